@@ -0,0 +1,297 @@
+package brokerstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type AuditOperation string
+
+const (
+	AuditCreate        AuditOperation = "create"
+	AuditUpdate        AuditOperation = "update"
+	AuditDelete        AuditOperation = "delete"
+	AuditConflictCheck AuditOperation = "conflict-check"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess    AuditOutcome = "success"
+	AuditFailure    AuditOutcome = "failure"
+	AuditConflict   AuditOutcome = "conflict"
+	AuditNoConflict AuditOutcome = "no-conflict"
+)
+
+type AuditEntry struct {
+	Timestamp        time.Time      `json:"timestamp"`
+	Operation        AuditOperation `json:"operation"`
+	Kind             RecordKind     `json:"kind"`
+	ID               string         `json:"id"`
+	OrganizationGUID string         `json:"organization_guid,omitempty"`
+	SpaceGUID        string         `json:"space_guid,omitempty"`
+	Outcome          AuditOutcome   `json:"outcome"`
+	Error            string         `json:"error,omitempty"`
+	Actor            string         `json:"actor,omitempty"`
+}
+
+//counterfeiter:generate -o ./brokerstorefakes/fake_audit_sink.go . AuditSink
+type AuditSink interface {
+	Record(entry AuditEntry) error
+}
+
+// AuditingStore records every create, delete and conflict decision made
+// through it to an AuditSink. Use WithActor to attribute entries to the
+// caller on whose behalf the store is being used.
+type AuditingStore struct {
+	logger lager.Logger
+	store  Store
+	sink   AuditSink
+	actor  string
+}
+
+func NewAuditingStore(logger lager.Logger, store Store, sink AuditSink, actor string) *AuditingStore {
+	return &AuditingStore{
+		logger: logger,
+		store:  store,
+		sink:   sink,
+		actor:  actor,
+	}
+}
+
+// WithActor returns a store sharing the same backend and sink whose entries
+// are attributed to actor.
+func (s *AuditingStore) WithActor(actor string) *AuditingStore {
+	withActor := *s
+	withActor.actor = actor
+	return &withActor
+}
+
+func (s *AuditingStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *AuditingStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *AuditingStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *AuditingStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	return s.store.RetrieveInstanceDetails(id)
+}
+
+func (s *AuditingStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	return s.store.RetrieveBindingDetails(id)
+}
+
+func (s *AuditingStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	return s.store.RetrieveAllInstanceDetails()
+}
+
+func (s *AuditingStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	return s.store.RetrieveAllBindingDetails()
+}
+
+func (s *AuditingStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	err := s.store.CreateInstanceDetails(id, details)
+	s.record(instanceAuditEntry(AuditCreate, id, details), err)
+	return err
+}
+
+func (s *AuditingStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	err := s.store.CreateBindingDetails(id, details)
+	s.record(bindingAuditEntry(AuditCreate, id, details), err)
+	return err
+}
+
+func (s *AuditingStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	err := s.store.UpdateInstanceDetails(id, details)
+	// The record is read back only so the entry can carry its org and space
+	updated, retrieveErr := s.store.RetrieveInstanceDetails(id)
+	if retrieveErr != nil {
+		s.logger.Error("failed-to-read-updated-instance", retrieveErr, recordData(InstanceRecord, id))
+	}
+	s.record(instanceAuditEntry(AuditUpdate, id, updated), err)
+	return err
+}
+
+func (s *AuditingStore) DeleteInstanceDetails(id string) error {
+	// The record is read first only so the entry can carry its org and space
+	details, _ := s.store.RetrieveInstanceDetails(id)
+	err := s.store.DeleteInstanceDetails(id)
+	s.record(instanceAuditEntry(AuditDelete, id, details), err)
+	return err
+}
+
+func (s *AuditingStore) DeleteBindingDetails(id string) error {
+	details, _ := s.store.RetrieveBindingDetails(id)
+	err := s.store.DeleteBindingDetails(id)
+	s.record(bindingAuditEntry(AuditDelete, id, details), err)
+	return err
+}
+
+func (s *AuditingStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	conflict := s.store.IsInstanceConflict(id, details)
+	entry := instanceAuditEntry(AuditConflictCheck, id, details)
+	entry.Outcome = conflictOutcome(conflict)
+	s.record(entry, nil)
+	return conflict
+}
+
+func (s *AuditingStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	conflict := s.store.IsBindingConflict(id, details)
+	entry := bindingAuditEntry(AuditConflictCheck, id, details)
+	entry.Outcome = conflictOutcome(conflict)
+	s.record(entry, nil)
+	return conflict
+}
+
+func (s *AuditingStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *AuditingStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *AuditingStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// record fills in the common fields and writes the entry. A failing sink is
+// logged but never fails the store operation itself.
+func (s *AuditingStore) record(entry AuditEntry, opErr error) {
+	entry.Timestamp = time.Now().UTC()
+	entry.Actor = s.actor
+	if entry.Outcome == "" {
+		entry.Outcome = AuditSuccess
+	}
+	if opErr != nil {
+		entry.Outcome = AuditFailure
+		entry.Error = opErr.Error()
+	}
+
+	if err := s.sink.Record(entry); err != nil {
+		s.logger.Error("failed-to-record-audit-entry", err, lager.Data{
+			"operation": entry.Operation,
+			"kind":      entry.Kind,
+			"id":        entry.ID,
+		})
+	}
+}
+
+func instanceAuditEntry(operation AuditOperation, id string, details ServiceInstance) AuditEntry {
+	return AuditEntry{
+		Operation:        operation,
+		Kind:             InstanceRecord,
+		ID:               id,
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+	}
+}
+
+func bindingAuditEntry(operation AuditOperation, id string, details domain.BindDetails) AuditEntry {
+	return AuditEntry{
+		Operation: operation,
+		Kind:      BindingRecord,
+		ID:        id,
+		SpaceGUID: bindingSpaceGUID(details),
+	}
+}
+
+func conflictOutcome(conflict bool) AuditOutcome {
+	if conflict {
+		return AuditConflict
+	}
+	return AuditNoConflict
+}
+
+// LagerAuditSink writes each entry as an info line on the given logger
+type LagerAuditSink struct {
+	logger lager.Logger
+}
+
+func NewLagerAuditSink(logger lager.Logger) *LagerAuditSink {
+	return &LagerAuditSink{logger: logger.Session("audit")}
+}
+
+func (s *LagerAuditSink) Record(entry AuditEntry) error {
+	s.logger.Info(string(entry.Operation), lager.Data{
+		"timestamp":         entry.Timestamp,
+		"kind":              entry.Kind,
+		"id":                entry.ID,
+		"organization_guid": entry.OrganizationGUID,
+		"space_guid":        entry.SpaceGUID,
+		"outcome":           entry.Outcome,
+		"error":             entry.Error,
+		"actor":             entry.Actor,
+	})
+	return nil
+}
+
+// JSONLinesAuditSink writes each entry as one line of JSON
+type JSONLinesAuditSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewJSONLinesAuditSink(writer io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{writer: writer}
+}
+
+// NewJSONLinesAuditFileSink appends entries to the file at path, creating it
+// if necessary. The caller owns the returned file and should close it.
+func NewJSONLinesAuditFileSink(path string) (*JSONLinesAuditSink, *os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewJSONLinesAuditSink(file), file, nil
+}
+
+func (s *JSONLinesAuditSink) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+// MemoryAuditSink keeps entries in memory, intended for tests
+type MemoryAuditSink struct {
+	mutex   sync.Mutex
+	entries []AuditEntry
+}
+
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+func (s *MemoryAuditSink) Record(entry AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *MemoryAuditSink) Entries() []AuditEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]AuditEntry(nil), s.entries...)
+}
@@ -0,0 +1,154 @@
+package brokerstore_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("AuditingStore", func() {
+	var (
+		logger    *lagertest.TestLogger
+		fakeStore *brokerstorefakes.FakeStore
+		sink      *MemoryAuditSink
+		store     *AuditingStore
+		err       error
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("auditing-store")
+		fakeStore = &brokerstorefakes.FakeStore{}
+		sink = NewMemoryAuditSink()
+		store = NewAuditingStore(logger, fakeStore, sink, "some-broker")
+	})
+
+	Context("#CreateInstanceDetails", func() {
+		JustBeforeEach(func() {
+			err = store.WithActor("some-user").CreateInstanceDetails("instance-1", ServiceInstance{
+				OrganizationGUID: "org-guid",
+				SpaceGUID:        "space-guid",
+			})
+		})
+
+		It("should record a successful create", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+
+			entries := sink.Entries()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Timestamp).NotTo(BeZero())
+			Expect(entries[0]).To(Equal(AuditEntry{
+				Timestamp:        entries[0].Timestamp,
+				Operation:        AuditCreate,
+				Kind:             InstanceRecord,
+				ID:               "instance-1",
+				OrganizationGUID: "org-guid",
+				SpaceGUID:        "space-guid",
+				Outcome:          AuditSuccess,
+				Actor:            "some-user",
+			}))
+		})
+
+		Context("when the wrapped store fails", func() {
+			BeforeEach(func() {
+				fakeStore.CreateInstanceDetailsReturns(errors.New("bad-create"))
+			})
+
+			It("should record the failure and return the error", func() {
+				Expect(err).To(MatchError("bad-create"))
+				Expect(sink.Entries()[0].Outcome).To(Equal(AuditFailure))
+				Expect(sink.Entries()[0].Error).To(Equal("bad-create"))
+			})
+		})
+	})
+
+	Context("#DeleteBindingDetails", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveBindingDetailsReturns(domain.BindDetails{
+				BindResource: &domain.BindResource{SpaceGuid: "space-guid"},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = store.DeleteBindingDetails("binding-1")
+		})
+
+		It("should record the delete with the binding's space", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.DeleteBindingDetailsArgsForCall(0)).To(Equal("binding-1"))
+
+			entry := sink.Entries()[0]
+			Expect(entry.Operation).To(Equal(AuditDelete))
+			Expect(entry.Kind).To(Equal(BindingRecord))
+			Expect(entry.SpaceGUID).To(Equal("space-guid"))
+			Expect(entry.Actor).To(Equal("some-broker"))
+		})
+	})
+
+	Context("#IsInstanceConflict", func() {
+		BeforeEach(func() {
+			fakeStore.IsInstanceConflictReturns(true)
+		})
+
+		It("should record the decision", func() {
+			Expect(store.IsInstanceConflict("instance-1", ServiceInstance{})).To(BeTrue())
+			Expect(sink.Entries()[0].Operation).To(Equal(AuditConflictCheck))
+			Expect(sink.Entries()[0].Outcome).To(Equal(AuditConflict))
+		})
+	})
+
+	Context("#RetrieveInstanceDetails", func() {
+		It("should not record reads", func() {
+			_, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Entries()).To(BeEmpty())
+		})
+	})
+
+	Context("when the sink fails", func() {
+		BeforeEach(func() {
+			fakeSink := &brokerstorefakes.FakeAuditSink{}
+			fakeSink.RecordReturns(errors.New("bad-sink"))
+			store = NewAuditingStore(logger, fakeStore, fakeSink, "some-broker")
+		})
+
+		It("should log but not fail the operation", func() {
+			Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+			Expect(logger).To(gbytes.Say("failed-to-record-audit-entry"))
+		})
+	})
+
+	Context("JSONLinesAuditSink", func() {
+		It("should write one JSON object per line", func() {
+			buffer := &bytes.Buffer{}
+			store = NewAuditingStore(logger, fakeStore, NewJSONLinesAuditSink(buffer), "some-broker")
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+			Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+			var entry map[string]interface{}
+			Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("operation", "delete"))
+			Expect(entry).To(HaveKeyWithValue("id", "instance-1"))
+			Expect(entry).To(HaveKeyWithValue("actor", "some-broker"))
+		})
+	})
+
+	Context("LagerAuditSink", func() {
+		It("should log each entry", func() {
+			store = NewAuditingStore(logger, fakeStore, NewLagerAuditSink(logger), "some-broker")
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+			Expect(logger).To(gbytes.Say(`audit.create.*"id":"instance-1"`))
+		})
+	})
+})
@@ -0,0 +1,208 @@
+package brokerstore
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type BatchOperationType string
+
+const (
+	BatchCreate BatchOperationType = "create"
+	BatchDelete BatchOperationType = "delete"
+)
+
+// BatchOperation is a single create or delete in a Batch. Instance is set for
+// instance creates and Binding for binding creates.
+type BatchOperation struct {
+	Type     BatchOperationType
+	Kind     RecordKind
+	ID       string
+	Instance ServiceInstance
+	Binding  domain.BindDetails
+}
+
+func (o BatchOperation) String() string {
+	return fmt.Sprintf("%s %s %s", o.Type, o.Kind, o.ID)
+}
+
+// Batch collects creates and deletes across instances and bindings to be
+// applied together by ApplyBatch, in the order they were added.
+//
+// Without a TransactionalStore a rolled back record is rewritten from what
+// the Store interface returns, so data kept only by the backend is lost. A
+// CredhubStore binding loses its expiry, and a deleted record that is put
+// back gets a new created_at.
+type Batch struct {
+	operations []BatchOperation
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) CreateInstanceDetails(id string, details ServiceInstance) *Batch {
+	b.operations = append(b.operations, BatchOperation{Type: BatchCreate, Kind: InstanceRecord, ID: id, Instance: details})
+	return b
+}
+
+func (b *Batch) CreateBindingDetails(id string, details domain.BindDetails) *Batch {
+	b.operations = append(b.operations, BatchOperation{Type: BatchCreate, Kind: BindingRecord, ID: id, Binding: details})
+	return b
+}
+
+func (b *Batch) DeleteInstanceDetails(id string) *Batch {
+	b.operations = append(b.operations, BatchOperation{Type: BatchDelete, Kind: InstanceRecord, ID: id})
+	return b
+}
+
+func (b *Batch) DeleteBindingDetails(id string) *Batch {
+	b.operations = append(b.operations, BatchOperation{Type: BatchDelete, Kind: BindingRecord, ID: id})
+	return b
+}
+
+func (b *Batch) Operations() []BatchOperation {
+	return append([]BatchOperation(nil), b.operations...)
+}
+
+// BatchStep reports what happened to one operation of a batch
+type BatchStep struct {
+	Operation   BatchOperation
+	Applied     bool
+	Err         error
+	RolledBack  bool
+	RollbackErr error
+}
+
+// BatchReport describes how a batch was applied. When Committed is false the
+// steps show which operations were applied and whether each was undone.
+type BatchReport struct {
+	Atomic    bool
+	Committed bool
+	Steps     []BatchStep
+}
+
+// RollbackFailures returns the steps that were applied but could not be
+// undone, i.e. the records left changed by a failed batch.
+func (r BatchReport) RollbackFailures() []BatchStep {
+	var failures []BatchStep
+	for _, step := range r.Steps {
+		if step.Applied && !step.RolledBack {
+			failures = append(failures, step)
+		}
+	}
+	return failures
+}
+
+// TransactionalStore is implemented by stores that can apply a batch
+// atomically. ApplyBatch uses it when available.
+type TransactionalStore interface {
+	ApplyBatch(batch *Batch) (BatchReport, error)
+}
+
+// ApplyBatch applies every operation in batch to store. Stores implementing
+// TransactionalStore apply it atomically. Anything else, including CredHub,
+// gets a best-effort transaction: each record's previous value is read before
+// it is changed, and if an operation fails the operations already applied are
+// compensated in reverse order. The report says which steps were applied and
+// undone; a batch that could not be fully undone returns both the failure
+// and the rollback errors.
+func ApplyBatch(logger lager.Logger, store Store, batch *Batch) (BatchReport, error) {
+	if transactional, ok := store.(TransactionalStore); ok {
+		return transactional.ApplyBatch(batch)
+	}
+
+	logger = logger.Session("apply-batch", lager.Data{"operations": len(batch.operations)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := BatchReport{Steps: make([]BatchStep, len(batch.operations))}
+	previous := make([]*BatchOperation, len(batch.operations))
+
+	for i, operation := range batch.operations {
+		report.Steps[i].Operation = operation
+
+		before, err := snapshot(store, operation)
+		if err == nil {
+			previous[i] = before
+			err = apply(store, operation)
+		}
+		if err != nil {
+			report.Steps[i].Err = err
+			logger.Error("failed-to-apply-operation", err, lager.Data{"step": i, "operation": operation.String()})
+
+			rollbackErr := rollback(logger, store, report.Steps[:i], previous[:i])
+			failure := fmt.Errorf("batch step %d (%s): %w", i, operation, err)
+			if rollbackErr != nil {
+				return report, errors.Join(failure, rollbackErr)
+			}
+			return report, failure
+		}
+		report.Steps[i].Applied = true
+	}
+
+	report.Committed = true
+	return report, nil
+}
+
+// snapshot returns the operation that would restore the record to its current
+// state: a create with its current value, or a delete if it does not exist.
+func snapshot(store Store, operation BatchOperation) (*BatchOperation, error) {
+	restore := &BatchOperation{Type: BatchCreate, Kind: operation.Kind, ID: operation.ID}
+
+	var err error
+	if operation.Kind == InstanceRecord {
+		restore.Instance, err = store.RetrieveInstanceDetails(operation.ID)
+	} else {
+		restore.Binding, err = store.RetrieveBindingDetails(operation.ID)
+	}
+
+	switch {
+	case err == nil:
+		return restore, nil
+	case isNotFound(err):
+		if operation.Type == BatchDelete {
+			// Deleting a record that does not exist changes nothing to undo
+			return nil, nil
+		}
+		restore.Type = BatchDelete
+		return restore, nil
+	default:
+		return nil, err
+	}
+}
+
+func apply(store Store, operation BatchOperation) error {
+	switch {
+	case operation.Type == BatchCreate && operation.Kind == InstanceRecord:
+		return store.CreateInstanceDetails(operation.ID, operation.Instance)
+	case operation.Type == BatchCreate:
+		return store.CreateBindingDetails(operation.ID, operation.Binding)
+	case operation.Kind == InstanceRecord:
+		return store.DeleteInstanceDetails(operation.ID)
+	default:
+		return store.DeleteBindingDetails(operation.ID)
+	}
+}
+
+func rollback(logger lager.Logger, store Store, steps []BatchStep, previous []*BatchOperation) error {
+	var rollbackErrs []error
+	for i := len(steps) - 1; i >= 0; i-- {
+		if previous[i] == nil {
+			steps[i].RolledBack = true
+			continue
+		}
+
+		if err := apply(store, *previous[i]); err != nil && !(previous[i].Type == BatchDelete && isNotFound(err)) {
+			logger.Error("failed-to-roll-back-operation", err, lager.Data{"step": i, "operation": steps[i].Operation.String()})
+			steps[i].RollbackErr = err
+			rollbackErrs = append(rollbackErrs, fmt.Errorf("rolling back batch step %d (%s): %w", i, steps[i].Operation, err))
+			continue
+		}
+		steps[i].RolledBack = true
+	}
+	return errors.Join(rollbackErrs...)
+}
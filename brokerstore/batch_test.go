@@ -0,0 +1,165 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeTransactionalStore struct {
+	*brokerstorefakes.FakeStore
+	applied *Batch
+}
+
+func (s *fakeTransactionalStore) ApplyBatch(batch *Batch) (BatchReport, error) {
+	s.applied = batch
+	return BatchReport{Atomic: true, Committed: true}, nil
+}
+
+var _ = Describe("ApplyBatch", func() {
+	var (
+		logger      *lagertest.TestLogger
+		fakeStore   *brokerstorefakes.FakeStore
+		instances   map[string]ServiceInstance
+		bindings    map[string]domain.BindDetails
+		oldInstance ServiceInstance
+		newInstance ServiceInstance
+		oldBinding  domain.BindDetails
+		newBinding  domain.BindDetails
+		batch       *Batch
+		report      BatchReport
+		err         error
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("batch")
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, bindings = backStoreWithMemory(fakeStore)
+
+		oldInstance = ServiceInstance{ServiceID: "service-id", PlanID: "old-plan", OrganizationGUID: "org-guid", SpaceGUID: "space-guid"}
+		newInstance = oldInstance
+		newInstance.PlanID = "new-plan"
+		oldBinding = domain.BindDetails{AppGUID: "app-guid", PlanID: "old-plan", ServiceID: "service-id"}
+		newBinding = oldBinding
+		newBinding.PlanID = "new-plan"
+
+		Expect(fakeStore.CreateInstanceDetails("instance-1", oldInstance)).To(Succeed())
+		Expect(fakeStore.CreateBindingDetails("binding-1", oldBinding)).To(Succeed())
+		Expect(fakeStore.CreateBindingDetails("binding-2", oldBinding)).To(Succeed())
+
+		batch = NewBatch().
+			CreateInstanceDetails("instance-1", newInstance).
+			CreateBindingDetails("binding-1", newBinding).
+			DeleteBindingDetails("binding-2").
+			CreateBindingDetails("binding-3", newBinding)
+	})
+
+	JustBeforeEach(func() {
+		report, err = ApplyBatch(logger, fakeStore, batch)
+	})
+
+	It("should apply every operation in order", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Committed).To(BeTrue())
+		Expect(report.Atomic).To(BeFalse())
+		Expect(report.Steps).To(HaveLen(4))
+		for _, step := range report.Steps {
+			Expect(step.Applied).To(BeTrue())
+		}
+
+		Expect(instances["instance-1"]).To(Equal(newInstance))
+		Expect(bindings).To(Equal(map[string]domain.BindDetails{
+			"binding-1": newBinding,
+			"binding-3": newBinding,
+		}))
+	})
+
+	Context("when an operation fails part way through", func() {
+		BeforeEach(func() {
+			createBinding := fakeStore.CreateBindingDetailsStub
+			fakeStore.CreateBindingDetailsStub = func(id string, details domain.BindDetails) error {
+				if id == "binding-3" {
+					return errors.New("bad-create")
+				}
+				return createBinding(id, details)
+			}
+		})
+
+		It("should compensate the applied operations and report them", func() {
+			Expect(err).To(MatchError(ContainSubstring("batch step 3 (create binding binding-3): bad-create")))
+			Expect(report.Committed).To(BeFalse())
+			Expect(report.Steps[3].Applied).To(BeFalse())
+			Expect(report.Steps[3].Err).To(MatchError("bad-create"))
+			for _, step := range report.Steps[:3] {
+				Expect(step.Applied).To(BeTrue())
+				Expect(step.RolledBack).To(BeTrue())
+			}
+			Expect(report.RollbackFailures()).To(BeEmpty())
+
+			Expect(instances["instance-1"]).To(Equal(oldInstance))
+			Expect(bindings).To(Equal(map[string]domain.BindDetails{
+				"binding-1": oldBinding,
+				"binding-2": oldBinding,
+			}))
+		})
+
+		Context("and a compensation fails too", func() {
+			BeforeEach(func() {
+				createInstance := fakeStore.CreateInstanceDetailsStub
+				calls := 0
+				fakeStore.CreateInstanceDetailsStub = func(id string, details ServiceInstance) error {
+					calls++
+					if calls > 1 {
+						return errors.New("bad-restore")
+					}
+					return createInstance(id, details)
+				}
+			})
+
+			It("should report what was left changed", func() {
+				Expect(err).To(MatchError(ContainSubstring("bad-create")))
+				Expect(err).To(MatchError(ContainSubstring("rolling back batch step 0 (create instance instance-1): bad-restore")))
+
+				failures := report.RollbackFailures()
+				Expect(failures).To(HaveLen(1))
+				Expect(failures[0].Operation.ID).To(Equal("instance-1"))
+				Expect(failures[0].RollbackErr).To(MatchError("bad-restore"))
+				Expect(bindings["binding-1"]).To(Equal(oldBinding))
+			})
+		})
+	})
+
+	Context("when the current value of a record cannot be read", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveBindingDetailsReturns(domain.BindDetails{}, errors.New("bad-retrieve"))
+		})
+
+		It("should not change that record and roll back the rest", func() {
+			Expect(err).To(MatchError(ContainSubstring("bad-retrieve")))
+			Expect(report.Steps[0].RolledBack).To(BeTrue())
+			Expect(instances["instance-1"]).To(Equal(oldInstance))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when the store is transactional", func() {
+		var transactionalStore *fakeTransactionalStore
+
+		JustBeforeEach(func() {
+			transactionalStore = &fakeTransactionalStore{FakeStore: &brokerstorefakes.FakeStore{}}
+			report, err = ApplyBatch(logger, transactionalStore, batch)
+		})
+
+		It("should hand the whole batch to the store", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Atomic).To(BeTrue())
+			Expect(transactionalStore.applied.Operations()).To(HaveLen(4))
+			Expect(transactionalStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,232 @@
+package brokerstore
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+)
+
+// CredhubRefKey is the key Cloud Foundry looks for in binding credentials to
+// resolve them from CredHub on the app's behalf.
+const CredhubRefKey = "credhub-ref"
+
+// CredhubRef wraps a credential name as binding credentials for
+// domain.Binding.Credentials.
+func CredhubRef(name string) map[string]interface{} {
+	return map[string]interface{}{CredhubRefKey: name}
+}
+
+// AppActor is the CredHub actor an app authenticates as with its instance
+// identity certificate.
+func AppActor(appGUID string) string {
+	return fmt.Sprintf("mtls-app:%s", appGUID)
+}
+
+// CreateBindingCredentials stores credentials for a binding in CredHub and
+// grants the bound app read access to them. It returns the credential name
+// to hand back as a credhub-ref. A retried bind finds the grant already in
+// place. If the grant fails, credentials written by this call are removed
+// again; credentials from an earlier bind are left for the app.
+func (s *CredhubStore) CreateBindingCredentials(bindingID, appGUID string, creds map[string]interface{}) (string, error) {
+	logger := s.logger.Session("create-binding-credentials", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := s.requireFeature(credhub_shims.FeaturePermissionsV2); err != nil {
+		return "", err
+	}
+
+	name := s.bindingCredentialsName(bindingID)
+	_, err := s.credhubShim.GetLatestJSON(name)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	created := err != nil
+
+	if _, err := s.credhubShim.SetJSON(name, values.JSON(creds)); err != nil {
+		return "", err
+	}
+
+	if err := s.grantAppRead(name, appGUID); err != nil {
+		logger.Error("failed-to-grant-read", err, lager.Data{"app-guid": appGUID})
+		if created {
+			if deleteErr := s.credhubShim.Delete(name); deleteErr != nil {
+				logger.Error("failed-to-remove-ungranted-credentials", deleteErr)
+			}
+		}
+		return "", err
+	}
+
+	return name, nil
+}
+
+// grantAppRead grants the app read access to name unless it already has it
+func (s *CredhubStore) grantAppRead(name, appGUID string) error {
+	permission, err := s.credhubShim.GetPermissionByPathActor(name, AppActor(appGUID))
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil && permission != nil && permission.UUID != "" {
+		return nil
+	}
+
+	_, err = s.credhubShim.AddPermission(name, AppActor(appGUID), []string{"read"})
+	return err
+}
+
+// DeleteBindingCredentials revokes the app's read access to the binding's
+// credentials and deletes them. Either being already gone is not an error.
+func (s *CredhubStore) DeleteBindingCredentials(bindingID, appGUID string) error {
+	logger := s.logger.Session("delete-binding-credentials", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := s.requireFeature(credhub_shims.FeaturePermissionsV2); err != nil {
+		return err
+	}
+
+	name := s.bindingCredentialsName(bindingID)
+
+	permission, err := s.credhubShim.GetPermissionByPathActor(name, AppActor(appGUID))
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil && permission != nil && permission.UUID != "" {
+		if _, err := s.credhubShim.DeletePermission(permission.UUID); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+
+	if err := s.credhubShim.Delete(name); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteAllBindingCredentials deletes every credential stored under the
+// binding, revoking the bound app's access to the ones written by
+// CreateBindingCredentials.
+func (s *CredhubStore) deleteAllBindingCredentials(logger lager.Logger, bindingID string) error {
+	results, err := s.credhubShim.FindByPath(s.namespaced(bindingID) + "/")
+	if err != nil {
+		return err
+	}
+	if len(results.Credentials) == 0 {
+		return nil
+	}
+
+	details, err := s.RetrieveBindingDetails(bindingID)
+	if err != nil && !isNotFound(err) {
+		logger.Error("failed-to-read-bound-app", err)
+	}
+
+	for _, result := range results.Credentials {
+		if result.Name == s.bindingCredentialsName(bindingID) && details.AppGUID != "" {
+			err = s.DeleteBindingCredentials(bindingID, details.AppGUID)
+		} else if err = s.credhubShim.Delete(result.Name); isNotFound(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateBindingPassword has CredHub generate a password named name under the
+// binding's namespace. An existing password is returned unchanged, so a
+// retried bind sees the same value; use RegenerateBindingCredential to rotate.
+func (s *CredhubStore) GenerateBindingPassword(bindingID, name string, gen generate.Password) (credentials.Password, error) {
+	logger := s.logger.Session("generate-binding-password", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	credentialName, err := s.bindingCredentialName(bindingID, name)
+	if err != nil {
+		return credentials.Password{}, err
+	}
+	return s.credhubShim.GeneratePassword(credentialName, gen, credhub.NoOverwrite)
+}
+
+// GenerateBindingUser has CredHub generate a user named name under the
+// binding's namespace. gen.Username is generated too when left empty.
+func (s *CredhubStore) GenerateBindingUser(bindingID, name string, gen generate.User) (credentials.User, error) {
+	logger := s.logger.Session("generate-binding-user", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	credentialName, err := s.bindingCredentialName(bindingID, name)
+	if err != nil {
+		return credentials.User{}, err
+	}
+	return s.credhubShim.GenerateUser(credentialName, gen, credhub.NoOverwrite)
+}
+
+// GenerateBindingCertificate has CredHub generate a certificate named name
+// under the binding's namespace, signed by gen.Ca unless gen.SelfSign is set.
+func (s *CredhubStore) GenerateBindingCertificate(bindingID, name string, gen generate.Certificate) (credentials.Certificate, error) {
+	logger := s.logger.Session("generate-binding-certificate", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	credentialName, err := s.bindingCredentialName(bindingID, name)
+	if err != nil {
+		return credentials.Certificate{}, err
+	}
+	return s.credhubShim.GenerateCertificate(credentialName, gen, credhub.NoOverwrite)
+}
+
+// RegenerateBindingCredential rotates a credential previously generated for
+// the binding, reusing the parameters it was generated with.
+func (s *CredhubStore) RegenerateBindingCredential(bindingID, name string) (credentials.Credential, error) {
+	logger := s.logger.Session("regenerate-binding-credential", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	credentialName, err := s.bindingCredentialName(bindingID, name)
+	if err != nil {
+		return credentials.Credential{}, err
+	}
+	return s.credhubShim.Regenerate(credentialName)
+}
+
+// DeleteBindingCredential deletes a credential generated for the binding. A
+// credential that is already gone is not an error.
+func (s *CredhubStore) DeleteBindingCredential(bindingID, name string) error {
+	logger := s.logger.Session("delete-binding-credential", recordData(BindingRecord, bindingID))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	credentialName, err := s.bindingCredentialName(bindingID, name)
+	if err != nil {
+		return err
+	}
+	if err := s.credhubShim.Delete(credentialName); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// bindingCredentialsSegment names the credentials written by
+// CreateBindingCredentials, so it is reserved from generated credentials.
+const bindingCredentialsSegment = "credentials"
+
+func (s *CredhubStore) bindingCredentialsName(bindingID string) string {
+	return s.namespaced(bindingID + "/" + bindingCredentialsSegment)
+}
+
+// bindingCredentialName names a credential under the binding's namespace.
+// name must be a single path segment so it cannot escape the binding, and
+// must not be the reserved credentials name.
+func (s *CredhubStore) bindingCredentialName(bindingID, name string) (string, error) {
+	if name == "" || name == bindingCredentialsSegment || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid binding credential name %q", name)
+	}
+	return s.namespaced(bindingID + "/" + name), nil
+}
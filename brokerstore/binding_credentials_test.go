@@ -0,0 +1,277 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BindingCredentials", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		store       *CredhubStore
+		err         error
+	)
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.12.0")), nil)
+		store = NewCredhubStore(lagertest.NewTestLogger("binding-credentials"), fakeCredhub, "some-store-id")
+	})
+
+	Context("#CreateBindingCredentials", func() {
+		var ref string
+
+		BeforeEach(func() {
+			fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, &credhub.NotFoundError{})
+		})
+
+		JustBeforeEach(func() {
+			ref, err = store.CreateBindingCredentials("binding-1", "app-guid", map[string]interface{}{"password": "a-password"})
+		})
+
+		It("should write the credentials under the binding and grant the app read access", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal("/some-store-id/binding-1/credentials"))
+			Expect(CredhubRef(ref)).To(Equal(map[string]interface{}{"credhub-ref": "/some-store-id/binding-1/credentials"}))
+
+			name, value := fakeCredhub.SetJSONArgsForCall(0)
+			Expect(name).To(Equal("/some-store-id/binding-1/credentials"))
+			Expect(value).To(Equal(values.JSON{"password": "a-password"}))
+
+			path, actor, ops := fakeCredhub.AddPermissionArgsForCall(0)
+			Expect(path).To(Equal("/some-store-id/binding-1/credentials"))
+			Expect(actor).To(Equal("mtls-app:app-guid"))
+			Expect(ops).To(Equal([]string{"read"}))
+		})
+
+		Context("when the grant fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.AddPermissionReturns(nil, errors.New("bad-add-permission"))
+			})
+
+			It("should remove the credentials and return the error", func() {
+				Expect(err).To(MatchError("bad-add-permission"))
+				Expect(fakeCredhub.DeleteCallCount()).To(Equal(1))
+				Expect(fakeCredhub.DeleteArgsForCall(0)).To(Equal("/some-store-id/binding-1/credentials"))
+			})
+		})
+
+		Context("when the write fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.SetJSONReturns(credentials.JSON{}, errors.New("bad-set-json"))
+			})
+
+			It("should not grant anything", func() {
+				Expect(err).To(MatchError("bad-set-json"))
+				Expect(fakeCredhub.AddPermissionCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the bind is retried", func() {
+			BeforeEach(func() {
+				fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, nil)
+				fakeCredhub.GetPermissionByPathActorReturns(&permissions.Permission{UUID: "permission-uuid"}, nil)
+			})
+
+			It("should keep the existing grant", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ref).To(Equal("/some-store-id/binding-1/credentials"))
+				Expect(fakeCredhub.AddPermissionCallCount()).To(Equal(0))
+			})
+
+			Context("and the grant fails", func() {
+				BeforeEach(func() {
+					fakeCredhub.GetPermissionByPathActorReturns(nil, &credhub.NotFoundError{})
+					fakeCredhub.AddPermissionReturns(nil, errors.New("bad-add-permission"))
+				})
+
+				It("should leave the earlier credentials in place", func() {
+					Expect(err).To(MatchError("bad-add-permission"))
+					Expect(fakeCredhub.DeleteCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when checking for existing credentials fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, errors.New("credhub-down"))
+			})
+
+			It("should not write anything", func() {
+				Expect(err).To(MatchError("credhub-down"))
+				Expect(fakeCredhub.SetJSONCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the server does not support v2 permissions", func() {
+			BeforeEach(func() {
+				fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("1.9.0")), nil)
+			})
+
+			It("should return an UnsupportedFeatureError without writing", func() {
+				var unsupportedErr *credhub_shims.UnsupportedFeatureError
+				Expect(errors.As(err, &unsupportedErr)).To(BeTrue())
+				Expect(fakeCredhub.SetJSONCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("#DeleteBindingCredentials", func() {
+		BeforeEach(func() {
+			fakeCredhub.GetPermissionByPathActorReturns(&permissions.Permission{UUID: "permission-uuid"}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = store.DeleteBindingCredentials("binding-1", "app-guid")
+		})
+
+		It("should revoke the app's access and delete the credentials", func() {
+			Expect(err).NotTo(HaveOccurred())
+			path, actor := fakeCredhub.GetPermissionByPathActorArgsForCall(0)
+			Expect(path).To(Equal("/some-store-id/binding-1/credentials"))
+			Expect(actor).To(Equal("mtls-app:app-guid"))
+			Expect(fakeCredhub.DeletePermissionArgsForCall(0)).To(Equal("permission-uuid"))
+			Expect(fakeCredhub.DeleteArgsForCall(0)).To(Equal("/some-store-id/binding-1/credentials"))
+		})
+
+		Context("when the permission and credentials are already gone", func() {
+			BeforeEach(func() {
+				fakeCredhub.GetPermissionByPathActorReturns(nil, &credhub.NotFoundError{})
+				fakeCredhub.DeleteReturns(&credhub.NotFoundError{})
+			})
+
+			It("should succeed", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCredhub.DeletePermissionCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when revoking fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.DeletePermissionReturns(nil, errors.New("bad-delete-permission"))
+			})
+
+			It("should keep the credentials and return the error", func() {
+				Expect(err).To(MatchError("bad-delete-permission"))
+				Expect(fakeCredhub.DeleteCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("#DeleteBindingDetails", func() {
+		var records map[string]values.JSON
+
+		BeforeEach(func() {
+			records = map[string]values.JSON{
+				"/some-store-id/binding-1":             {"app_guid": "app-guid", "plan_id": "plan-id", "service_id": "service-id"},
+				"/some-store-id/binding-1/credentials": {"password": "a-password"},
+				"/some-store-id/binding-1/db-password": {"password": "generated"},
+				"/some-store-id/binding-10":            {"app_guid": "other-app-guid", "plan_id": "plan-id", "service_id": "service-id"},
+			}
+			backCredhubWith(fakeCredhub, records)
+			fakeCredhub.GetPermissionByPathActorReturns(&permissions.Permission{UUID: "permission-uuid"}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = store.DeleteBindingDetails("binding-1")
+		})
+
+		It("should revoke the bound app's access and delete the binding's credentials", func() {
+			Expect(err).NotTo(HaveOccurred())
+			path, actor := fakeCredhub.GetPermissionByPathActorArgsForCall(0)
+			Expect(path).To(Equal("/some-store-id/binding-1/credentials"))
+			Expect(actor).To(Equal("mtls-app:app-guid"))
+			Expect(fakeCredhub.DeletePermissionArgsForCall(0)).To(Equal("permission-uuid"))
+			Expect(records).To(ConsistOf(HaveKey("app_guid")))
+			Expect(records).To(HaveKey("/some-store-id/binding-10"))
+		})
+
+		Context("when revoking fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.DeletePermissionReturns(nil, errors.New("bad-delete-permission"))
+			})
+
+			It("should keep the binding so that unbind can be retried", func() {
+				Expect(err).To(MatchError("bad-delete-permission"))
+				Expect(records).To(HaveKey("/some-store-id/binding-1"))
+				Expect(records).To(HaveKey("/some-store-id/binding-1/credentials"))
+			})
+		})
+	})
+
+	Context("generated credentials", func() {
+		It("should generate a password under the binding without overwriting", func() {
+			fakeCredhub.GeneratePasswordReturns(credentials.Password{Value: "generated"}, nil)
+
+			password, err := store.GenerateBindingPassword("binding-1", "password", generate.Password{Length: 40})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password.Value).To(BeEquivalentTo("generated"))
+
+			name, gen, mode := fakeCredhub.GeneratePasswordArgsForCall(0)
+			Expect(name).To(Equal("/some-store-id/binding-1/password"))
+			Expect(gen).To(Equal(generate.Password{Length: 40}))
+			Expect(mode).To(Equal(credhub.NoOverwrite))
+		})
+
+		It("should generate a user under the binding", func() {
+			_, err := store.GenerateBindingUser("binding-1", "user", generate.User{Username: "some-user"})
+			Expect(err).NotTo(HaveOccurred())
+
+			name, gen, mode := fakeCredhub.GenerateUserArgsForCall(0)
+			Expect(name).To(Equal("/some-store-id/binding-1/user"))
+			Expect(gen.Username).To(Equal("some-user"))
+			Expect(mode).To(Equal(credhub.NoOverwrite))
+		})
+
+		It("should generate a certificate under the binding", func() {
+			_, err := store.GenerateBindingCertificate("binding-1", "tls", generate.Certificate{CommonName: "app", Ca: "/some-ca"})
+			Expect(err).NotTo(HaveOccurred())
+
+			name, gen, _ := fakeCredhub.GenerateCertificateArgsForCall(0)
+			Expect(name).To(Equal("/some-store-id/binding-1/tls"))
+			Expect(gen.Ca).To(Equal("/some-ca"))
+		})
+
+		It("should regenerate a credential under the binding", func() {
+			fakeCredhub.RegenerateReturns(credentials.Credential{Value: "rotated"}, nil)
+
+			credential, err := store.RegenerateBindingCredential("binding-1", "password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credential.Value).To(Equal("rotated"))
+			Expect(fakeCredhub.RegenerateArgsForCall(0)).To(Equal("/some-store-id/binding-1/password"))
+		})
+
+		It("should delete a generated credential, tolerating one already gone", func() {
+			fakeCredhub.DeleteReturns(&credhub.NotFoundError{})
+
+			Expect(store.DeleteBindingCredential("binding-1", "password")).To(Succeed())
+			Expect(fakeCredhub.DeleteArgsForCall(0)).To(Equal("/some-store-id/binding-1/password"))
+		})
+
+		It("should reject names that would escape the binding", func() {
+			_, err := store.GenerateBindingPassword("binding-1", "../other-binding/password", generate.Password{})
+			Expect(err).To(MatchError(ContainSubstring("invalid binding credential name")))
+			Expect(fakeCredhub.GeneratePasswordCallCount()).To(Equal(0))
+		})
+
+		It("should reject the name reserved for the binding's credentials", func() {
+			_, err := store.GenerateBindingPassword("binding-1", "credentials", generate.Password{})
+			Expect(err).To(MatchError(ContainSubstring("invalid binding credential name")))
+			Expect(store.DeleteBindingCredential("binding-1", "credentials")).To(MatchError(ContainSubstring("invalid binding credential name")))
+			Expect(fakeCredhub.GeneratePasswordCallCount()).To(Equal(0))
+			Expect(fakeCredhub.DeleteCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,166 @@
+package brokerstore
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const bindingExpiryKey = "binding_expiry"
+
+// BindingExpiry records when a binding's credentials were issued and when
+// they stop being valid. RenewBefore is when the platform should start
+// asking for new ones; it is zero when no renewal window was given.
+type BindingExpiry struct {
+	IssuedAt    time.Time `json:"issued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	RenewBefore time.Time `json:"renew_before"`
+}
+
+// Metadata renders the expiry as the binding metadata returned to the
+// platform from bind and fetch-binding requests.
+func (e BindingExpiry) Metadata() domain.BindingMetadata {
+	metadata := domain.BindingMetadata{ExpiresAt: e.ExpiresAt.UTC().Format(time.RFC3339)}
+	if !e.RenewBefore.IsZero() {
+		metadata.RenewBefore = e.RenewBefore.UTC().Format(time.RFC3339)
+	}
+	return metadata
+}
+
+// CredentialLifetime is how long issued credentials are valid for, and how
+// long before expiry they should be renewed.
+type CredentialLifetime struct {
+	TTL         time.Duration
+	RenewWindow time.Duration
+}
+
+// ExpiryFrom returns the expiry of credentials issued at issuedAt
+func (l CredentialLifetime) ExpiryFrom(issuedAt time.Time) BindingExpiry {
+	expiry := BindingExpiry{
+		IssuedAt:  issuedAt.UTC(),
+		ExpiresAt: issuedAt.Add(l.TTL).UTC(),
+	}
+	if l.RenewWindow > 0 {
+		expiry.RenewBefore = expiry.ExpiresAt.Add(-l.RenewWindow)
+	}
+	return expiry
+}
+
+// SetBindingExpiry stores expiry alongside the binding's record. The binding
+// must already exist; recreating it with CreateBindingDetails drops the expiry.
+func (s *CredhubStore) SetBindingExpiry(id string, expiry BindingExpiry) error {
+	logger := s.logger.Session("set-binding-expiry", recordData(BindingRecord, id))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	record, err := s.retrieveBindingRecord(id)
+	if err != nil {
+		return err
+	}
+
+	mappedExpiry, err := toMap(expiry)
+	if err != nil {
+		return err
+	}
+	updated := map[string]interface{}{}
+	for key, value := range record.Value.Value {
+		updated[key] = value
+	}
+	updated[bindingExpiryKey] = mappedExpiry
+
+	return s.writeRecord(BindingRecord, id, updated)
+}
+
+// RetrieveBindingExpiry returns the binding's expiry, and false if none has
+// been set.
+func (s *CredhubStore) RetrieveBindingExpiry(id string) (BindingExpiry, bool, error) {
+	logger := s.logger.Session("retrieve-binding-expiry", recordData(BindingRecord, id))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	record, err := s.retrieveBindingRecord(id)
+	if err != nil {
+		return BindingExpiry{}, false, err
+	}
+	return bindingExpiryOf(record.Value)
+}
+
+// retrieveBindingRecord reads id, treating a record of any other kind as not
+// found so that an instance never picks up a binding expiry.
+func (s *CredhubStore) retrieveBindingRecord(id string) (credhubRecord, error) {
+	record, err := s.retrieveRecord(id)
+	if err != nil {
+		return credhubRecord{}, err
+	}
+	if record.Kind != BindingRecord {
+		return credhubRecord{}, &credhub.NotFoundError{Description: fmt.Sprintf("%s %s is not a %s", record.Kind, id, BindingRecord)}
+	}
+	return record, nil
+}
+
+// RetrieveBindingsExpiringBefore returns the expiry of every binding whose
+// credentials expire before t. Bindings without an expiry never expire.
+func (s *CredhubStore) RetrieveBindingsExpiringBefore(t time.Time) (map[string]BindingExpiry, error) {
+	logger := s.logger.Session("retrieve-bindings-expiring-before", lager.Data{"kind": BindingRecord, "before": t})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiring := map[string]BindingExpiry{}
+	for id, record := range records {
+		if record.Err != nil || record.Kind != BindingRecord {
+			continue
+		}
+
+		expiry, ok, err := bindingExpiryOf(record.Value)
+		if err != nil {
+			logger.Error("failed-to-decode-binding-expiry", err, lager.Data{"id": id})
+			continue
+		}
+		if ok && expiry.ExpiresAt.Before(t) {
+			expiring[id] = expiry
+		}
+	}
+
+	return expiring, nil
+}
+
+// RotateBindingCredential regenerates the named credential generated for the
+// binding and restarts its lifetime from now. If the new expiry cannot be
+// stored the credential has still been rotated, and the error is returned
+// alongside it so the caller can retry SetBindingExpiry.
+func (s *CredhubStore) RotateBindingCredential(bindingID, name string, lifetime CredentialLifetime) (credentials.Credential, BindingExpiry, error) {
+	credential, err := s.RegenerateBindingCredential(bindingID, name)
+	if err != nil {
+		return credentials.Credential{}, BindingExpiry{}, err
+	}
+
+	expiry := lifetime.ExpiryFrom(time.Now())
+	if err := s.SetBindingExpiry(bindingID, expiry); err != nil {
+		s.logger.Error("failed-to-store-rotated-binding-expiry", err, recordData(BindingRecord, bindingID))
+		return credential, expiry, err
+	}
+	return credential, expiry, nil
+}
+
+func bindingExpiryOf(creds credentials.JSON) (BindingExpiry, bool, error) {
+	if _, ok := creds.Value[bindingExpiryKey]; !ok {
+		return BindingExpiry{}, false, nil
+	}
+
+	var record struct {
+		Expiry BindingExpiry `json:"binding_expiry"`
+	}
+	if err := toStruct(creds, &record); err != nil {
+		return BindingExpiry{}, false, err
+	}
+	return record.Expiry, true, nil
+}
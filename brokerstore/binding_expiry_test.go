@@ -0,0 +1,115 @@
+package brokerstore_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BindingExpiry", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		records     map[string]values.JSON
+		store       *CredhubStore
+		issuedAt    time.Time
+	)
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		records = map[string]values.JSON{
+			"/some-store-id/binding-1": {"app_guid": "app-1", "plan_id": "plan"},
+			"/some-store-id/binding-2": {"app_guid": "app-2", "plan_id": "plan"},
+			"/some-store-id/instance":  {"organization_guid": "org", "space_guid": "space"},
+		}
+		backCredhubWith(fakeCredhub, records)
+		store = NewCredhubStore(lagertest.NewTestLogger("binding-expiry"), fakeCredhub, "some-store-id")
+		issuedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	})
+
+	It("should render binding metadata in RFC 3339", func() {
+		expiry := CredentialLifetime{TTL: time.Hour, RenewWindow: 10 * time.Minute}.ExpiryFrom(issuedAt)
+		Expect(expiry.Metadata()).To(Equal(domain.BindingMetadata{
+			ExpiresAt:   "2026-01-02T04:04:05Z",
+			RenewBefore: "2026-01-02T03:54:05Z",
+		}))
+		Expect(CredentialLifetime{TTL: time.Hour}.ExpiryFrom(issuedAt).Metadata().RenewBefore).To(BeEmpty())
+	})
+
+	It("should store the expiry alongside the binding without changing its details", func() {
+		expiry := CredentialLifetime{TTL: time.Hour}.ExpiryFrom(issuedAt)
+		Expect(store.SetBindingExpiry("binding-1", expiry)).To(Succeed())
+
+		stored, ok, err := store.RetrieveBindingExpiry("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(stored).To(Equal(expiry))
+
+		details, err := store.RetrieveBindingDetails("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(details.AppGUID).To(Equal("app-1"))
+	})
+
+	It("should treat an instance as not found rather than give it an expiry", func() {
+		expiry := CredentialLifetime{TTL: time.Hour}.ExpiryFrom(issuedAt)
+
+		var notFound *credhub.NotFoundError
+		Expect(errors.As(store.SetBindingExpiry("instance", expiry), &notFound)).To(BeTrue())
+		Expect(records["/some-store-id/instance"]).To(Equal(values.JSON{"organization_guid": "org", "space_guid": "space"}))
+
+		_, _, err := store.RetrieveBindingExpiry("instance")
+		Expect(errors.As(err, &notFound)).To(BeTrue())
+	})
+
+	It("should report bindings without an expiry", func() {
+		_, ok, err := store.RetrieveBindingExpiry("binding-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should list only bindings expiring before the given time", func() {
+		soon := CredentialLifetime{TTL: time.Hour}.ExpiryFrom(issuedAt)
+		later := CredentialLifetime{TTL: 48 * time.Hour}.ExpiryFrom(issuedAt)
+		Expect(store.SetBindingExpiry("binding-1", soon)).To(Succeed())
+		Expect(store.SetBindingExpiry("binding-2", later)).To(Succeed())
+
+		expiring, err := store.RetrieveBindingsExpiringBefore(issuedAt.Add(24 * time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiring).To(Equal(map[string]BindingExpiry{"binding-1": soon}))
+	})
+
+	Context("#RotateBindingCredential", func() {
+		It("should regenerate the credential and restart its lifetime", func() {
+			fakeCredhub.RegenerateReturns(credentials.Credential{Value: "rotated"}, nil)
+			before := time.Now()
+
+			credential, expiry, err := store.RotateBindingCredential("binding-1", "password", CredentialLifetime{TTL: time.Hour})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credential.Value).To(Equal("rotated"))
+			Expect(fakeCredhub.RegenerateArgsForCall(0)).To(Equal("/some-store-id/binding-1/password"))
+			Expect(expiry.IssuedAt).To(BeTemporally(">=", before.Truncate(time.Second)))
+			Expect(expiry.ExpiresAt).To(Equal(expiry.IssuedAt.Add(time.Hour)))
+
+			stored, ok, err := store.RetrieveBindingExpiry("binding-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(stored.ExpiresAt).To(BeTemporally("==", expiry.ExpiresAt))
+		})
+
+		It("should leave the expiry alone when regenerating fails", func() {
+			fakeCredhub.RegenerateReturns(credentials.Credential{}, errors.New("bad-regenerate"))
+
+			_, _, err := store.RotateBindingCredential("binding-1", "password", CredentialLifetime{TTL: time.Hour})
+			Expect(err).To(MatchError("bad-regenerate"))
+			Expect(fakeCredhub.SetJSONCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package brokerstore
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+)
+
+// BindingInstanceKey is the context key under which a binding records the
+// instance it was made for. The bind request carries the instance ID in its
+// URL only, so brokers that want per-instance accounting add it with
+// WithBindingInstance before storing the binding. Binding conflict checks do
+// not compare the context, so a retried bind carrying only the platform's
+// context still matches the stored binding.
+const BindingInstanceKey = "instance_id"
+
+// WithBindingInstance returns details with instanceID recorded in its
+// context, keeping anything the platform sent there.
+func WithBindingInstance(details domain.BindDetails, instanceID string) (domain.BindDetails, error) {
+	context := map[string]interface{}{}
+	if len(details.RawContext) > 0 {
+		if err := json.Unmarshal(details.RawContext, &context); err != nil {
+			return domain.BindDetails{}, err
+		}
+	}
+	context[BindingInstanceKey] = instanceID
+
+	rawContext, err := json.Marshal(context)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+	details.RawContext = rawContext
+	return details, nil
+}
+
+// BindingInstance returns the instance recorded by WithBindingInstance, or ""
+func BindingInstance(details domain.BindDetails) string {
+	if len(details.RawContext) == 0 {
+		return ""
+	}
+
+	var context struct {
+		InstanceID string `json:"instance_id"`
+	}
+	if err := json.Unmarshal(details.RawContext, &context); err != nil {
+		return ""
+	}
+	return context.InstanceID
+}
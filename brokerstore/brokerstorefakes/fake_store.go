@@ -162,6 +162,18 @@ type FakeStore struct {
 	saveReturnsOnCall map[int]struct {
 		result1 error
 	}
+	UpdateInstanceDetailsStub        func(string, domain.UpdateDetails) error
+	updateInstanceDetailsMutex       sync.RWMutex
+	updateInstanceDetailsArgsForCall []struct {
+		arg1 string
+		arg2 domain.UpdateDetails
+	}
+	updateInstanceDetailsReturns struct {
+		result1 error
+	}
+	updateInstanceDetailsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -951,6 +963,68 @@ func (fake *FakeStore) SaveReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeStore) UpdateInstanceDetails(arg1 string, arg2 domain.UpdateDetails) error {
+	fake.updateInstanceDetailsMutex.Lock()
+	ret, specificReturn := fake.updateInstanceDetailsReturnsOnCall[len(fake.updateInstanceDetailsArgsForCall)]
+	fake.updateInstanceDetailsArgsForCall = append(fake.updateInstanceDetailsArgsForCall, struct {
+		arg1 string
+		arg2 domain.UpdateDetails
+	}{arg1, arg2})
+	stub := fake.UpdateInstanceDetailsStub
+	fakeReturns := fake.updateInstanceDetailsReturns
+	fake.recordInvocation("UpdateInstanceDetails", []interface{}{arg1, arg2})
+	fake.updateInstanceDetailsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsCallCount() int {
+	fake.updateInstanceDetailsMutex.RLock()
+	defer fake.updateInstanceDetailsMutex.RUnlock()
+	return len(fake.updateInstanceDetailsArgsForCall)
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsCalls(stub func(string, domain.UpdateDetails) error) {
+	fake.updateInstanceDetailsMutex.Lock()
+	defer fake.updateInstanceDetailsMutex.Unlock()
+	fake.UpdateInstanceDetailsStub = stub
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsArgsForCall(i int) (string, domain.UpdateDetails) {
+	fake.updateInstanceDetailsMutex.RLock()
+	defer fake.updateInstanceDetailsMutex.RUnlock()
+	argsForCall := fake.updateInstanceDetailsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsReturns(result1 error) {
+	fake.updateInstanceDetailsMutex.Lock()
+	defer fake.updateInstanceDetailsMutex.Unlock()
+	fake.UpdateInstanceDetailsStub = nil
+	fake.updateInstanceDetailsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateInstanceDetailsReturnsOnCall(i int, result1 error) {
+	fake.updateInstanceDetailsMutex.Lock()
+	defer fake.updateInstanceDetailsMutex.Unlock()
+	fake.UpdateInstanceDetailsStub = nil
+	if fake.updateInstanceDetailsReturnsOnCall == nil {
+		fake.updateInstanceDetailsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateInstanceDetailsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeStore) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -980,6 +1054,8 @@ func (fake *FakeStore) Invocations() map[string][][]interface{} {
 	defer fake.retrieveInstanceDetailsMutex.RUnlock()
 	fake.saveMutex.RLock()
 	defer fake.saveMutex.RUnlock()
+	fake.updateInstanceDetailsMutex.RLock()
+	defer fake.updateInstanceDetailsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
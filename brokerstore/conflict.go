@@ -0,0 +1,187 @@
+package brokerstore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// fingerPrintField is the serialized name of ServiceInstance.ServiceFingerPrint,
+// whose values are never shown in a diff.
+const fingerPrintField = "ServiceFingerPrint"
+
+// fingerPrintHashKey keys the hashes of fingerprint values in diffs. It is
+// drawn once per process and never leaves it, so a logged hash cannot be
+// used to guess a low-entropy secret offline.
+var fingerPrintHashKey = newFingerPrintHashKey()
+
+func newFingerPrintHashKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+type ConflictStatus string
+
+const (
+	// ConflictAbsent means there is no existing record to conflict with
+	ConflictAbsent    ConflictStatus = "absent"
+	ConflictIdentical ConflictStatus = "identical"
+	ConflictDetected  ConflictStatus = "conflict"
+	// ConflictUnknown means the existing record could not be read or compared
+	ConflictUnknown ConflictStatus = "unknown"
+)
+
+// FieldDiff is a field that differs between the existing and requested
+// record. Path uses the record's JSON names, with array indexes in brackets.
+// Fingerprint values are shown as a keyed hash, equal for equal values within
+// the process, and a missing field as nil.
+type FieldDiff struct {
+	Path      string      `json:"path"`
+	Existing  interface{} `json:"existing"`
+	Requested interface{} `json:"requested"`
+}
+
+type ConflictResult struct {
+	Status ConflictStatus `json:"status"`
+	Diff   []FieldDiff    `json:"diff,omitempty"`
+	Err    error          `json:"-"`
+}
+
+// CheckInstanceConflict compares details with the instance already stored
+// under id. Both are normalised through JSON first, so number encodings and
+// key order make no difference. Fingerprint values may be secrets, so a diff
+// reports them by keyed hash only. A read error other than not-found gives
+// ConflictUnknown rather than being mistaken for an absent instance.
+func CheckInstanceConflict(s Store, id string, details ServiceInstance) ConflictResult {
+	existing, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		if isNotFound(err) {
+			return ConflictResult{Status: ConflictAbsent}
+		}
+		return ConflictResult{Status: ConflictUnknown, Err: err}
+	}
+
+	// Shares and history record what happened after provisioning, not part
+	// of what is requested
+	existing.SharedSpaceGUIDs, details.SharedSpaceGUIDs = nil, nil
+	existing.History, details.History = nil, nil
+
+	existingFields, err := flattenJSON(existing)
+	if err != nil {
+		return ConflictResult{Status: ConflictUnknown, Err: fmt.Errorf("normalising existing %s %s: %w", InstanceRecord, id, err)}
+	}
+	requestedFields, err := flattenJSON(details)
+	if err != nil {
+		return ConflictResult{Status: ConflictUnknown, Err: fmt.Errorf("normalising requested %s %s: %w", InstanceRecord, id, err)}
+	}
+
+	diff := diffFields(existingFields, requestedFields)
+	if len(diff) > 0 {
+		return ConflictResult{Status: ConflictDetected, Diff: diff}
+	}
+	return ConflictResult{Status: ConflictIdentical}
+}
+
+// flattenJSON maps the path of every leaf value in v's JSON encoding to that
+// value's canonical encoding.
+func flattenJSON(v interface{}) (map[string]string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	if err := flattenInto(fields, "", generic); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func flattenInto(fields map[string]string, path string, v interface{}) error {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			fields[path] = "{}"
+		}
+		for key, child := range value {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			if err := flattenInto(fields, childPath, child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			fields[path] = "[]"
+		}
+		for i, child := range value {
+			if err := flattenInto(fields, fmt.Sprintf("%s[%d]", path, i), child); err != nil {
+				return err
+			}
+		}
+	default:
+		canonical, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		fields[path] = string(canonical)
+	}
+	return nil
+}
+
+func diffFields(existing, requested map[string]string) []FieldDiff {
+	paths := map[string]struct{}{}
+	for path := range existing {
+		paths[path] = struct{}{}
+	}
+	for path := range requested {
+		paths[path] = struct{}{}
+	}
+
+	diff := []FieldDiff{}
+	for _, path := range sortedKeys(paths) {
+		existingValue, inExisting := existing[path]
+		requestedValue, inRequested := requested[path]
+		if inExisting && inRequested && existingValue == requestedValue {
+			continue
+		}
+
+		diff = append(diff, FieldDiff{
+			Path:      path,
+			Existing:  diffValue(path, existingValue, inExisting),
+			Requested: diffValue(path, requestedValue, inRequested),
+		})
+	}
+	return diff
+}
+
+func diffValue(path, canonical string, present bool) interface{} {
+	if !present {
+		return nil
+	}
+	if path == fingerPrintField || strings.HasPrefix(path, fingerPrintField+".") || strings.HasPrefix(path, fingerPrintField+"[") {
+		mac := hmac.New(sha256.New, fingerPrintHashKey)
+		mac.Write([]byte(canonical))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(canonical), &value); err != nil {
+		return canonical
+	}
+	return value
+}
@@ -0,0 +1,142 @@
+package brokerstore_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckInstanceConflict", func() {
+	var (
+		fakeStore *brokerstorefakes.FakeStore
+		existing  ServiceInstance
+		requested ServiceInstance
+		result    ConflictResult
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		existing = ServiceInstance{
+			ServiceID:        "service-id",
+			PlanID:           "plan-id",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			ServiceFingerPrint: map[string]interface{}{
+				"share":    "nfs://server/export",
+				"uid":      float64(1000),
+				"password": "a-password",
+				"options":  []interface{}{"ro", "nolock"},
+			},
+		}
+		fakeStore.RetrieveInstanceDetailsReturns(existing, nil)
+
+		type fingerPrint struct {
+			UID      int      `json:"uid"`
+			Share    string   `json:"share"`
+			Options  []string `json:"options"`
+			Password string   `json:"password"`
+		}
+		requested = existing
+		requested.ServiceFingerPrint = fingerPrint{UID: 1000, Share: "nfs://server/export", Options: []string{"ro", "nolock"}, Password: "a-password"}
+	})
+
+	JustBeforeEach(func() {
+		result = CheckInstanceConflict(fakeStore, "instance-1", requested)
+	})
+
+	It("should find a retry with differently encoded numbers and key order identical", func() {
+		Expect(result).To(Equal(ConflictResult{Status: ConflictIdentical}))
+	})
+
+	Context("when fields differ", func() {
+		BeforeEach(func() {
+			requested.PlanID = "other-plan-id"
+			requested.ServiceFingerPrint = map[string]interface{}{
+				"share":    "nfs://server/export",
+				"uid":      1001,
+				"password": "b-password",
+				"options":  []string{"ro"},
+			}
+		})
+
+		It("should report a field-level diff, hashing fingerprint values with a key", func() {
+			Expect(result.Status).To(Equal(ConflictDetected))
+			Expect(result.Err).NotTo(HaveOccurred())
+
+			paths := []string{}
+			for _, diff := range result.Diff {
+				paths = append(paths, diff.Path)
+			}
+			Expect(paths).To(Equal([]string{
+				"ServiceFingerPrint.options[1]",
+				"ServiceFingerPrint.password",
+				"ServiceFingerPrint.uid",
+				"plan_id",
+			}))
+
+			Expect(result.Diff[3]).To(Equal(FieldDiff{Path: "plan_id", Existing: "plan-id", Requested: "other-plan-id"}))
+			Expect(result.Diff[0].Existing).To(HavePrefix("hmac-sha256:"))
+			Expect(result.Diff[0].Requested).To(BeNil())
+			Expect(result.Diff[1].Existing).To(HavePrefix("hmac-sha256:"))
+			Expect(result.Diff[1].Existing).NotTo(Equal(result.Diff[1].Requested))
+			Expect(result.Diff).NotTo(ContainElement(HaveField("Existing", "a-password")))
+
+			unkeyed := sha256.Sum256([]byte(`"a-password"`))
+			Expect(result.Diff[1].Existing).NotTo(HaveSuffix(hex.EncodeToString(unkeyed[:])))
+		})
+
+		It("should hash equal fingerprint values alike", func() {
+			again := CheckInstanceConflict(fakeStore, "instance-1", requested)
+			Expect(again.Diff).To(Equal(result.Diff))
+		})
+	})
+
+	Context("when there is no existing instance", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, &credhub.NotFoundError{})
+		})
+
+		It("should report it absent", func() {
+			Expect(result.Status).To(Equal(ConflictAbsent))
+		})
+	})
+
+	Context("when a store reports the instance missing with a wrapped ErrNotFound", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, fmt.Errorf("reading instance-1: %w", ErrNotFound))
+		})
+
+		It("should report it absent", func() {
+			Expect(result.Status).To(Equal(ConflictAbsent))
+		})
+	})
+
+	Context("when the existing instance cannot be read", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, errors.New("bad-retrieve"))
+		})
+
+		It("should report the conflict as unknown, with the error", func() {
+			Expect(result.Status).To(Equal(ConflictUnknown))
+			Expect(result.Err).To(MatchError("bad-retrieve"))
+		})
+	})
+
+	Context("when the requested instance cannot be encoded", func() {
+		BeforeEach(func() {
+			requested.ServiceFingerPrint = map[string]interface{}{"callback": func() {}}
+		})
+
+		It("should report the conflict as unknown", func() {
+			Expect(result.Status).To(Equal(ConflictUnknown))
+			Expect(result.Err).To(MatchError(ContainSubstring("normalising requested instance instance-1")))
+		})
+	})
+})
@@ -0,0 +1,454 @@
+package brokerstore
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// indexPrefix is where index entries live, nested under the store so that
+// listing records skips them.
+const indexPrefix = ".index"
+
+type IndexKind string
+
+const (
+	// IndexOrg and IndexSpace map an org or space GUID to instance IDs
+	IndexOrg   IndexKind = "org"
+	IndexSpace IndexKind = "space"
+	// IndexInstance maps an instance ID to the IDs of its bindings
+	IndexInstance IndexKind = "instance"
+)
+
+// IndexEntry says that the record ID is listed under Key in the Kind index
+type IndexEntry struct {
+	Kind IndexKind `json:"kind"`
+	Key  string    `json:"key"`
+	ID   string    `json:"id"`
+}
+
+// IndexReport lists the differences between the indexes and the records.
+// Missing entries are records absent from an index; stale entries are index
+// entries whose record no longer exists or no longer has that key.
+type IndexReport struct {
+	Missing []IndexEntry `json:"missing"`
+	Stale   []IndexEntry `json:"stale"`
+}
+
+func (r IndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0
+}
+
+type credhubIndexes struct {
+	mutex   sync.Mutex
+	enabled bool
+}
+
+// EnableIndexes makes the store maintain index entries on every create and
+// delete, so that the RetrieveBy queries list one index and then read only
+// the records in it. Run RebuildIndexes once after enabling them on a store
+// that already holds records.
+//
+// Each entry is a credential of its own, /<store>/.index/<kind>/<key>/<id>,
+// so brokers sharing a CredHub add and remove entries without overwriting
+// each other's. An entry is written after the record it covers, and a
+// failure to do so is logged rather than failing the write; CheckIndexes
+// finds such gaps.
+//
+// The RetrieveBy queries read the records as CredhubStore holds them. Records
+// written through an IntegrityStore come back unverified and still wrapped,
+// and records sealed by an EncryptingStore are never indexed, so the queries
+// suit stores where CredhubStore is used directly or under a SoftDeleteStore,
+// whose tombstones are left out.
+func (s *CredhubStore) EnableIndexes() {
+	s.indexes.mutex.Lock()
+	defer s.indexes.mutex.Unlock()
+	s.indexes.enabled = true
+}
+
+func (s *CredhubStore) indexesEnabled() bool {
+	s.indexes.mutex.Lock()
+	defer s.indexes.mutex.Unlock()
+	return s.indexes.enabled
+}
+
+// RetrieveInstanceDetailsByOrg returns the instances owned by an org. Without
+// indexes every record is read.
+func (s *CredhubStore) RetrieveInstanceDetailsByOrg(orgGUID string) (map[string]ServiceInstance, error) {
+	return s.retrieveInstancesBy(IndexOrg, orgGUID)
+}
+
+// RetrieveInstanceDetailsBySpace returns the instances owned by a space, not
+// those shared into it.
+func (s *CredhubStore) RetrieveInstanceDetailsBySpace(spaceGUID string) (map[string]ServiceInstance, error) {
+	return s.retrieveInstancesBy(IndexSpace, spaceGUID)
+}
+
+// RetrieveBindingDetailsByInstance returns the bindings recorded for an
+// instance with WithBindingInstance.
+func (s *CredhubStore) RetrieveBindingDetailsByInstance(instanceID string) (map[string]domain.BindDetails, error) {
+	logger := s.logger.Session("retrieve-binding-details-by-instance", lager.Data{"instance-id": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	records, err := s.retrieveIndexed(logger, IndexInstance, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := map[string]domain.BindDetails{}
+	for id, record := range records {
+		var bindDetails domain.BindDetails
+		if err := toStruct(record.Value, &bindDetails); err != nil {
+			logger.Error("failed-to-decode-binding", err, lager.Data{"id": id})
+			continue
+		}
+		bindings[id] = bindDetails
+	}
+	return bindings, nil
+}
+
+func (s *CredhubStore) retrieveInstancesBy(kind IndexKind, key string) (map[string]ServiceInstance, error) {
+	logger := s.logger.Session("retrieve-instance-details-by-"+string(kind), lager.Data{"key": key})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	records, err := s.retrieveIndexed(logger, kind, key)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]ServiceInstance{}
+	for id, record := range records {
+		var serviceInstance ServiceInstance
+		if err := toStruct(record.Value, &serviceInstance); err != nil {
+			logger.Error("failed-to-decode-instance", err, lager.Data{"id": id})
+			continue
+		}
+		instances[id] = serviceInstance
+	}
+	return instances, nil
+}
+
+// retrieveIndexed returns the records listed under key, skipping stale
+// entries. Without indexes it filters every record instead.
+func (s *CredhubStore) retrieveIndexed(logger lager.Logger, kind IndexKind, key string) (map[string]credhubRecord, error) {
+	matches := func(id string, record credhubRecord) bool {
+		return slices.Contains(indexEntriesOf(id, record), IndexEntry{Kind: kind, Key: key, ID: id})
+	}
+
+	if !s.indexesEnabled() {
+		all, err := s.retrieveAllRecords(s.storeID)
+		if err != nil {
+			return nil, err
+		}
+
+		records := map[string]credhubRecord{}
+		for id, record := range all {
+			if record.Err == nil && matches(id, record) {
+				records[id] = record
+			}
+		}
+		return records, nil
+	}
+
+	entries, err := s.storedIndexEntries(s.indexKeyPath(kind, key))
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]credhubRecord{}
+	for _, entry := range entries {
+		record, err := s.retrieveRecord(entry.ID)
+		if err != nil {
+			if !isNotFound(err) {
+				return nil, err
+			}
+			logger.Info("skipping-stale-index-entry", lager.Data{"kind": kind, "id": entry.ID})
+			continue
+		}
+		if !matches(entry.ID, record) {
+			logger.Info("skipping-stale-index-entry", lager.Data{"kind": kind, "id": entry.ID})
+			continue
+		}
+		records[entry.ID] = record
+	}
+	return records, nil
+}
+
+// RebuildIndexes writes every missing index entry and removes stale ones
+func (s *CredhubStore) RebuildIndexes() error {
+	logger := s.logger.Session("rebuild-indexes")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report, err := s.CheckIndexes()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range report.Missing {
+		if err := s.addIndexEntry(entry); err != nil {
+			return err
+		}
+	}
+	for _, entry := range report.Stale {
+		if err := s.removeIndexEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("rebuilt", lager.Data{"added": len(report.Missing), "removed": len(report.Stale)})
+	return nil
+}
+
+// CheckIndexes compares every index with the records, without changing
+// either.
+func (s *CredhubStore) CheckIndexes() (IndexReport, error) {
+	logger := s.logger.Session("check-indexes")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := IndexReport{Missing: []IndexEntry{}, Stale: []IndexEntry{}}
+
+	wanted, err := s.wantedIndexEntries()
+	if err != nil {
+		return report, err
+	}
+	stored, err := s.storedIndexEntries(s.namespaced(indexPrefix))
+	if err != nil {
+		return report, err
+	}
+
+	for _, entry := range wanted {
+		if !slices.Contains(stored, entry) {
+			report.Missing = append(report.Missing, entry)
+		}
+	}
+	for _, entry := range stored {
+		if !slices.Contains(wanted, entry) {
+			report.Stale = append(report.Stale, entry)
+		}
+	}
+
+	if !report.Consistent() {
+		logger.Info("inconsistent", lager.Data{"missing": len(report.Missing), "stale": len(report.Stale)})
+	}
+	return report, nil
+}
+
+// writeIndexedRecord is writeRecord, also updating the indexes if enabled
+func (s *CredhubStore) writeIndexedRecord(logger lager.Logger, kind RecordKind, id string, record map[string]interface{}) error {
+	if !s.indexesEnabled() {
+		return s.writeRecord(kind, id, record)
+	}
+
+	previous, previousErr := s.recordIndexEntries(id)
+	if err := s.writeRecord(kind, id, record); err != nil {
+		return err
+	}
+	s.reindex(logger, id, previous, previousErr, indexEntriesOf(id, credhubRecord{Kind: kind, Value: credentials.JSON{Value: record}}))
+	return nil
+}
+
+// deleteIndexedRecord deletes a record, also updating the indexes if enabled
+func (s *CredhubStore) deleteIndexedRecord(logger lager.Logger, id string) error {
+	if !s.indexesEnabled() {
+		return s.credhubShim.Delete(s.namespaced(id))
+	}
+
+	previous, previousErr := s.recordIndexEntries(id)
+	if err := s.credhubShim.Delete(s.namespaced(id)); err != nil {
+		return err
+	}
+	s.reindex(logger, id, previous, previousErr, nil)
+	return nil
+}
+
+// reindex moves a record from the index entries it had to the ones it has
+// now. When the previous record could not be read its entries are unknown,
+// so they are left for CheckIndexes to report as stale.
+func (s *CredhubStore) reindex(logger lager.Logger, id string, previous []IndexEntry, previousErr error, current []IndexEntry) {
+	if previousErr != nil {
+		logger.Error("failed-to-read-previous-index-entries", previousErr, lager.Data{"id": id})
+	}
+
+	for _, entry := range previous {
+		if slices.Contains(current, entry) {
+			continue
+		}
+		if err := s.removeIndexEntry(entry); err != nil {
+			logger.Error("failed-to-remove-index-entry", err, lager.Data{"index": entry})
+		}
+	}
+	for _, entry := range current {
+		if slices.Contains(previous, entry) {
+			continue
+		}
+		if err := s.addIndexEntry(entry); err != nil {
+			logger.Error("failed-to-add-index-entry", err, lager.Data{"index": entry})
+		}
+	}
+}
+
+// recordIndexEntries returns the index entries of the record currently
+// stored under id, or none if there is no such record.
+func (s *CredhubStore) recordIndexEntries(id string) ([]IndexEntry, error) {
+	record, err := s.retrieveRecord(id)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return indexEntriesOf(id, record), nil
+}
+
+func (s *CredhubStore) addIndexEntry(entry IndexEntry) error {
+	_, err := s.credhubShim.SetJSON(s.indexEntryName(entry), map[string]interface{}{
+		"kind": entry.Kind,
+		"key":  entry.Key,
+		"id":   entry.ID,
+	})
+	return err
+}
+
+func (s *CredhubStore) removeIndexEntry(entry IndexEntry) error {
+	if err := s.credhubShim.Delete(s.indexEntryName(entry)); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// storedIndexEntries lists the index entries under path, sorted
+func (s *CredhubStore) storedIndexEntries(path string) ([]IndexEntry, error) {
+	results, err := s.credhubShim.FindByPath(path + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []IndexEntry{}
+	for _, result := range results.Credentials {
+		if entry, ok := s.indexEntryOf(result.Name); ok {
+			entries = append(entries, entry)
+		}
+	}
+	sortIndexEntries(entries)
+	return entries, nil
+}
+
+// wantedIndexEntries returns the index entries of every record, sorted
+func (s *CredhubStore) wantedIndexEntries() ([]IndexEntry, error) {
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []IndexEntry{}
+	for id, record := range records {
+		if record.Err == nil {
+			entries = append(entries, indexEntriesOf(id, record)...)
+		}
+	}
+	sortIndexEntries(entries)
+	return entries, nil
+}
+
+func (s *CredhubStore) indexKeyPath(kind IndexKind, key string) string {
+	return s.namespaced(fmt.Sprintf("%s/%s/%s", indexPrefix, kind, key))
+}
+
+func (s *CredhubStore) indexEntryName(entry IndexEntry) string {
+	return s.indexKeyPath(entry.Kind, entry.Key) + "/" + entry.ID
+}
+
+func (s *CredhubStore) indexEntryOf(name string) (IndexEntry, bool) {
+	rest, ok := strings.CutPrefix(name, s.namespaced(indexPrefix+"/"))
+	if !ok {
+		return IndexEntry{}, false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return IndexEntry{}, false
+	}
+	return IndexEntry{Kind: IndexKind(parts[0]), Key: parts[1], ID: parts[2]}, true
+}
+
+func sortIndexEntries(entries []IndexEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.ID < b.ID
+	})
+}
+
+// indexEntriesOf returns the index entries of a bare record: its org and
+// space for instances, and its instance for bindings. Soft-deleted records
+// have none.
+func indexEntriesOf(id string, record credhubRecord) []IndexEntry {
+	entries := []IndexEntry{}
+	if id == "" || strings.Contains(id, "/") {
+		return entries
+	}
+	add := func(kind IndexKind, key interface{}) {
+		if key, ok := key.(string); ok && key != "" && !strings.Contains(key, "/") {
+			entries = append(entries, IndexEntry{Kind: kind, Key: key, ID: id})
+		}
+	}
+
+	switch record.Kind {
+	case InstanceRecord:
+		if isTombstoneValue(record.Value.Value[fingerPrintField]) {
+			return entries
+		}
+		add(IndexOrg, record.Value.Value["organization_guid"])
+		add(IndexSpace, record.Value.Value["space_guid"])
+	case BindingRecord:
+		if isTombstoneValue(record.Value.Value["context"]) {
+			return entries
+		}
+		add(IndexInstance, contextInstance(record.Value.Value["context"]))
+	}
+	return entries
+}
+
+// isTombstoneValue reports whether a stored fingerprint or binding context is
+// a SoftDeleteStore tombstone, looking inside an integrity store's wrapper.
+func isTombstoneValue(value interface{}) bool {
+	wrapped, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, deleted := tombstoneDeletedAt(wrapped); deleted {
+		return true
+	}
+	if _, ok := wrapped[integrityMACKey]; ok {
+		return isTombstoneValue(wrapped[integrityValueKey])
+	}
+	return false
+}
+
+// contextInstance finds the instance recorded by WithBindingInstance in a
+// stored binding context, looking inside the "value" that the integrity and
+// soft delete stores wrap the original context in.
+func contextInstance(context interface{}) interface{} {
+	wrapped, ok := context.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if instanceID, ok := wrapped[BindingInstanceKey]; ok {
+		return instanceID
+	}
+	return contextInstance(wrapped["value"])
+}
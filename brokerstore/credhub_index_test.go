@@ -0,0 +1,272 @@
+package brokerstore_test
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("CredhubStore indexes", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		records     map[string]values.JSON
+		logger      *lagertest.TestLogger
+		store       *CredhubStore
+	)
+
+	instance := func(org, space string) ServiceInstance {
+		return ServiceInstance{ServiceID: "service-id", PlanID: "plan-id", OrganizationGUID: org, SpaceGUID: space}
+	}
+
+	binding := func(instanceID string) domain.BindDetails {
+		details, err := WithBindingInstance(domain.BindDetails{AppGUID: "app-guid", PlanID: "plan-id"}, instanceID)
+		Expect(err).NotTo(HaveOccurred())
+		return details
+	}
+
+	// ids lists the record IDs with an entry under an index key
+	ids := func(kind IndexKind, key string) []string {
+		prefix := "/some-store-id/.index/" + string(kind) + "/" + key + "/"
+		found := []string{}
+		for name := range records {
+			if id, ok := strings.CutPrefix(name, prefix); ok {
+				found = append(found, id)
+			}
+		}
+		sort.Strings(found)
+		return found
+	}
+
+	listedStore := func() bool {
+		for i := 0; i < fakeCredhub.FindByPathCallCount(); i++ {
+			if fakeCredhub.FindByPathArgsForCall(i) == "/some-store-id/" {
+				return true
+			}
+		}
+		return false
+	}
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		records = map[string]values.JSON{}
+		backCredhubWith(fakeCredhub, records)
+		logger = lagertest.NewTestLogger("credhub-index")
+		store = NewCredhubStore(logger, fakeCredhub, "some-store-id")
+	})
+
+	Context("when indexes are enabled", func() {
+		BeforeEach(func() {
+			store.EnableIndexes()
+
+			Expect(store.CreateInstanceDetails("instance-1", instance("org-1", "space-1"))).To(Succeed())
+			Expect(store.CreateInstanceDetails("instance-2", instance("org-1", "space-2"))).To(Succeed())
+			Expect(store.CreateBindingDetails("binding-1", binding("instance-1"))).To(Succeed())
+			Expect(store.CreateBindingDetails("binding-2", binding("instance-1"))).To(Succeed())
+		})
+
+		It("should write an index entry per record on create", func() {
+			Expect(ids(IndexOrg, "org-1")).To(Equal([]string{"instance-1", "instance-2"}))
+			Expect(ids(IndexSpace, "space-1")).To(Equal([]string{"instance-1"}))
+			Expect(ids(IndexInstance, "instance-1")).To(Equal([]string{"binding-1", "binding-2"}))
+			Expect(records).To(HaveKeyWithValue("/some-store-id/.index/org/org-1/instance-1", values.JSON{"kind": IndexOrg, "key": "org-1", "id": "instance-1"}))
+		})
+
+		It("should not list index records as store records", func() {
+			instances, err := store.RetrieveAllInstanceDetails()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+		})
+
+		It("should answer queries from the index without listing the store", func() {
+			instances, err := store.RetrieveInstanceDetailsByOrg("org-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(instances["instance-2"].SpaceGUID).To(Equal("space-2"))
+
+			instances, err = store.RetrieveInstanceDetailsBySpace("space-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveKey("instance-1"))
+			Expect(instances).To(HaveLen(1))
+
+			bindings, err := store.RetrieveBindingDetailsByInstance("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(HaveLen(2))
+			Expect(BindingInstance(bindings["binding-1"])).To(Equal("instance-1"))
+
+			Expect(listedStore()).To(BeFalse())
+		})
+
+		It("should move a recreated record between index entries", func() {
+			Expect(store.CreateInstanceDetails("instance-1", instance("org-1", "space-2"))).To(Succeed())
+
+			Expect(ids(IndexSpace, "space-1")).To(BeEmpty())
+			Expect(ids(IndexSpace, "space-2")).To(Equal([]string{"instance-1", "instance-2"}))
+		})
+
+		It("should remove deleted records from the indexes", func() {
+			Expect(store.DeleteBindingDetails("binding-1")).To(Succeed())
+			Expect(store.DeleteInstanceDetails("instance-2")).To(Succeed())
+
+			Expect(ids(IndexInstance, "instance-1")).To(Equal([]string{"binding-2"}))
+			Expect(ids(IndexOrg, "org-1")).To(Equal([]string{"instance-1"}))
+			Expect(ids(IndexSpace, "space-2")).To(BeEmpty())
+		})
+
+		It("should find bindings whose context a decorator has wrapped", func() {
+			Expect(store.CreateBindingDetails("binding-3", domain.BindDetails{
+				AppGUID:    "app-guid",
+				RawContext: []byte(`{"hmac":"a-mac","value":{"instance_id":"instance-2"}}`),
+			})).To(Succeed())
+
+			Expect(ids(IndexInstance, "instance-2")).To(Equal([]string{"binding-3"}))
+		})
+
+		It("should see entries written by other brokers sharing the CredHub", func() {
+			other := NewCredhubStore(lagertest.NewTestLogger("other-broker"), fakeCredhub, "some-store-id")
+			other.EnableIndexes()
+			Expect(other.CreateInstanceDetails("instance-3", instance("org-1", "space-3"))).To(Succeed())
+
+			instances, err := store.RetrieveInstanceDetailsByOrg("org-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(3))
+		})
+
+		It("should leave soft-deleted records out of the indexes", func() {
+			softDelete := NewSoftDeleteStore(lagertest.NewTestLogger("soft-delete"), store, SoftDeleteOptions{})
+			Expect(softDelete.DeleteInstanceDetails("instance-2")).To(Succeed())
+			Expect(records).To(HaveKey("/some-store-id/instance-2"))
+
+			instances, err := store.RetrieveInstanceDetailsByOrg("org-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(ids(IndexOrg, "org-1")).To(Equal([]string{"instance-1"}))
+
+			report, err := store.CheckIndexes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Consistent()).To(BeTrue())
+		})
+
+		Context("when the previous record cannot be read", func() {
+			BeforeEach(func() {
+				getLatestJSON := fakeCredhub.GetLatestJSONStub
+				failed := false
+				fakeCredhub.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+					if name == "/some-store-id/instance-1" && !failed {
+						failed = true
+						return credentials.JSON{}, errors.New("credhub-down")
+					}
+					return getLatestJSON(name)
+				}
+			})
+
+			It("should keep its entries, as they are unknown, and log the error", func() {
+				Expect(store.CreateInstanceDetails("instance-1", instance("org-1", "space-2"))).To(Succeed())
+				Expect(logger).To(gbytes.Say("failed-to-read-previous-index-entries"))
+				Expect(ids(IndexSpace, "space-1")).To(Equal([]string{"instance-1"}))
+				Expect(ids(IndexSpace, "space-2")).To(Equal([]string{"instance-1", "instance-2"}))
+
+				report, err := store.CheckIndexes()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Stale).To(ConsistOf(IndexEntry{Kind: IndexSpace, Key: "space-1", ID: "instance-1"}))
+			})
+		})
+
+		It("should skip index entries whose record has gone", func() {
+			delete(records, "/some-store-id/instance-2")
+
+			instances, err := store.RetrieveInstanceDetailsByOrg("org-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances).To(HaveKey("instance-1"))
+		})
+
+		It("should report a consistent index", func() {
+			report, err := store.CheckIndexes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Consistent()).To(BeTrue())
+		})
+
+		Context("when records are changed around the store", func() {
+			BeforeEach(func() {
+				delete(records, "/some-store-id/instance-2")
+				records["/some-store-id/instance-3"] = values.JSON{"organization_guid": "org-2", "space_guid": "space-3"}
+			})
+
+			It("should report missing and stale entries", func() {
+				report, err := store.CheckIndexes()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Consistent()).To(BeFalse())
+				Expect(report.Missing).To(ConsistOf(
+					IndexEntry{Kind: IndexOrg, Key: "org-2", ID: "instance-3"},
+					IndexEntry{Kind: IndexSpace, Key: "space-3", ID: "instance-3"},
+				))
+				Expect(report.Stale).To(ConsistOf(
+					IndexEntry{Kind: IndexOrg, Key: "org-1", ID: "instance-2"},
+					IndexEntry{Kind: IndexSpace, Key: "space-2", ID: "instance-2"},
+				))
+			})
+
+			It("should repair them on rebuild", func() {
+				Expect(store.RebuildIndexes()).To(Succeed())
+
+				Expect(ids(IndexSpace, "space-2")).To(BeEmpty())
+				Expect(ids(IndexOrg, "org-1")).To(Equal([]string{"instance-1"}))
+				Expect(ids(IndexOrg, "org-2")).To(Equal([]string{"instance-3"}))
+
+				report, err := store.CheckIndexes()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Consistent()).To(BeTrue())
+			})
+		})
+
+		Context("when an index cannot be written", func() {
+			BeforeEach(func() {
+				setJSON := fakeCredhub.SetJSONStub
+				fakeCredhub.SetJSONStub = func(name string, value values.JSON) (credentials.JSON, error) {
+					if name == "/some-store-id/.index/org/org-3/instance-4" {
+						return credentials.JSON{}, errors.New("credhub-down")
+					}
+					return setJSON(name, value)
+				}
+			})
+
+			It("should still store the record", func() {
+				Expect(store.CreateInstanceDetails("instance-4", instance("org-3", "space-1"))).To(Succeed())
+				Expect(records).To(HaveKey("/some-store-id/instance-4"))
+
+				report, err := store.CheckIndexes()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Missing).To(ConsistOf(IndexEntry{Kind: IndexOrg, Key: "org-3", ID: "instance-4"}))
+			})
+		})
+	})
+
+	Context("when indexes are not enabled", func() {
+		BeforeEach(func() {
+			Expect(store.CreateInstanceDetails("instance-1", instance("org-1", "space-1"))).To(Succeed())
+			Expect(store.CreateInstanceDetails("instance-2", instance("org-2", "space-2"))).To(Succeed())
+		})
+
+		It("should not write index records", func() {
+			Expect(records).To(HaveLen(2))
+		})
+
+		It("should answer queries by listing the store", func() {
+			instances, err := store.RetrieveInstanceDetailsByOrg("org-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances).To(HaveKey("instance-2"))
+			Expect(fakeCredhub.FindByPathCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package credhub_shims
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+)
+
+type Feature string
+
+const (
+	FeaturePermissionsV2      Feature = "v2 permissions"
+	FeatureCredentialMetadata Feature = "credential metadata"
+	FeatureBulkRegenerate     Feature = "bulk regenerate"
+)
+
+var minimumVersions = map[Feature]*version.Version{
+	FeaturePermissionsV2:      version.Must(version.NewVersion("2.0.0")),
+	FeatureCredentialMetadata: version.Must(version.NewVersion("2.6.0")),
+	FeatureBulkRegenerate:     version.Must(version.NewVersion("1.6.0")),
+}
+
+// Capabilities describes what the targeted CredHub server supports
+type Capabilities struct {
+	ServerVersion      string `json:"server_version"`
+	PermissionsV2      bool   `json:"permissions_v2"`
+	CredentialMetadata bool   `json:"credential_metadata"`
+	BulkRegenerate     bool   `json:"bulk_regenerate"`
+}
+
+type UnsupportedFeatureError struct {
+	Feature       Feature
+	ServerVersion string
+	MinVersion    string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("credhub %s does not support %s, requires >= %s", e.ServerVersion, e.Feature, e.MinVersion)
+}
+
+func CapabilitiesForVersion(serverVersion *version.Version) Capabilities {
+	supports := func(feature Feature) bool {
+		return serverVersion.GreaterThanOrEqual(minimumVersions[feature])
+	}
+
+	return Capabilities{
+		ServerVersion:      serverVersion.String(),
+		PermissionsV2:      supports(FeaturePermissionsV2),
+		CredentialMetadata: supports(FeatureCredentialMetadata),
+		BulkRegenerate:     supports(FeatureBulkRegenerate),
+	}
+}
+
+// DetectCapabilities asks the server for its version and derives what it supports
+func DetectCapabilities(ch Credhub) (Capabilities, error) {
+	serverVersion, err := ch.ServerVersion()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return CapabilitiesForVersion(serverVersion), nil
+}
+
+// Require returns an UnsupportedFeatureError if the server lacks feature
+func (c Capabilities) Require(feature Feature) error {
+	var supported bool
+	switch feature {
+	case FeaturePermissionsV2:
+		supported = c.PermissionsV2
+	case FeatureCredentialMetadata:
+		supported = c.CredentialMetadata
+	case FeatureBulkRegenerate:
+		supported = c.BulkRegenerate
+	}
+
+	if supported {
+		return nil
+	}
+
+	minVersion := "an unknown version"
+	if v, ok := minimumVersions[feature]; ok {
+		minVersion = v.String()
+	}
+	return &UnsupportedFeatureError{Feature: feature, ServerVersion: c.ServerVersion, MinVersion: minVersion}
+}
@@ -0,0 +1,63 @@
+package credhub_shims_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+	var fakeCredhub *credhub_fakes.FakeCredhub
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+	})
+
+	Describe("DetectCapabilities", func() {
+		It("should enable every feature on a recent server", func() {
+			fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.12.1")), nil)
+
+			capabilities, err := credhub_shims.DetectCapabilities(fakeCredhub)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(capabilities).To(Equal(credhub_shims.Capabilities{
+				ServerVersion:      "2.12.1",
+				PermissionsV2:      true,
+				CredentialMetadata: true,
+				BulkRegenerate:     true,
+			}))
+		})
+
+		It("should disable features newer than the server", func() {
+			fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.1.0")), nil)
+
+			capabilities, err := credhub_shims.DetectCapabilities(fakeCredhub)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(capabilities.PermissionsV2).To(BeTrue())
+			Expect(capabilities.CredentialMetadata).To(BeFalse())
+		})
+
+		It("should return errors fetching the version", func() {
+			fakeCredhub.ServerVersionReturns(nil, errors.New("bad-version"))
+
+			_, err := credhub_shims.DetectCapabilities(fakeCredhub)
+			Expect(err).To(MatchError("bad-version"))
+		})
+	})
+
+	Describe("Require", func() {
+		It("should explain which version a missing feature needs", func() {
+			capabilities := credhub_shims.CapabilitiesForVersion(version.Must(version.NewVersion("1.9.0")))
+
+			Expect(capabilities.Require(credhub_shims.FeatureBulkRegenerate)).To(Succeed())
+
+			err := capabilities.Require(credhub_shims.FeaturePermissionsV2)
+			var unsupportedErr *credhub_shims.UnsupportedFeatureError
+			Expect(errors.As(err, &unsupportedErr)).To(BeTrue())
+			Expect(err).To(MatchError("credhub 1.9.0 does not support v2 permissions, requires >= 2.0.0"))
+		})
+	})
+})
@@ -4,12 +4,32 @@ package credhub_fakes
 import (
 	"sync"
 
+	"code.cloudfoundry.org/credhub-cli/credhub"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
 	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	version "github.com/hashicorp/go-version"
 )
 
 type FakeCredhub struct {
+	AddPermissionStub        func(string, string, []string) (*permissions.Permission, error)
+	addPermissionMutex       sync.RWMutex
+	addPermissionArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 []string
+	}
+	addPermissionReturns struct {
+		result1 *permissions.Permission
+		result2 error
+	}
+	addPermissionReturnsOnCall map[int]struct {
+		result1 *permissions.Permission
+		result2 error
+	}
 	DeleteStub        func(string) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
@@ -21,6 +41,19 @@ type FakeCredhub struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
+	DeletePermissionStub        func(string) (*permissions.Permission, error)
+	deletePermissionMutex       sync.RWMutex
+	deletePermissionArgsForCall []struct {
+		arg1 string
+	}
+	deletePermissionReturns struct {
+		result1 *permissions.Permission
+		result2 error
+	}
+	deletePermissionReturnsOnCall map[int]struct {
+		result1 *permissions.Permission
+		result2 error
+	}
 	FindByPathStub        func(string) (credentials.FindResults, error)
 	findByPathMutex       sync.RWMutex
 	findByPathArgsForCall []struct {
@@ -34,6 +67,51 @@ type FakeCredhub struct {
 		result1 credentials.FindResults
 		result2 error
 	}
+	GenerateCertificateStub        func(string, generate.Certificate, credhub.Mode) (credentials.Certificate, error)
+	generateCertificateMutex       sync.RWMutex
+	generateCertificateArgsForCall []struct {
+		arg1 string
+		arg2 generate.Certificate
+		arg3 credhub.Mode
+	}
+	generateCertificateReturns struct {
+		result1 credentials.Certificate
+		result2 error
+	}
+	generateCertificateReturnsOnCall map[int]struct {
+		result1 credentials.Certificate
+		result2 error
+	}
+	GeneratePasswordStub        func(string, generate.Password, credhub.Mode) (credentials.Password, error)
+	generatePasswordMutex       sync.RWMutex
+	generatePasswordArgsForCall []struct {
+		arg1 string
+		arg2 generate.Password
+		arg3 credhub.Mode
+	}
+	generatePasswordReturns struct {
+		result1 credentials.Password
+		result2 error
+	}
+	generatePasswordReturnsOnCall map[int]struct {
+		result1 credentials.Password
+		result2 error
+	}
+	GenerateUserStub        func(string, generate.User, credhub.Mode) (credentials.User, error)
+	generateUserMutex       sync.RWMutex
+	generateUserArgsForCall []struct {
+		arg1 string
+		arg2 generate.User
+		arg3 credhub.Mode
+	}
+	generateUserReturns struct {
+		result1 credentials.User
+		result2 error
+	}
+	generateUserReturnsOnCall map[int]struct {
+		result1 credentials.User
+		result2 error
+	}
 	GetLatestJSONStub        func(string) (credentials.JSON, error)
 	getLatestJSONMutex       sync.RWMutex
 	getLatestJSONArgsForCall []struct {
@@ -60,6 +138,57 @@ type FakeCredhub struct {
 		result1 credentials.Value
 		result2 error
 	}
+	GetPermissionByPathActorStub        func(string, string) (*permissions.Permission, error)
+	getPermissionByPathActorMutex       sync.RWMutex
+	getPermissionByPathActorArgsForCall []struct {
+		arg1 string
+		arg2 string
+	}
+	getPermissionByPathActorReturns struct {
+		result1 *permissions.Permission
+		result2 error
+	}
+	getPermissionByPathActorReturnsOnCall map[int]struct {
+		result1 *permissions.Permission
+		result2 error
+	}
+	InfoStub        func() (*server.Info, error)
+	infoMutex       sync.RWMutex
+	infoArgsForCall []struct {
+	}
+	infoReturns struct {
+		result1 *server.Info
+		result2 error
+	}
+	infoReturnsOnCall map[int]struct {
+		result1 *server.Info
+		result2 error
+	}
+	RegenerateStub        func(string) (credentials.Credential, error)
+	regenerateMutex       sync.RWMutex
+	regenerateArgsForCall []struct {
+		arg1 string
+	}
+	regenerateReturns struct {
+		result1 credentials.Credential
+		result2 error
+	}
+	regenerateReturnsOnCall map[int]struct {
+		result1 credentials.Credential
+		result2 error
+	}
+	ServerVersionStub        func() (*version.Version, error)
+	serverVersionMutex       sync.RWMutex
+	serverVersionArgsForCall []struct {
+	}
+	serverVersionReturns struct {
+		result1 *version.Version
+		result2 error
+	}
+	serverVersionReturnsOnCall map[int]struct {
+		result1 *version.Version
+		result2 error
+	}
 	SetJSONStub        func(string, values.JSON) (credentials.JSON, error)
 	setJSONMutex       sync.RWMutex
 	setJSONArgsForCall []struct {
@@ -92,6 +221,77 @@ type FakeCredhub struct {
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeCredhub) AddPermission(arg1 string, arg2 string, arg3 []string) (*permissions.Permission, error) {
+	var arg3Copy []string
+	if arg3 != nil {
+		arg3Copy = make([]string, len(arg3))
+		copy(arg3Copy, arg3)
+	}
+	fake.addPermissionMutex.Lock()
+	ret, specificReturn := fake.addPermissionReturnsOnCall[len(fake.addPermissionArgsForCall)]
+	fake.addPermissionArgsForCall = append(fake.addPermissionArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 []string
+	}{arg1, arg2, arg3Copy})
+	stub := fake.AddPermissionStub
+	fakeReturns := fake.addPermissionReturns
+	fake.recordInvocation("AddPermission", []interface{}{arg1, arg2, arg3Copy})
+	fake.addPermissionMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) AddPermissionCallCount() int {
+	fake.addPermissionMutex.RLock()
+	defer fake.addPermissionMutex.RUnlock()
+	return len(fake.addPermissionArgsForCall)
+}
+
+func (fake *FakeCredhub) AddPermissionCalls(stub func(string, string, []string) (*permissions.Permission, error)) {
+	fake.addPermissionMutex.Lock()
+	defer fake.addPermissionMutex.Unlock()
+	fake.AddPermissionStub = stub
+}
+
+func (fake *FakeCredhub) AddPermissionArgsForCall(i int) (string, string, []string) {
+	fake.addPermissionMutex.RLock()
+	defer fake.addPermissionMutex.RUnlock()
+	argsForCall := fake.addPermissionArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeCredhub) AddPermissionReturns(result1 *permissions.Permission, result2 error) {
+	fake.addPermissionMutex.Lock()
+	defer fake.addPermissionMutex.Unlock()
+	fake.AddPermissionStub = nil
+	fake.addPermissionReturns = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) AddPermissionReturnsOnCall(i int, result1 *permissions.Permission, result2 error) {
+	fake.addPermissionMutex.Lock()
+	defer fake.addPermissionMutex.Unlock()
+	fake.AddPermissionStub = nil
+	if fake.addPermissionReturnsOnCall == nil {
+		fake.addPermissionReturnsOnCall = make(map[int]struct {
+			result1 *permissions.Permission
+			result2 error
+		})
+	}
+	fake.addPermissionReturnsOnCall[i] = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeCredhub) Delete(arg1 string) error {
 	fake.deleteMutex.Lock()
 	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
@@ -153,6 +353,70 @@ func (fake *FakeCredhub) DeleteReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeCredhub) DeletePermission(arg1 string) (*permissions.Permission, error) {
+	fake.deletePermissionMutex.Lock()
+	ret, specificReturn := fake.deletePermissionReturnsOnCall[len(fake.deletePermissionArgsForCall)]
+	fake.deletePermissionArgsForCall = append(fake.deletePermissionArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.DeletePermissionStub
+	fakeReturns := fake.deletePermissionReturns
+	fake.recordInvocation("DeletePermission", []interface{}{arg1})
+	fake.deletePermissionMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) DeletePermissionCallCount() int {
+	fake.deletePermissionMutex.RLock()
+	defer fake.deletePermissionMutex.RUnlock()
+	return len(fake.deletePermissionArgsForCall)
+}
+
+func (fake *FakeCredhub) DeletePermissionCalls(stub func(string) (*permissions.Permission, error)) {
+	fake.deletePermissionMutex.Lock()
+	defer fake.deletePermissionMutex.Unlock()
+	fake.DeletePermissionStub = stub
+}
+
+func (fake *FakeCredhub) DeletePermissionArgsForCall(i int) string {
+	fake.deletePermissionMutex.RLock()
+	defer fake.deletePermissionMutex.RUnlock()
+	argsForCall := fake.deletePermissionArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCredhub) DeletePermissionReturns(result1 *permissions.Permission, result2 error) {
+	fake.deletePermissionMutex.Lock()
+	defer fake.deletePermissionMutex.Unlock()
+	fake.DeletePermissionStub = nil
+	fake.deletePermissionReturns = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) DeletePermissionReturnsOnCall(i int, result1 *permissions.Permission, result2 error) {
+	fake.deletePermissionMutex.Lock()
+	defer fake.deletePermissionMutex.Unlock()
+	fake.DeletePermissionStub = nil
+	if fake.deletePermissionReturnsOnCall == nil {
+		fake.deletePermissionReturnsOnCall = make(map[int]struct {
+			result1 *permissions.Permission
+			result2 error
+		})
+	}
+	fake.deletePermissionReturnsOnCall[i] = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeCredhub) FindByPath(arg1 string) (credentials.FindResults, error) {
 	fake.findByPathMutex.Lock()
 	ret, specificReturn := fake.findByPathReturnsOnCall[len(fake.findByPathArgsForCall)]
@@ -217,6 +481,204 @@ func (fake *FakeCredhub) FindByPathReturnsOnCall(i int, result1 credentials.Find
 	}{result1, result2}
 }
 
+func (fake *FakeCredhub) GenerateCertificate(arg1 string, arg2 generate.Certificate, arg3 credhub.Mode) (credentials.Certificate, error) {
+	fake.generateCertificateMutex.Lock()
+	ret, specificReturn := fake.generateCertificateReturnsOnCall[len(fake.generateCertificateArgsForCall)]
+	fake.generateCertificateArgsForCall = append(fake.generateCertificateArgsForCall, struct {
+		arg1 string
+		arg2 generate.Certificate
+		arg3 credhub.Mode
+	}{arg1, arg2, arg3})
+	stub := fake.GenerateCertificateStub
+	fakeReturns := fake.generateCertificateReturns
+	fake.recordInvocation("GenerateCertificate", []interface{}{arg1, arg2, arg3})
+	fake.generateCertificateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) GenerateCertificateCallCount() int {
+	fake.generateCertificateMutex.RLock()
+	defer fake.generateCertificateMutex.RUnlock()
+	return len(fake.generateCertificateArgsForCall)
+}
+
+func (fake *FakeCredhub) GenerateCertificateCalls(stub func(string, generate.Certificate, credhub.Mode) (credentials.Certificate, error)) {
+	fake.generateCertificateMutex.Lock()
+	defer fake.generateCertificateMutex.Unlock()
+	fake.GenerateCertificateStub = stub
+}
+
+func (fake *FakeCredhub) GenerateCertificateArgsForCall(i int) (string, generate.Certificate, credhub.Mode) {
+	fake.generateCertificateMutex.RLock()
+	defer fake.generateCertificateMutex.RUnlock()
+	argsForCall := fake.generateCertificateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeCredhub) GenerateCertificateReturns(result1 credentials.Certificate, result2 error) {
+	fake.generateCertificateMutex.Lock()
+	defer fake.generateCertificateMutex.Unlock()
+	fake.GenerateCertificateStub = nil
+	fake.generateCertificateReturns = struct {
+		result1 credentials.Certificate
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GenerateCertificateReturnsOnCall(i int, result1 credentials.Certificate, result2 error) {
+	fake.generateCertificateMutex.Lock()
+	defer fake.generateCertificateMutex.Unlock()
+	fake.GenerateCertificateStub = nil
+	if fake.generateCertificateReturnsOnCall == nil {
+		fake.generateCertificateReturnsOnCall = make(map[int]struct {
+			result1 credentials.Certificate
+			result2 error
+		})
+	}
+	fake.generateCertificateReturnsOnCall[i] = struct {
+		result1 credentials.Certificate
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GeneratePassword(arg1 string, arg2 generate.Password, arg3 credhub.Mode) (credentials.Password, error) {
+	fake.generatePasswordMutex.Lock()
+	ret, specificReturn := fake.generatePasswordReturnsOnCall[len(fake.generatePasswordArgsForCall)]
+	fake.generatePasswordArgsForCall = append(fake.generatePasswordArgsForCall, struct {
+		arg1 string
+		arg2 generate.Password
+		arg3 credhub.Mode
+	}{arg1, arg2, arg3})
+	stub := fake.GeneratePasswordStub
+	fakeReturns := fake.generatePasswordReturns
+	fake.recordInvocation("GeneratePassword", []interface{}{arg1, arg2, arg3})
+	fake.generatePasswordMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) GeneratePasswordCallCount() int {
+	fake.generatePasswordMutex.RLock()
+	defer fake.generatePasswordMutex.RUnlock()
+	return len(fake.generatePasswordArgsForCall)
+}
+
+func (fake *FakeCredhub) GeneratePasswordCalls(stub func(string, generate.Password, credhub.Mode) (credentials.Password, error)) {
+	fake.generatePasswordMutex.Lock()
+	defer fake.generatePasswordMutex.Unlock()
+	fake.GeneratePasswordStub = stub
+}
+
+func (fake *FakeCredhub) GeneratePasswordArgsForCall(i int) (string, generate.Password, credhub.Mode) {
+	fake.generatePasswordMutex.RLock()
+	defer fake.generatePasswordMutex.RUnlock()
+	argsForCall := fake.generatePasswordArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeCredhub) GeneratePasswordReturns(result1 credentials.Password, result2 error) {
+	fake.generatePasswordMutex.Lock()
+	defer fake.generatePasswordMutex.Unlock()
+	fake.GeneratePasswordStub = nil
+	fake.generatePasswordReturns = struct {
+		result1 credentials.Password
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GeneratePasswordReturnsOnCall(i int, result1 credentials.Password, result2 error) {
+	fake.generatePasswordMutex.Lock()
+	defer fake.generatePasswordMutex.Unlock()
+	fake.GeneratePasswordStub = nil
+	if fake.generatePasswordReturnsOnCall == nil {
+		fake.generatePasswordReturnsOnCall = make(map[int]struct {
+			result1 credentials.Password
+			result2 error
+		})
+	}
+	fake.generatePasswordReturnsOnCall[i] = struct {
+		result1 credentials.Password
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GenerateUser(arg1 string, arg2 generate.User, arg3 credhub.Mode) (credentials.User, error) {
+	fake.generateUserMutex.Lock()
+	ret, specificReturn := fake.generateUserReturnsOnCall[len(fake.generateUserArgsForCall)]
+	fake.generateUserArgsForCall = append(fake.generateUserArgsForCall, struct {
+		arg1 string
+		arg2 generate.User
+		arg3 credhub.Mode
+	}{arg1, arg2, arg3})
+	stub := fake.GenerateUserStub
+	fakeReturns := fake.generateUserReturns
+	fake.recordInvocation("GenerateUser", []interface{}{arg1, arg2, arg3})
+	fake.generateUserMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) GenerateUserCallCount() int {
+	fake.generateUserMutex.RLock()
+	defer fake.generateUserMutex.RUnlock()
+	return len(fake.generateUserArgsForCall)
+}
+
+func (fake *FakeCredhub) GenerateUserCalls(stub func(string, generate.User, credhub.Mode) (credentials.User, error)) {
+	fake.generateUserMutex.Lock()
+	defer fake.generateUserMutex.Unlock()
+	fake.GenerateUserStub = stub
+}
+
+func (fake *FakeCredhub) GenerateUserArgsForCall(i int) (string, generate.User, credhub.Mode) {
+	fake.generateUserMutex.RLock()
+	defer fake.generateUserMutex.RUnlock()
+	argsForCall := fake.generateUserArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeCredhub) GenerateUserReturns(result1 credentials.User, result2 error) {
+	fake.generateUserMutex.Lock()
+	defer fake.generateUserMutex.Unlock()
+	fake.GenerateUserStub = nil
+	fake.generateUserReturns = struct {
+		result1 credentials.User
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GenerateUserReturnsOnCall(i int, result1 credentials.User, result2 error) {
+	fake.generateUserMutex.Lock()
+	defer fake.generateUserMutex.Unlock()
+	fake.GenerateUserStub = nil
+	if fake.generateUserReturnsOnCall == nil {
+		fake.generateUserReturnsOnCall = make(map[int]struct {
+			result1 credentials.User
+			result2 error
+		})
+	}
+	fake.generateUserReturnsOnCall[i] = struct {
+		result1 credentials.User
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeCredhub) GetLatestJSON(arg1 string) (credentials.JSON, error) {
 	fake.getLatestJSONMutex.Lock()
 	ret, specificReturn := fake.getLatestJSONReturnsOnCall[len(fake.getLatestJSONArgsForCall)]
@@ -345,6 +807,247 @@ func (fake *FakeCredhub) GetLatestValueReturnsOnCall(i int, result1 credentials.
 	}{result1, result2}
 }
 
+func (fake *FakeCredhub) GetPermissionByPathActor(arg1 string, arg2 string) (*permissions.Permission, error) {
+	fake.getPermissionByPathActorMutex.Lock()
+	ret, specificReturn := fake.getPermissionByPathActorReturnsOnCall[len(fake.getPermissionByPathActorArgsForCall)]
+	fake.getPermissionByPathActorArgsForCall = append(fake.getPermissionByPathActorArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetPermissionByPathActorStub
+	fakeReturns := fake.getPermissionByPathActorReturns
+	fake.recordInvocation("GetPermissionByPathActor", []interface{}{arg1, arg2})
+	fake.getPermissionByPathActorMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) GetPermissionByPathActorCallCount() int {
+	fake.getPermissionByPathActorMutex.RLock()
+	defer fake.getPermissionByPathActorMutex.RUnlock()
+	return len(fake.getPermissionByPathActorArgsForCall)
+}
+
+func (fake *FakeCredhub) GetPermissionByPathActorCalls(stub func(string, string) (*permissions.Permission, error)) {
+	fake.getPermissionByPathActorMutex.Lock()
+	defer fake.getPermissionByPathActorMutex.Unlock()
+	fake.GetPermissionByPathActorStub = stub
+}
+
+func (fake *FakeCredhub) GetPermissionByPathActorArgsForCall(i int) (string, string) {
+	fake.getPermissionByPathActorMutex.RLock()
+	defer fake.getPermissionByPathActorMutex.RUnlock()
+	argsForCall := fake.getPermissionByPathActorArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeCredhub) GetPermissionByPathActorReturns(result1 *permissions.Permission, result2 error) {
+	fake.getPermissionByPathActorMutex.Lock()
+	defer fake.getPermissionByPathActorMutex.Unlock()
+	fake.GetPermissionByPathActorStub = nil
+	fake.getPermissionByPathActorReturns = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) GetPermissionByPathActorReturnsOnCall(i int, result1 *permissions.Permission, result2 error) {
+	fake.getPermissionByPathActorMutex.Lock()
+	defer fake.getPermissionByPathActorMutex.Unlock()
+	fake.GetPermissionByPathActorStub = nil
+	if fake.getPermissionByPathActorReturnsOnCall == nil {
+		fake.getPermissionByPathActorReturnsOnCall = make(map[int]struct {
+			result1 *permissions.Permission
+			result2 error
+		})
+	}
+	fake.getPermissionByPathActorReturnsOnCall[i] = struct {
+		result1 *permissions.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) Info() (*server.Info, error) {
+	fake.infoMutex.Lock()
+	ret, specificReturn := fake.infoReturnsOnCall[len(fake.infoArgsForCall)]
+	fake.infoArgsForCall = append(fake.infoArgsForCall, struct {
+	}{})
+	stub := fake.InfoStub
+	fakeReturns := fake.infoReturns
+	fake.recordInvocation("Info", []interface{}{})
+	fake.infoMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) InfoCallCount() int {
+	fake.infoMutex.RLock()
+	defer fake.infoMutex.RUnlock()
+	return len(fake.infoArgsForCall)
+}
+
+func (fake *FakeCredhub) InfoCalls(stub func() (*server.Info, error)) {
+	fake.infoMutex.Lock()
+	defer fake.infoMutex.Unlock()
+	fake.InfoStub = stub
+}
+
+func (fake *FakeCredhub) InfoReturns(result1 *server.Info, result2 error) {
+	fake.infoMutex.Lock()
+	defer fake.infoMutex.Unlock()
+	fake.InfoStub = nil
+	fake.infoReturns = struct {
+		result1 *server.Info
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) InfoReturnsOnCall(i int, result1 *server.Info, result2 error) {
+	fake.infoMutex.Lock()
+	defer fake.infoMutex.Unlock()
+	fake.InfoStub = nil
+	if fake.infoReturnsOnCall == nil {
+		fake.infoReturnsOnCall = make(map[int]struct {
+			result1 *server.Info
+			result2 error
+		})
+	}
+	fake.infoReturnsOnCall[i] = struct {
+		result1 *server.Info
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) Regenerate(arg1 string) (credentials.Credential, error) {
+	fake.regenerateMutex.Lock()
+	ret, specificReturn := fake.regenerateReturnsOnCall[len(fake.regenerateArgsForCall)]
+	fake.regenerateArgsForCall = append(fake.regenerateArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.RegenerateStub
+	fakeReturns := fake.regenerateReturns
+	fake.recordInvocation("Regenerate", []interface{}{arg1})
+	fake.regenerateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) RegenerateCallCount() int {
+	fake.regenerateMutex.RLock()
+	defer fake.regenerateMutex.RUnlock()
+	return len(fake.regenerateArgsForCall)
+}
+
+func (fake *FakeCredhub) RegenerateCalls(stub func(string) (credentials.Credential, error)) {
+	fake.regenerateMutex.Lock()
+	defer fake.regenerateMutex.Unlock()
+	fake.RegenerateStub = stub
+}
+
+func (fake *FakeCredhub) RegenerateArgsForCall(i int) string {
+	fake.regenerateMutex.RLock()
+	defer fake.regenerateMutex.RUnlock()
+	argsForCall := fake.regenerateArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCredhub) RegenerateReturns(result1 credentials.Credential, result2 error) {
+	fake.regenerateMutex.Lock()
+	defer fake.regenerateMutex.Unlock()
+	fake.RegenerateStub = nil
+	fake.regenerateReturns = struct {
+		result1 credentials.Credential
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) RegenerateReturnsOnCall(i int, result1 credentials.Credential, result2 error) {
+	fake.regenerateMutex.Lock()
+	defer fake.regenerateMutex.Unlock()
+	fake.RegenerateStub = nil
+	if fake.regenerateReturnsOnCall == nil {
+		fake.regenerateReturnsOnCall = make(map[int]struct {
+			result1 credentials.Credential
+			result2 error
+		})
+	}
+	fake.regenerateReturnsOnCall[i] = struct {
+		result1 credentials.Credential
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) ServerVersion() (*version.Version, error) {
+	fake.serverVersionMutex.Lock()
+	ret, specificReturn := fake.serverVersionReturnsOnCall[len(fake.serverVersionArgsForCall)]
+	fake.serverVersionArgsForCall = append(fake.serverVersionArgsForCall, struct {
+	}{})
+	stub := fake.ServerVersionStub
+	fakeReturns := fake.serverVersionReturns
+	fake.recordInvocation("ServerVersion", []interface{}{})
+	fake.serverVersionMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCredhub) ServerVersionCallCount() int {
+	fake.serverVersionMutex.RLock()
+	defer fake.serverVersionMutex.RUnlock()
+	return len(fake.serverVersionArgsForCall)
+}
+
+func (fake *FakeCredhub) ServerVersionCalls(stub func() (*version.Version, error)) {
+	fake.serverVersionMutex.Lock()
+	defer fake.serverVersionMutex.Unlock()
+	fake.ServerVersionStub = stub
+}
+
+func (fake *FakeCredhub) ServerVersionReturns(result1 *version.Version, result2 error) {
+	fake.serverVersionMutex.Lock()
+	defer fake.serverVersionMutex.Unlock()
+	fake.ServerVersionStub = nil
+	fake.serverVersionReturns = struct {
+		result1 *version.Version
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCredhub) ServerVersionReturnsOnCall(i int, result1 *version.Version, result2 error) {
+	fake.serverVersionMutex.Lock()
+	defer fake.serverVersionMutex.Unlock()
+	fake.ServerVersionStub = nil
+	if fake.serverVersionReturnsOnCall == nil {
+		fake.serverVersionReturnsOnCall = make(map[int]struct {
+			result1 *version.Version
+			result2 error
+		})
+	}
+	fake.serverVersionReturnsOnCall[i] = struct {
+		result1 *version.Version
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeCredhub) SetJSON(arg1 string, arg2 values.JSON) (credentials.JSON, error) {
 	fake.setJSONMutex.Lock()
 	ret, specificReturn := fake.setJSONReturnsOnCall[len(fake.setJSONArgsForCall)]
@@ -478,18 +1181,6 @@ func (fake *FakeCredhub) SetValueReturnsOnCall(i int, result1 credentials.Value,
 func (fake *FakeCredhub) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.deleteMutex.RLock()
-	defer fake.deleteMutex.RUnlock()
-	fake.findByPathMutex.RLock()
-	defer fake.findByPathMutex.RUnlock()
-	fake.getLatestJSONMutex.RLock()
-	defer fake.getLatestJSONMutex.RUnlock()
-	fake.getLatestValueMutex.RLock()
-	defer fake.getLatestValueMutex.RUnlock()
-	fake.setJSONMutex.RLock()
-	defer fake.setJSONMutex.RUnlock()
-	fake.setValueMutex.RLock()
-	defer fake.setValueMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -4,7 +4,11 @@ import (
 	"code.cloudfoundry.org/credhub-cli/credhub"
 	"code.cloudfoundry.org/credhub-cli/credhub/auth"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
+	"github.com/hashicorp/go-version"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
@@ -28,6 +32,15 @@ type Credhub interface {
 	GetLatestValue(name string) (credentials.Value, error)
 	FindByPath(path string) (credentials.FindResults, error)
 	Delete(name string) error
+	Info() (*server.Info, error)
+	ServerVersion() (*version.Version, error)
+	AddPermission(path string, actor string, ops []string) (*permissions.Permission, error)
+	GetPermissionByPathActor(path string, actor string) (*permissions.Permission, error)
+	DeletePermission(uuid string) (*permissions.Permission, error)
+	GeneratePassword(name string, gen generate.Password, overwrite credhub.Mode) (credentials.Password, error)
+	GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error)
+	GenerateCertificate(name string, gen generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error)
+	Regenerate(name string) (credentials.Credential, error)
 }
 
 type CredhubShim struct {
@@ -93,3 +106,39 @@ func (ch *CredhubShim) FindByPath(path string) (credentials.FindResults, error)
 func (ch *CredhubShim) Delete(name string) error {
 	return ch.delegate.Delete(name)
 }
+
+func (ch *CredhubShim) Info() (*server.Info, error) {
+	return ch.delegate.Info()
+}
+
+func (ch *CredhubShim) ServerVersion() (*version.Version, error) {
+	return ch.delegate.ServerVersion()
+}
+
+func (ch *CredhubShim) AddPermission(path string, actor string, ops []string) (*permissions.Permission, error) {
+	return ch.delegate.AddPermission(path, actor, ops)
+}
+
+func (ch *CredhubShim) GetPermissionByPathActor(path string, actor string) (*permissions.Permission, error) {
+	return ch.delegate.GetPermissionByPathActor(path, actor)
+}
+
+func (ch *CredhubShim) DeletePermission(uuid string) (*permissions.Permission, error) {
+	return ch.delegate.DeletePermission(uuid)
+}
+
+func (ch *CredhubShim) GeneratePassword(name string, gen generate.Password, overwrite credhub.Mode) (credentials.Password, error) {
+	return ch.delegate.GeneratePassword(name, gen, overwrite)
+}
+
+func (ch *CredhubShim) GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error) {
+	return ch.delegate.GenerateUser(name, gen, overwrite)
+}
+
+func (ch *CredhubShim) GenerateCertificate(name string, gen generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error) {
+	return ch.delegate.GenerateCertificate(name, gen, overwrite)
+}
+
+func (ch *CredhubShim) Regenerate(name string) (credentials.Credential, error) {
+	return ch.delegate.Regenerate(name)
+}
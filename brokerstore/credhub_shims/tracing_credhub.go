@@ -0,0 +1,174 @@
+package credhub_shims
+
+import (
+	"context"
+	"strconv"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/tracing"
+	"github.com/hashicorp/go-version"
+)
+
+// ContextualCredhub is implemented by Credhub shims that can carry a context,
+// e.g. to parent their tracing spans.
+type ContextualCredhub interface {
+	WithContext(ctx context.Context) Credhub
+}
+
+// TracingCredhub starts a span around every call to the wrapped shim. Time
+// spent fetching UAA tokens happens inside the CredHub client and is included
+// in the span of the call that triggered it.
+type TracingCredhub struct {
+	delegate Credhub
+	tracer   tracing.Tracer
+	ctx      context.Context
+}
+
+func NewTracingCredhub(delegate Credhub, tracer tracing.Tracer) *TracingCredhub {
+	return &TracingCredhub{
+		delegate: delegate,
+		tracer:   tracer,
+		ctx:      context.Background(),
+	}
+}
+
+func (ch *TracingCredhub) WithContext(ctx context.Context) Credhub {
+	withContext := *ch
+	withContext.ctx = ctx
+	return &withContext
+}
+
+func (ch *TracingCredhub) SetJSON(name string, value values.JSON) (credentials.JSON, error) {
+	span := ch.start("credhub.SetJSON", name)
+	result, err := ch.delegate.SetJSON(name, value)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GetLatestJSON(name string) (credentials.JSON, error) {
+	span := ch.start("credhub.GetLatestJSON", name)
+	result, err := ch.delegate.GetLatestJSON(name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) SetValue(name string, value values.Value) (credentials.Value, error) {
+	span := ch.start("credhub.SetValue", name)
+	result, err := ch.delegate.SetValue(name, value)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GetLatestValue(name string) (credentials.Value, error) {
+	span := ch.start("credhub.GetLatestValue", name)
+	result, err := ch.delegate.GetLatestValue(name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) FindByPath(path string) (credentials.FindResults, error) {
+	span := ch.start("credhub.FindByPath", path)
+	result, err := ch.delegate.FindByPath(path)
+	if err == nil {
+		span.SetAttributes(tracing.String("credhub.results", strconv.Itoa(len(result.Credentials))))
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) Delete(name string) error {
+	span := ch.start("credhub.Delete", name)
+	err := ch.delegate.Delete(name)
+	endSpan(span, err)
+	return err
+}
+
+func (ch *TracingCredhub) Info() (*server.Info, error) {
+	span := ch.start("credhub.Info", "")
+	result, err := ch.delegate.Info()
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) ServerVersion() (*version.Version, error) {
+	span := ch.start("credhub.ServerVersion", "")
+	result, err := ch.delegate.ServerVersion()
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) AddPermission(path string, actor string, ops []string) (*permissions.Permission, error) {
+	span := ch.start("credhub.AddPermission", path)
+	span.SetAttributes(tracing.String("credhub.actor", actor))
+	result, err := ch.delegate.AddPermission(path, actor, ops)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GetPermissionByPathActor(path string, actor string) (*permissions.Permission, error) {
+	span := ch.start("credhub.GetPermissionByPathActor", path)
+	span.SetAttributes(tracing.String("credhub.actor", actor))
+	result, err := ch.delegate.GetPermissionByPathActor(path, actor)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) DeletePermission(uuid string) (*permissions.Permission, error) {
+	span := ch.start("credhub.DeletePermission", "")
+	span.SetAttributes(tracing.String("credhub.permission", uuid))
+	result, err := ch.delegate.DeletePermission(uuid)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GeneratePassword(name string, gen generate.Password, overwrite credhub.Mode) (credentials.Password, error) {
+	span := ch.start("credhub.GeneratePassword", name)
+	result, err := ch.delegate.GeneratePassword(name, gen, overwrite)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GenerateUser(name string, gen generate.User, overwrite credhub.Mode) (credentials.User, error) {
+	span := ch.start("credhub.GenerateUser", name)
+	result, err := ch.delegate.GenerateUser(name, gen, overwrite)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) GenerateCertificate(name string, gen generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error) {
+	span := ch.start("credhub.GenerateCertificate", name)
+	result, err := ch.delegate.GenerateCertificate(name, gen, overwrite)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) Regenerate(name string) (credentials.Credential, error) {
+	span := ch.start("credhub.Regenerate", name)
+	result, err := ch.delegate.Regenerate(name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (ch *TracingCredhub) start(operation, name string) tracing.Span {
+	var attributes []tracing.Attribute
+	if name != "" {
+		attributes = append(attributes, tracing.String("credhub.name", name))
+	}
+
+	_, span := ch.tracer.Start(ch.ctx, operation, attributes...)
+	return span
+}
+
+func endSpan(span tracing.Span, err error) {
+	if err != nil {
+		span.SetAttributes(tracing.String("outcome", "error"))
+	} else {
+		span.SetAttributes(tracing.String("outcome", "success"))
+	}
+	span.End(err)
+}
@@ -0,0 +1,65 @@
+package credhub_shims_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TracingCredhub", func() {
+	var (
+		tracer      *tracing.MemoryTracer
+		fakeCredhub *credhub_fakes.FakeCredhub
+		shim        *credhub_shims.TracingCredhub
+	)
+
+	BeforeEach(func() {
+		tracer = tracing.NewMemoryTracer()
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		shim = credhub_shims.NewTracingCredhub(fakeCredhub, tracer)
+	})
+
+	It("should delegate and record a span for each call", func() {
+		fakeCredhub.FindByPathReturns(credentials.FindResults{Credentials: make([]struct {
+			Name             string `json:"name" yaml:"name"`
+			VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+		}, 2)}, nil)
+
+		_, err := shim.FindByPath("/some-path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeCredhub.FindByPathArgsForCall(0)).To(Equal("/some-path"))
+
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("credhub.FindByPath"))
+		Expect(spans[0].Attributes).To(Equal(map[string]string{
+			"credhub.name":    "/some-path",
+			"credhub.results": "2",
+			"outcome":         "success",
+		}))
+	})
+
+	It("should record errors", func() {
+		fakeCredhub.DeleteReturns(errors.New("bad-delete"))
+
+		Expect(shim.Delete("/some-name")).To(MatchError("bad-delete"))
+		Expect(tracer.Spans()[0].Err).To(MatchError("bad-delete"))
+		Expect(tracer.Spans()[0].Attributes).To(HaveKeyWithValue("outcome", "error"))
+	})
+
+	It("should parent spans to the context it was given", func() {
+		ctx, parent := tracer.Start(context.Background(), "parent")
+		_, err := shim.WithContext(ctx).GetLatestValue("/some-name")
+		Expect(err).NotTo(HaveOccurred())
+		parent.End(nil)
+
+		spans := tracer.Spans()
+		Expect(spans[0].ParentID).To(Equal(spans[1].SpanID))
+	})
+})
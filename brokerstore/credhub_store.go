@@ -1,8 +1,12 @@
 package brokerstore
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/brokerapi/v13/domain"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
@@ -10,23 +14,78 @@ import (
 	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
 )
 
+const activationMarker = "migrated-from-sql"
+
 type CredhubStore struct {
-	logger      lager.Logger
-	credhubShim credhub_shims.Credhub
-	storeID     string
+	logger       lager.Logger
+	credhubShim  credhub_shims.Credhub
+	storeID      string
+	capabilities *capabilitiesCache
+	schema       *recordSchema
+	indexes      *credhubIndexes
+}
+
+type capabilitiesCache struct {
+	mutex        sync.Mutex
+	detected     bool
+	capabilities credhub_shims.Capabilities
 }
 
 func NewCredhubStore(logger lager.Logger, credhubShim credhub_shims.Credhub, storeID string) *CredhubStore {
 	return &CredhubStore{
-		logger:      logger,
-		credhubShim: credhubShim,
-		storeID:     storeID,
+		logger:       logger,
+		credhubShim:  credhubShim,
+		storeID:      storeID,
+		capabilities: &capabilitiesCache{},
+		schema:       newRecordSchema(),
+		indexes:      &credhubIndexes{},
+	}
+}
+
+// Capabilities returns what the CredHub server supports. The server version
+// is only fetched until detection first succeeds; the result is then cached.
+func (s *CredhubStore) Capabilities() (credhub_shims.Capabilities, error) {
+	s.capabilities.mutex.Lock()
+	defer s.capabilities.mutex.Unlock()
+
+	if s.capabilities.detected {
+		return s.capabilities.capabilities, nil
+	}
+
+	capabilities, err := credhub_shims.DetectCapabilities(s.credhubShim)
+	if err != nil {
+		return credhub_shims.Capabilities{}, err
 	}
+
+	s.logger.Info("detected-credhub-capabilities", lager.Data{"capabilities": capabilities})
+	s.capabilities.detected = true
+	s.capabilities.capabilities = capabilities
+	return capabilities, nil
+}
+
+// requireFeature returns an UnsupportedFeatureError if the server lacks feature
+func (s *CredhubStore) requireFeature(feature credhub_shims.Feature) error {
+	capabilities, err := s.Capabilities()
+	if err != nil {
+		return err
+	}
+	return capabilities.Require(feature)
+}
+
+func (s *CredhubStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	if contextual, ok := s.credhubShim.(credhub_shims.ContextualCredhub); ok {
+		withContext.credhubShim = contextual.WithContext(ctx)
+	}
+	return &withContext
 }
 
 func (s *CredhubStore) Activate() error {
-	s.logger.Info("activating-credhub")
-	_, err := s.credhubShim.SetValue(s.namespaced("migrated-from-sql"), "true")
+	logger := s.logger.Session("activate")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	_, err := s.credhubShim.SetValue(s.namespaced(activationMarker), "true")
 	if err != nil {
 		return err
 	}
@@ -39,7 +98,7 @@ func (s *CredhubStore) IsActivated() (bool, error) {
 	logger.Info("start")
 	defer logger.Info("end")
 
-	results, err := s.credhubShim.FindByPath(s.namespaced("migrated-from-sql"))
+	results, err := s.credhubShim.FindByPath(s.namespaced(activationMarker))
 	if err != nil {
 		return false, err
 	}
@@ -48,7 +107,7 @@ func (s *CredhubStore) IsActivated() (bool, error) {
 }
 
 func (s *CredhubStore) CreateInstanceDetails(id string, details ServiceInstance) error {
-	logger := s.logger.Session("create-instance-details")
+	logger := s.logger.Session("create-instance-details", recordData(InstanceRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
@@ -56,25 +115,21 @@ func (s *CredhubStore) CreateInstanceDetails(id string, details ServiceInstance)
 	if err != nil {
 		return err
 	}
-	_, err = s.credhubShim.SetJSON(s.namespaced(id), mappedDetails)
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.writeIndexedRecord(logger, InstanceRecord, id, mappedDetails)
 }
 
 func (s *CredhubStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
-	logger := s.logger.Session("retrieve-instance-details")
+	logger := s.logger.Session("retrieve-instance-details", recordData(InstanceRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
-	creds, err := s.credhubShim.GetLatestJSON(s.namespaced(id))
+	record, err := s.retrieveRecord(id)
 	if err != nil {
 		return ServiceInstance{}, err
 	}
 
 	var serviceInstance ServiceInstance
-	err = toStruct(creds, &serviceInstance)
+	err = toStruct(record.Value, &serviceInstance)
 	if err != nil {
 		return ServiceInstance{}, err
 	}
@@ -83,17 +138,17 @@ func (s *CredhubStore) RetrieveInstanceDetails(id string) (ServiceInstance, erro
 }
 
 func (s *CredhubStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
-	logger := s.logger.Session("retrieve-binding-details")
+	logger := s.logger.Session("retrieve-binding-details", recordData(BindingRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
-	creds, err := s.credhubShim.GetLatestJSON(s.namespaced(id))
+	record, err := s.retrieveRecord(id)
 	if err != nil {
 		return domain.BindDetails{}, err
 	}
 
 	var bindDetails domain.BindDetails
-	err = toStruct(creds, &bindDetails)
+	err = toStruct(record.Value, &bindDetails)
 	if err != nil {
 		return domain.BindDetails{}, err
 	}
@@ -102,15 +157,61 @@ func (s *CredhubStore) RetrieveBindingDetails(id string) (domain.BindDetails, er
 }
 
 func (s *CredhubStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
-	panic("Not Implemented")
+	logger := s.logger.Session("retrieve-all-instance-details", lager.Data{"kind": InstanceRecord})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]ServiceInstance{}
+	for id, record := range records {
+		if record.Err != nil || record.Kind != InstanceRecord {
+			continue
+		}
+
+		var serviceInstance ServiceInstance
+		if err := toStruct(record.Value, &serviceInstance); err != nil {
+			logger.Error("failed-to-decode-instance", err, lager.Data{"id": id})
+			continue
+		}
+		instances[id] = serviceInstance
+	}
+
+	return instances, nil
 }
 
 func (s *CredhubStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
-	panic("Not Implemented")
+	logger := s.logger.Session("retrieve-all-binding-details", lager.Data{"kind": BindingRecord})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := map[string]domain.BindDetails{}
+	for id, record := range records {
+		if record.Err != nil || record.Kind != BindingRecord {
+			continue
+		}
+
+		var bindDetails domain.BindDetails
+		if err := toStruct(record.Value, &bindDetails); err != nil {
+			logger.Error("failed-to-decode-binding", err, lager.Data{"id": id})
+			continue
+		}
+		bindings[id] = bindDetails
+	}
+
+	return bindings, nil
 }
 
 func (s *CredhubStore) CreateBindingDetails(id string, details domain.BindDetails) error {
-	logger := s.logger.Session("create-binding-details")
+	logger := s.logger.Session("create-binding-details", recordData(BindingRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
@@ -118,27 +219,38 @@ func (s *CredhubStore) CreateBindingDetails(id string, details domain.BindDetail
 	if err != nil {
 		return err
 	}
+	return s.writeIndexedRecord(logger, BindingRecord, id, mappedDetails)
+}
 
-	_, err = s.credhubShim.SetJSON(s.namespaced(id), mappedDetails)
-	if err != nil {
-		return err
-	}
-	return nil
+func (s *CredhubStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	logger := s.logger.Session("update-instance-details", recordData(InstanceRecord, id))
+	logger.Info("start")
+	defer logger.Info("end")
+
+	return updateInstanceDetails(s, id, details)
 }
 
 func (s *CredhubStore) DeleteInstanceDetails(id string) error {
-	logger := s.logger.Session("delete-instance-details")
+	logger := s.logger.Session("delete-instance-details", recordData(InstanceRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
-	return s.credhubShim.Delete(s.namespaced(id))
+	return s.deleteIndexedRecord(logger, id)
 }
+
+// DeleteBindingDetails also revokes and deletes any credentials stored under
+// the binding, so that unbind leaves nothing readable behind. The record is
+// kept if that fails, so a retried unbind can finish the job.
 func (s *CredhubStore) DeleteBindingDetails(id string) error {
-	logger := s.logger.Session("delete-binding-details")
+	logger := s.logger.Session("delete-binding-details", recordData(BindingRecord, id))
 	logger.Info("start")
 	defer logger.Info("end")
 
-	return s.credhubShim.Delete(s.namespaced(id))
+	if err := s.deleteAllBindingCredentials(logger, id); err != nil {
+		logger.Error("failed-to-delete-binding-credentials", err)
+		return err
+	}
+	return s.deleteIndexedRecord(logger, id)
 }
 
 func (s *CredhubStore) IsInstanceConflict(id string, details ServiceInstance) bool {
@@ -161,7 +273,68 @@ func (s *CredhubStore) Cleanup() error {
 }
 
 func (s *CredhubStore) namespaced(id string) string {
-	return fmt.Sprintf("/%s/%s", s.storeID, id)
+	return namespacedIn(s.storeID, id)
+}
+
+// retrieveAllRecords reads every record stored under the given store ID.
+// Records that cannot be read are returned with Err set so that callers can
+// decide whether to skip or report them.
+func (s *CredhubStore) retrieveAllRecords(storeID string) (map[string]credhubRecord, error) {
+	prefix := namespacedIn(storeID, "")
+	results, err := s.credhubShim.FindByPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]credhubRecord{}
+	for _, result := range results.Credentials {
+		id, ok := recordID(prefix, result.Name)
+		if !ok {
+			continue
+		}
+
+		creds, err := s.credhubShim.GetLatestJSON(result.Name)
+		if err != nil {
+			records[id] = credhubRecord{Kind: UnknownRecord, Err: err}
+			continue
+		}
+		record, err := s.openRecord(id, creds)
+		if err != nil {
+			records[id] = credhubRecord{Kind: UnknownRecord, Err: err}
+			continue
+		}
+		records[id] = record
+	}
+
+	return records, nil
+}
+
+// credhubRecord is a record read back from CredHub. Value holds the bare
+// record, upgraded to SchemaVersion; StoredVersion and Enveloped describe it
+// as it was stored.
+type credhubRecord struct {
+	Kind          RecordKind
+	Value         credentials.JSON
+	Err           error
+	Enveloped     bool
+	StoredVersion int
+	SchemaVersion int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// recordID returns the ID of the record with the given credential name, or
+// false if the name is the activation marker or nested under a record.
+func recordID(prefix, name string) (string, bool) {
+	id := strings.TrimPrefix(name, prefix)
+	if id == name || id == "" || id == activationMarker || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+func namespacedIn(storeID, id string) string {
+	return fmt.Sprintf("/%s/%s", storeID, id)
 }
 
 func toMap(subject interface{}) (map[string]interface{}, error) {
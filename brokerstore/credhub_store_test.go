@@ -3,14 +3,17 @@ package brokerstore_test
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 
 	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/lager/v3/lagertest"
 	. "code.cloudfoundry.org/service-broker-store/brokerstore"
 	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"golang.org/x/crypto/bcrypt"
@@ -68,7 +71,10 @@ var _ = Describe("CredhubStore", func() {
 			Expect(fakeCredhub.SetJSONCallCount()).To(Equal(1))
 			id, value := fakeCredhub.SetJSONArgsForCall(0)
 			Expect(id).To(Equal("/some-store-id/12345"))
-			actualJSON, err := json.Marshal(value)
+			Expect(value["schema_version"]).To(BeEquivalentTo(BaseSchemaVersion))
+			Expect(value).To(HaveKey("created_at"))
+			Expect(value["updated_at"]).To(Equal(value["created_at"]))
+			actualJSON, err := json.Marshal(value["record"])
 			Expect(err).NotTo(HaveOccurred())
 			Expect(actualJSON).To(MatchJSON(expectedJSON))
 		})
@@ -186,6 +192,91 @@ var _ = Describe("CredhubStore", func() {
 		})
 	})
 
+	Context("#RetrieveAllInstanceDetails", func() {
+		var instances map[string]ServiceInstance
+
+		BeforeEach(func() {
+			fakeCredhub.FindByPathReturns(findResults(
+				"/some-store-id/migrated-from-sql",
+				"/some-store-id/instance-1",
+				"/some-store-id/binding-1",
+				"/some-store-id/broken",
+			), nil)
+			fakeCredhub.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+				switch name {
+				case "/some-store-id/instance-1":
+					return credentials.JSON{Value: values.JSON{
+						"service_id":        "service-id",
+						"plan_id":           "plan-id",
+						"organization_guid": "org-guid",
+						"space_guid":        "space-guid",
+					}}, nil
+				case "/some-store-id/binding-1":
+					return credentials.JSON{Value: values.JSON{"app_guid": "app-guid", "plan_id": "plan-id"}}, nil
+				}
+				return credentials.JSON{}, errors.New("bad-get-latest-json")
+			}
+		})
+
+		JustBeforeEach(func() {
+			instances, err = store.RetrieveAllInstanceDetails()
+		})
+
+		It("should return only the instance records under the store path", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCredhub.FindByPathArgsForCall(0)).To(Equal("/some-store-id/"))
+			Expect(instances).To(Equal(map[string]ServiceInstance{
+				"instance-1": {
+					ServiceID:        "service-id",
+					PlanID:           "plan-id",
+					OrganizationGUID: "org-guid",
+					SpaceGUID:        "space-guid",
+				},
+			}))
+		})
+
+		It("should not read the activation marker", func() {
+			for i := 0; i < fakeCredhub.GetLatestJSONCallCount(); i++ {
+				Expect(fakeCredhub.GetLatestJSONArgsForCall(i)).NotTo(Equal("/some-store-id/migrated-from-sql"))
+			}
+		})
+
+		Context("when FindByPath returns an error", func() {
+			BeforeEach(func() {
+				fakeCredhub.FindByPathReturns(credentials.FindResults{}, errors.New("bad-find-by-path"))
+			})
+
+			It("should return the error", func() {
+				Expect(err).To(MatchError("bad-find-by-path"))
+			})
+		})
+	})
+
+	Context("#RetrieveAllBindingDetails", func() {
+		var bindings map[string]domain.BindDetails
+
+		BeforeEach(func() {
+			fakeCredhub.FindByPathReturns(findResults("/some-store-id/instance-1", "/some-store-id/binding-1"), nil)
+			fakeCredhub.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+				if name == "/some-store-id/binding-1" {
+					return credentials.JSON{Value: values.JSON{"app_guid": "app-guid", "plan_id": "plan-id", "service_id": "service-id"}}, nil
+				}
+				return credentials.JSON{Value: values.JSON{"organization_guid": "org-guid"}}, nil
+			}
+		})
+
+		JustBeforeEach(func() {
+			bindings, err = store.RetrieveAllBindingDetails()
+		})
+
+		It("should return only the binding records under the store path", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(Equal(map[string]domain.BindDetails{
+				"binding-1": {AppGUID: "app-guid", PlanID: "plan-id", ServiceID: "service-id"},
+			}))
+		})
+	})
+
 	Context("#CreateBindingDetails", func() {
 		var (
 			id           string
@@ -220,7 +311,10 @@ var _ = Describe("CredhubStore", func() {
 			Expect(fakeCredhub.SetJSONCallCount()).To(Equal(1))
 			id, value := fakeCredhub.SetJSONArgsForCall(0)
 			Expect(id).To(Equal("/some-store-id/12345"))
-			actualJSON, err := json.Marshal(value)
+			Expect(value["schema_version"]).To(BeEquivalentTo(BaseSchemaVersion))
+			Expect(value).To(HaveKey("created_at"))
+			Expect(value["updated_at"]).To(Equal(value["created_at"]))
+			actualJSON, err := json.Marshal(value["record"])
 			Expect(err).NotTo(HaveOccurred())
 			Expect(actualJSON).To(MatchJSON(expectedJSON))
 		})
@@ -339,6 +433,35 @@ var _ = Describe("CredhubStore", func() {
 			})
 			Expect(isConflict).To(BeTrue())
 		})
+
+		It("returns false when only the fingerprint's number encoding differs", func() {
+			fakeCredhub.GetLatestJSONReturns(credentials.JSON{
+				Value: values.JSON{
+					"organization_guid":  "org-guid",
+					"ServiceFingerPrint": map[string]interface{}{"uid": float64(1000)},
+				},
+			}, nil)
+
+			isConflict := store.IsInstanceConflict(id, ServiceInstance{
+				OrganizationGUID:   "org-guid",
+				ServiceFingerPrint: map[string]interface{}{"uid": 1000},
+			})
+			Expect(isConflict).To(BeFalse())
+		})
+
+		It("returns true when the existing instance cannot be read", func() {
+			fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, errors.New("bad-get-latest-json"))
+
+			isConflict := store.IsInstanceConflict(id, ServiceInstance{})
+			Expect(isConflict).To(BeTrue())
+		})
+
+		It("returns false when there is no existing instance", func() {
+			fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, &credhub.NotFoundError{})
+
+			isConflict := store.IsInstanceConflict(id, ServiceInstance{})
+			Expect(isConflict).To(BeFalse())
+		})
 	})
 
 	Context("#IsBindingConflict", func() {
@@ -394,6 +517,43 @@ var _ = Describe("CredhubStore", func() {
 		})
 	})
 
+	Context("#Capabilities", func() {
+		BeforeEach(func() {
+			fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.5.0")), nil)
+		})
+
+		It("should detect them from the server version", func() {
+			capabilities, err := store.Capabilities()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(capabilities.ServerVersion).To(Equal("2.5.0"))
+			Expect(capabilities.PermissionsV2).To(BeTrue())
+			Expect(capabilities.CredentialMetadata).To(BeFalse())
+		})
+
+		It("should only ask the server once", func() {
+			_, err := store.Capabilities()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = store.Capabilities()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCredhub.ServerVersionCallCount()).To(Equal(1))
+		})
+
+		Context("when detection fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.ServerVersionReturnsOnCall(0, nil, errors.New("bad-version"))
+			})
+
+			It("should retry on the next call", func() {
+				_, err := store.Capabilities()
+				Expect(err).To(MatchError("bad-version"))
+
+				capabilities, err := store.Capabilities()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(capabilities.ServerVersion).To(Equal("2.5.0"))
+			})
+		})
+	})
+
 	Context("#Activate", func() {
 		JustBeforeEach(func() {
 			err = store.Activate()
@@ -461,3 +621,43 @@ var _ = Describe("CredhubStore", func() {
 		})
 	})
 })
+
+func findResults(names ...string) credentials.FindResults {
+	results := credentials.FindResults{}
+	for _, name := range names {
+		results.Credentials = append(results.Credentials, struct {
+			Name             string `json:"name" yaml:"name"`
+			VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+		}{Name: name})
+	}
+	return results
+}
+
+// backCredhubWith makes the fake behave like a CredHub server holding the
+// given JSON records, keyed by their full credential names.
+func backCredhubWith(fakeCredhub *credhub_fakes.FakeCredhub, records map[string]values.JSON) {
+	fakeCredhub.FindByPathStub = func(path string) (credentials.FindResults, error) {
+		names := []string{}
+		for name := range records {
+			if strings.HasPrefix(name, path) {
+				names = append(names, name)
+			}
+		}
+		return findResults(names...), nil
+	}
+	fakeCredhub.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+		value, ok := records[name]
+		if !ok {
+			return credentials.JSON{}, &credhub.NotFoundError{Description: "The request could not be completed because the credential does not exist or you do not have sufficient authorization."}
+		}
+		return credentials.JSON{Value: value}, nil
+	}
+	fakeCredhub.SetJSONStub = func(name string, value values.JSON) (credentials.JSON, error) {
+		records[name] = value
+		return credentials.JSON{Value: value}, nil
+	}
+	fakeCredhub.DeleteStub = func(name string) error {
+		delete(records, name)
+		return nil
+	}
+}
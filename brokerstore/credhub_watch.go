@@ -0,0 +1,155 @@
+package brokerstore
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// DefaultWatchInterval is how often CredhubStore.Watch polls CredHub
+const DefaultWatchInterval = 10 * time.Second
+
+// Watch polls CredHub every DefaultWatchInterval and delivers an event for
+// each record created, updated or deleted since the previous poll.
+func (s *CredhubStore) Watch(ctx context.Context, filter WatchFilter) <-chan Event {
+	return s.WatchEvery(ctx, filter, DefaultWatchInterval)
+}
+
+// WatchEvery is Watch with a custom poll interval. Records present when it is
+// called are read before it returns and taken as the baseline, producing no
+// events; list the store first if the initial state is needed.
+//
+// Changes are detected from each credential's version_created_at as returned
+// by a path search, and confirmed against its version ID, so unchanged
+// records cost no further reads. Several changes to one record between polls
+// are seen as a single update. Failed polls are logged and retried on the
+// next tick.
+func (s *CredhubStore) WatchEvery(ctx context.Context, filter WatchFilter, every time.Duration) <-chan Event {
+	events := make(chan Event, watchBufferSize)
+
+	logger := s.logger.Session("watch")
+	poller := &credhubPoller{store: s, seen: map[string]seenCredential{}}
+	baselined := poller.poll(logger, nil) == nil
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !baselined {
+				baselined = poller.poll(logger, nil) == nil
+				continue
+			}
+
+			poller.poll(logger, func(event Event) {
+				if !filter.Matches(event) {
+					return
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+				}
+			})
+		}
+	}()
+
+	return events
+}
+
+type seenCredential struct {
+	kind             RecordKind
+	versionID        string
+	versionCreatedAt string
+}
+
+type credhubPoller struct {
+	store *CredhubStore
+	seen  map[string]seenCredential
+}
+
+// poll compares the store with the previous poll, passing each change to
+// emit. A nil emit just records the current state.
+func (p *credhubPoller) poll(logger lager.Logger, emit func(Event)) error {
+	prefix := p.store.namespaced("")
+	results, err := p.store.credhubShim.FindByPath(prefix)
+	if err != nil {
+		logger.Error("failed-to-poll", err)
+		return err
+	}
+
+	present := map[string]bool{}
+	for _, result := range results.Credentials {
+		id, ok := recordID(prefix, result.Name)
+		if !ok {
+			continue
+		}
+		present[id] = true
+
+		previous, known := p.seen[id]
+		if known && previous.versionCreatedAt == result.VersionCreatedAt {
+			continue
+		}
+
+		creds, err := p.store.credhubShim.GetLatestJSON(result.Name)
+		if err != nil {
+			// Picked up again on the next poll, or seen as deleted
+			logger.Error("failed-to-read-changed-record", err, lager.Data{"id": id})
+			continue
+		}
+
+		record, err := p.store.openRecord(id, creds)
+		if err != nil {
+			logger.Error("failed-to-open-changed-record", err, lager.Data{"id": id})
+			continue
+		}
+
+		current := seenCredential{
+			kind:             record.Kind,
+			versionID:        creds.Id,
+			versionCreatedAt: result.VersionCreatedAt,
+		}
+		p.seen[id] = current
+		if known && previous.versionID == current.versionID {
+			continue
+		}
+		if emit == nil {
+			continue
+		}
+
+		event := Event{Type: EventCreate, Kind: current.kind, ID: id, VersionID: current.versionID}
+		if known {
+			event.Type = EventUpdate
+		}
+		switch current.kind {
+		case InstanceRecord:
+			err = toStruct(record.Value, &event.Instance)
+		case BindingRecord:
+			err = toStruct(record.Value, &event.Binding)
+		}
+		if err != nil {
+			logger.Error("failed-to-decode-changed-record", err, lager.Data{"id": id})
+		}
+		emit(event)
+	}
+
+	for id, previous := range p.seen {
+		if present[id] {
+			continue
+		}
+		delete(p.seen, id)
+		if emit != nil {
+			emit(Event{Type: EventDelete, Kind: previous.kind, ID: id, VersionID: previous.versionID})
+		}
+	}
+
+	return nil
+}
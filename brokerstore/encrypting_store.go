@@ -0,0 +1,427 @@
+package brokerstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const sealedAlgorithm = "AES-256-GCM"
+
+var ErrUnknownKeyID = errors.New("record is sealed with an unknown key id")
+
+// Keyring holds the key-encryption keys used to wrap per-record data keys.
+// New records are always sealed under the primary key; the others are kept
+// so that records sealed before a rotation can still be opened.
+type Keyring struct {
+	primaryKeyID string
+	keys         map[string][]byte
+}
+
+func NewKeyring(primaryKeyID string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primaryKeyID]; !ok {
+		return nil, fmt.Errorf("primary key id %q is not in the keyring", primaryKeyID)
+	}
+
+	copied := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+		copied[id] = append([]byte(nil), key...)
+	}
+
+	return &Keyring{primaryKeyID: primaryKeyID, keys: copied}, nil
+}
+
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primaryKeyID
+}
+
+type sealedRecord struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// seal encrypts plaintext under a fresh data key, which is itself encrypted
+// under the primary key. The record kind and ID are bound in as additional
+// data so a sealed record cannot be replayed under another ID.
+func (k *Keyring) seal(kind RecordKind, id string, plaintext []byte) (sealedRecord, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return sealedRecord{}, err
+	}
+
+	wrappedKey, err := gcmSeal(k.keys[k.primaryKeyID], dataKey, []byte(k.primaryKeyID))
+	if err != nil {
+		return sealedRecord{}, err
+	}
+
+	sealed, err := gcmSeal(dataKey, plaintext, associatedData(kind, id))
+	if err != nil {
+		return sealedRecord{}, err
+	}
+
+	return sealedRecord{
+		Algorithm:  sealedAlgorithm,
+		KeyID:      k.primaryKeyID,
+		WrappedKey: wrappedKey,
+		Nonce:      sealed[:gcmNonceSize],
+		Ciphertext: sealed[gcmNonceSize:],
+	}, nil
+}
+
+func (k *Keyring) open(kind RecordKind, id string, record sealedRecord) ([]byte, error) {
+	if record.Algorithm != sealedAlgorithm {
+		return nil, fmt.Errorf("unsupported sealing algorithm %q", record.Algorithm)
+	}
+
+	kek, ok := k.keys[record.KeyID]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	dataKey, err := gcmOpen(kek, record.WrappedKey, []byte(record.KeyID))
+	if err != nil {
+		return nil, err
+	}
+
+	return gcmOpen(dataKey, append(append([]byte(nil), record.Nonce...), record.Ciphertext...), associatedData(kind, id))
+}
+
+const gcmNonceSize = 12
+
+// gcmSeal returns the nonce followed by the ciphertext
+func gcmSeal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcmNonceSize {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	return aead.Open(nil, sealed[:gcmNonceSize], sealed[gcmNonceSize:], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func associatedData(kind RecordKind, id string) []byte {
+	return []byte(fmt.Sprintf("%s:%s", kind, id))
+}
+
+// EncryptingStore seals every record before handing it to the wrapped store.
+// The wrapped store only ever sees an otherwise empty record carrying the
+// sealed form: in ServiceFingerPrint for instances and in RawContext for
+// bindings. Records written before encryption was enabled are still readable
+// and are sealed by ReEncryptAll.
+type EncryptingStore struct {
+	logger  lager.Logger
+	store   Store
+	keyring *Keyring
+}
+
+func NewEncryptingStore(logger lager.Logger, store Store, keyring *Keyring) *EncryptingStore {
+	return &EncryptingStore{
+		logger:  logger,
+		store:   store,
+		keyring: keyring,
+	}
+}
+
+func (s *EncryptingStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *EncryptingStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *EncryptingStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *EncryptingStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	stored, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+
+	serviceInstance, _, err := s.openInstance(id, stored)
+	return serviceInstance, err
+}
+
+func (s *EncryptingStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	stored, err := s.store.RetrieveBindingDetails(id)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+
+	bindDetails, _, err := s.openBinding(id, stored)
+	return bindDetails, err
+}
+
+func (s *EncryptingStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	stored, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]ServiceInstance, len(stored))
+	for id, details := range stored {
+		if instances[id], _, err = s.openInstance(id, details); err != nil {
+			return nil, err
+		}
+	}
+	return instances, nil
+}
+
+func (s *EncryptingStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	stored, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make(map[string]domain.BindDetails, len(stored))
+	for id, details := range stored {
+		if bindings[id], _, err = s.openBinding(id, details); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+func (s *EncryptingStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	sealed, err := s.sealInstance(id, details)
+	if err != nil {
+		return err
+	}
+	return s.store.CreateInstanceDetails(id, sealed)
+}
+
+func (s *EncryptingStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	sealed, err := s.sealBinding(id, details)
+	if err != nil {
+		return err
+	}
+	return s.store.CreateBindingDetails(id, sealed)
+}
+
+func (s *EncryptingStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	return updateInstanceDetails(s, id, details)
+}
+
+func (s *EncryptingStore) DeleteInstanceDetails(id string) error {
+	return s.store.DeleteInstanceDetails(id)
+}
+
+func (s *EncryptingStore) DeleteBindingDetails(id string) error {
+	return s.store.DeleteBindingDetails(id)
+}
+
+func (s *EncryptingStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	return isInstanceConflict(s, id, details)
+}
+
+func (s *EncryptingStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return isBindingConflict(s, id, details)
+}
+
+func (s *EncryptingStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *EncryptingStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *EncryptingStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// ReEncryptAll seals every record that is stored in clear text or under a
+// key other than the primary one, and returns how many records it rewrote.
+func (s *EncryptingStore) ReEncryptAll() (int, error) {
+	logger := s.logger.Session("re-encrypt-all", lager.Data{"primary-key-id": s.keyring.PrimaryKeyID()})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	rewritten := 0
+
+	instances, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return rewritten, err
+	}
+	for id, stored := range instances {
+		details, keyID, err := s.openInstance(id, stored)
+		if err != nil {
+			logger.Error("failed-to-open-instance", err, lager.Data{"id": id})
+			return rewritten, err
+		}
+		if keyID == s.keyring.PrimaryKeyID() {
+			continue
+		}
+		if err := s.CreateInstanceDetails(id, details); err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+
+	bindings, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return rewritten, err
+	}
+	for id, stored := range bindings {
+		details, keyID, err := s.openBinding(id, stored)
+		if err != nil {
+			logger.Error("failed-to-open-binding", err, lager.Data{"id": id})
+			return rewritten, err
+		}
+		if keyID == s.keyring.PrimaryKeyID() {
+			continue
+		}
+		if err := s.CreateBindingDetails(id, details); err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+
+	logger.Info("rewritten", lager.Data{"count": rewritten})
+	return rewritten, nil
+}
+
+func (s *EncryptingStore) sealInstance(id string, details ServiceInstance) (ServiceInstance, error) {
+	plaintext, err := json.Marshal(details)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+
+	sealed, err := s.keyring.seal(InstanceRecord, id, plaintext)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+
+	fingerprint, err := toMap(sealed)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	return ServiceInstance{ServiceFingerPrint: fingerprint}, nil
+}
+
+// openInstance returns the decrypted record and the key ID it was sealed
+// under, or the record unchanged and "" if it was stored in clear text.
+func (s *EncryptingStore) openInstance(id string, stored ServiceInstance) (ServiceInstance, string, error) {
+	sealed, ok := asSealedRecord(stored.ServiceFingerPrint)
+	if !ok {
+		return stored, "", nil
+	}
+
+	plaintext, err := s.keyring.open(InstanceRecord, id, sealed)
+	if err != nil {
+		return ServiceInstance{}, "", err
+	}
+
+	var serviceInstance ServiceInstance
+	if err := json.Unmarshal(plaintext, &serviceInstance); err != nil {
+		return ServiceInstance{}, "", err
+	}
+	return serviceInstance, sealed.KeyID, nil
+}
+
+func (s *EncryptingStore) sealBinding(id string, details domain.BindDetails) (domain.BindDetails, error) {
+	plaintext, err := json.Marshal(details)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+
+	sealed, err := s.keyring.seal(BindingRecord, id, plaintext)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+
+	rawContext, err := json.Marshal(sealed)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+	return domain.BindDetails{RawContext: rawContext}, nil
+}
+
+func (s *EncryptingStore) openBinding(id string, stored domain.BindDetails) (domain.BindDetails, string, error) {
+	var context interface{}
+	if len(stored.RawContext) == 0 || json.Unmarshal(stored.RawContext, &context) != nil {
+		return stored, "", nil
+	}
+
+	sealed, ok := asSealedRecord(context)
+	if !ok {
+		return stored, "", nil
+	}
+
+	plaintext, err := s.keyring.open(BindingRecord, id, sealed)
+	if err != nil {
+		return domain.BindDetails{}, "", err
+	}
+
+	var bindDetails domain.BindDetails
+	if err := json.Unmarshal(plaintext, &bindDetails); err != nil {
+		return domain.BindDetails{}, "", err
+	}
+	return bindDetails, sealed.KeyID, nil
+}
+
+func asSealedRecord(field interface{}) (sealedRecord, bool) {
+	fields, ok := field.(map[string]interface{})
+	if !ok {
+		return sealedRecord{}, false
+	}
+	if _, ok := fields["ciphertext"]; !ok {
+		return sealedRecord{}, false
+	}
+	if _, ok := fields["key_id"]; !ok {
+		return sealedRecord{}, false
+	}
+
+	fieldBytes, err := json.Marshal(fields)
+	if err != nil {
+		return sealedRecord{}, false
+	}
+
+	var sealed sealedRecord
+	if err := json.Unmarshal(fieldBytes, &sealed); err != nil {
+		return sealedRecord{}, false
+	}
+	return sealed, true
+}
@@ -0,0 +1,266 @@
+package brokerstore_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EncryptingStore", func() {
+	var (
+		fakeStore       *brokerstorefakes.FakeStore
+		instances       map[string]ServiceInstance
+		bindings        map[string]domain.BindDetails
+		keyring         *Keyring
+		store           *EncryptingStore
+		serviceInstance ServiceInstance
+		bindDetails     domain.BindDetails
+		err             error
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, bindings = backStoreWithMemory(fakeStore)
+
+		keyring, err = NewKeyring("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte("1"), 32)})
+		Expect(err).NotTo(HaveOccurred())
+
+		serviceInstance = ServiceInstance{
+			ServiceID:          "service-id",
+			PlanID:             "plan-id",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"username": "a-username", "password": "a-password"},
+		}
+		bindDetails = domain.BindDetails{
+			AppGUID:       "app-guid",
+			PlanID:        "plan-id",
+			ServiceID:     "service-id",
+			RawParameters: json.RawMessage(`{"paramsHash":"some-hash"}`),
+		}
+	})
+
+	JustBeforeEach(func() {
+		store = NewEncryptingStore(lagertest.NewTestLogger("encrypting-store"), fakeStore, keyring)
+	})
+
+	Context("#NewKeyring", func() {
+		It("should reject a primary key id that is not in the keyring", func() {
+			_, err := NewKeyring("key-2", map[string][]byte{"key-1": bytes.Repeat([]byte("1"), 32)})
+			Expect(err).To(MatchError(ContainSubstring("key-2")))
+		})
+
+		It("should reject keys that are not 32 bytes", func() {
+			_, err := NewKeyring("key-1", map[string][]byte{"key-1": []byte("short")})
+			Expect(err).To(MatchError(ContainSubstring("32 bytes")))
+		})
+	})
+
+	Context("#CreateInstanceDetails", func() {
+		JustBeforeEach(func() {
+			err = store.CreateInstanceDetails("instance-1", serviceInstance)
+		})
+
+		It("should hand only the sealed form to the wrapped store", func() {
+			Expect(err).NotTo(HaveOccurred())
+			stored := instances["instance-1"]
+			Expect(stored.PlanID).To(BeEmpty())
+			Expect(stored.SpaceGUID).To(BeEmpty())
+			storedJSON, err := json.Marshal(stored)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(storedJSON)).NotTo(ContainSubstring("a-password"))
+			Expect(stored.ServiceFingerPrint).To(HaveKeyWithValue("key_id", "key-1"))
+		})
+
+		It("should round trip through RetrieveInstanceDetails", func() {
+			retrieved, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrieved).To(Equal(serviceInstance))
+		})
+
+		It("should not open under a different ID", func() {
+			instances["instance-2"] = instances["instance-1"]
+			_, err := store.RetrieveInstanceDetails("instance-2")
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when the wrapped store fails", func() {
+			BeforeEach(func() {
+				fakeStore.CreateInstanceDetailsStub = nil
+				fakeStore.CreateInstanceDetailsReturns(errors.New("bad-create"))
+			})
+
+			It("should return the error", func() {
+				Expect(err).To(MatchError("bad-create"))
+			})
+		})
+	})
+
+	Context("#CreateBindingDetails", func() {
+		JustBeforeEach(func() {
+			err = store.CreateBindingDetails("binding-1", bindDetails)
+		})
+
+		It("should hand only the sealed form to the wrapped store", func() {
+			Expect(err).NotTo(HaveOccurred())
+			stored := bindings["binding-1"]
+			Expect(stored.AppGUID).To(BeEmpty())
+			Expect(stored.RawParameters).To(BeEmpty())
+			Expect(string(stored.RawContext)).To(ContainSubstring(`"key_id":"key-1"`))
+		})
+
+		It("should round trip through RetrieveAllBindingDetails", func() {
+			retrieved, err := store.RetrieveAllBindingDetails()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrieved).To(Equal(map[string]domain.BindDetails{"binding-1": bindDetails}))
+		})
+
+		It("should detect conflicts against the decrypted record", func() {
+			Expect(store.IsBindingConflict("binding-1", domain.BindDetails{AppGUID: "other-app-guid"})).To(BeTrue())
+		})
+	})
+
+	Context("when records were stored in clear text", func() {
+		BeforeEach(func() {
+			instances["instance-1"] = serviceInstance
+			bindings["binding-1"] = bindDetails
+		})
+
+		It("should still read them", func() {
+			retrieved, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrieved).To(Equal(serviceInstance))
+		})
+
+		Context("#ReEncryptAll", func() {
+			It("should seal them", func() {
+				rewritten, err := store.ReEncryptAll()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rewritten).To(Equal(2))
+				Expect(instances["instance-1"].PlanID).To(BeEmpty())
+				Expect(bindings["binding-1"].AppGUID).To(BeEmpty())
+
+				retrieved, err := store.RetrieveBindingDetails("binding-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrieved).To(Equal(bindDetails))
+			})
+		})
+	})
+
+	Context("when the key is rotated", func() {
+		JustBeforeEach(func() {
+			Expect(store.CreateInstanceDetails("instance-1", serviceInstance)).To(Succeed())
+
+			keyring, err = NewKeyring("key-2", map[string][]byte{
+				"key-1": bytes.Repeat([]byte("1"), 32),
+				"key-2": bytes.Repeat([]byte("2"), 32),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			store = NewEncryptingStore(lagertest.NewTestLogger("encrypting-store"), fakeStore, keyring)
+		})
+
+		It("should still open records sealed under the old key", func() {
+			retrieved, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrieved).To(Equal(serviceInstance))
+		})
+
+		It("should reseal them under the new key with ReEncryptAll", func() {
+			rewritten, err := store.ReEncryptAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rewritten).To(Equal(1))
+			Expect(instances["instance-1"].ServiceFingerPrint).To(HaveKeyWithValue("key_id", "key-2"))
+
+			rewritten, err = store.ReEncryptAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rewritten).To(Equal(0))
+		})
+
+		Context("when the old key has been dropped", func() {
+			JustBeforeEach(func() {
+				keyring, err = NewKeyring("key-2", map[string][]byte{"key-2": bytes.Repeat([]byte("2"), 32)})
+				Expect(err).NotTo(HaveOccurred())
+				store = NewEncryptingStore(lagertest.NewTestLogger("encrypting-store"), fakeStore, keyring)
+			})
+
+			It("should return ErrUnknownKeyID", func() {
+				_, err := store.RetrieveInstanceDetails("instance-1")
+				Expect(err).To(MatchError(ErrUnknownKeyID))
+			})
+		})
+	})
+})
+
+// backStoreWithMemory makes the fake behave like a working store by keeping
+// records in the returned maps, round tripping them through JSON as a real
+// backend would.
+func backStoreWithMemory(fakeStore *brokerstorefakes.FakeStore) (map[string]ServiceInstance, map[string]domain.BindDetails) {
+	instances := map[string]ServiceInstance{}
+	bindings := map[string]domain.BindDetails{}
+
+	roundTrip := func(in, out interface{}) {
+		inJSON, err := json.Marshal(in)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(inJSON, out)).To(Succeed())
+	}
+
+	fakeStore.CreateInstanceDetailsStub = func(id string, details ServiceInstance) error {
+		var stored ServiceInstance
+		roundTrip(details, &stored)
+		instances[id] = stored
+		return nil
+	}
+	fakeStore.RetrieveInstanceDetailsStub = func(id string) (ServiceInstance, error) {
+		details, ok := instances[id]
+		if !ok {
+			return ServiceInstance{}, &credhub.NotFoundError{Description: "not found"}
+		}
+		return details, nil
+	}
+	fakeStore.RetrieveAllInstanceDetailsStub = func() (map[string]ServiceInstance, error) {
+		all := map[string]ServiceInstance{}
+		for id, details := range instances {
+			all[id] = details
+		}
+		return all, nil
+	}
+	fakeStore.DeleteInstanceDetailsStub = func(id string) error {
+		delete(instances, id)
+		return nil
+	}
+
+	fakeStore.CreateBindingDetailsStub = func(id string, details domain.BindDetails) error {
+		var stored domain.BindDetails
+		roundTrip(details, &stored)
+		bindings[id] = stored
+		return nil
+	}
+	fakeStore.RetrieveBindingDetailsStub = func(id string) (domain.BindDetails, error) {
+		details, ok := bindings[id]
+		if !ok {
+			return domain.BindDetails{}, &credhub.NotFoundError{Description: "not found"}
+		}
+		return details, nil
+	}
+	fakeStore.RetrieveAllBindingDetailsStub = func() (map[string]domain.BindDetails, error) {
+		all := map[string]domain.BindDetails{}
+		for id, details := range bindings {
+			all[id] = details
+		}
+		return all, nil
+	}
+	fakeStore.DeleteBindingDetailsStub = func(id string) error {
+		delete(bindings, id)
+		return nil
+	}
+
+	return instances, bindings
+}
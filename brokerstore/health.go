@@ -0,0 +1,147 @@
+package brokerstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type HealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// Reachable is set when the backend answered an unauthenticated request
+	Reachable bool `json:"reachable"`
+	// Authenticated is set when the backend answered an authenticated read
+	Authenticated bool          `json:"authenticated"`
+	ServerVersion string        `json:"server_version,omitempty"`
+	Latency       time.Duration `json:"-"`
+	Error         string        `json:"error,omitempty"`
+}
+
+func (h HealthStatus) MarshalJSON() ([]byte, error) {
+	type status HealthStatus
+	return json.Marshal(struct {
+		status
+		LatencyMS float64 `json:"latency_ms"`
+	}{status(h), float64(h.Latency) / float64(time.Millisecond)})
+}
+
+// HealthChecker is implemented by backends that support a cheap liveness
+// check.
+type HealthChecker interface {
+	// Ping returns an error if the backend is not healthy or ctx is done first
+	Ping(ctx context.Context) error
+	Health() HealthStatus
+}
+
+// Health checks that CredHub is reachable via its unauthenticated info
+// endpoint, then that UAA authentication works by reading the activation
+// marker.
+func (s *CredhubStore) Health() HealthStatus {
+	logger := s.logger.Session("health")
+	start := time.Now()
+
+	status := HealthStatus{}
+
+	if _, err := s.credhubShim.Info(); err != nil {
+		logger.Error("credhub-unreachable", err)
+		status.Error = err.Error()
+		status.Latency = time.Since(start)
+		return status
+	}
+	status.Reachable = true
+	if capabilities, err := s.Capabilities(); err == nil {
+		status.ServerVersion = capabilities.ServerVersion
+	} else {
+		logger.Info("server-version-unknown", lager.Data{"error": err.Error()})
+	}
+
+	if _, err := s.IsActivated(); err != nil {
+		logger.Error("credhub-authenticated-read-failed", err)
+		status.Error = err.Error()
+		status.Latency = time.Since(start)
+		return status
+	}
+	status.Authenticated = true
+	status.Healthy = true
+	status.Latency = time.Since(start)
+
+	return status
+}
+
+func (s *CredhubStore) Ping(ctx context.Context) error {
+	return pingHealth(ctx, s)
+}
+
+func pingHealth(ctx context.Context, checker interface{ Health() HealthStatus }) error {
+	status, err := healthWithin(ctx, checker)
+	if err != nil {
+		return err
+	}
+	if !status.Healthy {
+		return &HealthError{Status: status}
+	}
+	return nil
+}
+
+// healthWithin runs Health in the background so that callers are released
+// when ctx is done, even though the CredHub client itself cannot be
+// cancelled.
+func healthWithin(ctx context.Context, checker interface{ Health() HealthStatus }) (HealthStatus, error) {
+	start := time.Now()
+	result := make(chan HealthStatus, 1)
+	go func() { result <- checker.Health() }()
+
+	select {
+	case <-ctx.Done():
+		return HealthStatus{Error: ctx.Err().Error(), Latency: time.Since(start)}, ctx.Err()
+	case status := <-result:
+		return status, nil
+	}
+}
+
+// storeHealth and storePing forward health checks from a decorator to the
+// store it wraps, reporting a store without them as unhealthy.
+func storeHealth(store Store) HealthStatus {
+	if checker, ok := store.(HealthChecker); ok {
+		return checker.Health()
+	}
+	return HealthStatus{Error: errHealthUnsupported.Error()}
+}
+
+func storePing(ctx context.Context, store Store) error {
+	if checker, ok := store.(HealthChecker); ok {
+		return checker.Ping(ctx)
+	}
+	return errHealthUnsupported
+}
+
+var errHealthUnsupported = errors.New("store does not support health checks")
+
+type HealthError struct {
+	Status HealthStatus
+}
+
+func (e *HealthError) Error() string {
+	return "store is unhealthy: " + e.Status.Error
+}
+
+// NewHealthHandler serves the checker's HealthStatus as JSON, with a 200 when
+// healthy and a 503 otherwise, including when the request is cancelled or
+// times out before the check completes.
+func NewHealthHandler(checker HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := healthWithin(r.Context(), checker)
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
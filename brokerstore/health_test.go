@@ -0,0 +1,185 @@
+package brokerstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/server"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		store       *CredhubStore
+	)
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		fakeCredhub.InfoReturns(&server.Info{}, nil)
+		fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.12.0")), nil)
+		store = NewCredhubStore(lagertest.NewTestLogger("health"), fakeCredhub, "some-store-id")
+	})
+
+	Context("#Health", func() {
+		It("should report a healthy store with the server version", func() {
+			status := store.Health()
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.Reachable).To(BeTrue())
+			Expect(status.Authenticated).To(BeTrue())
+			Expect(status.ServerVersion).To(Equal("2.12.0"))
+			Expect(status.Latency).To(BeNumerically(">", 0))
+			Expect(fakeCredhub.FindByPathArgsForCall(0)).To(Equal("/some-store-id/migrated-from-sql"))
+		})
+
+		It("should still report a healthy store when the version is unknown", func() {
+			fakeCredhub.ServerVersionReturns(nil, errors.New("no version endpoint"))
+			status := store.Health()
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.ServerVersion).To(BeEmpty())
+		})
+
+		Context("when CredHub is unreachable", func() {
+			BeforeEach(func() {
+				fakeCredhub.InfoReturns(nil, errors.New("connection refused"))
+			})
+
+			It("should report it without trying to authenticate", func() {
+				status := store.Health()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Reachable).To(BeFalse())
+				Expect(status.Error).To(Equal("connection refused"))
+				Expect(fakeCredhub.FindByPathCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when authentication fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.FindByPathReturns(credentials.FindResults{}, errors.New("invalid_token"))
+			})
+
+			It("should report a reachable but unauthenticated store", func() {
+				status := store.Health()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Reachable).To(BeTrue())
+				Expect(status.Authenticated).To(BeFalse())
+				Expect(status.Error).To(Equal("invalid_token"))
+			})
+		})
+	})
+
+	Context("#Ping", func() {
+		It("should succeed when healthy", func() {
+			Expect(store.Ping(context.Background())).To(Succeed())
+		})
+
+		It("should return a HealthError when unhealthy", func() {
+			fakeCredhub.InfoReturns(nil, errors.New("connection refused"))
+			err := store.Ping(context.Background())
+			var healthErr *HealthError
+			Expect(errors.As(err, &healthErr)).To(BeTrue())
+			Expect(healthErr.Status.Reachable).To(BeFalse())
+		})
+
+		It("should give up when the context is done", func() {
+			release := make(chan struct{})
+			defer close(release)
+			fakeCredhub.InfoStub = func() (*server.Info, error) {
+				<-release
+				return &server.Info{}, nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			Expect(store.Ping(ctx)).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	Context("when the store is wrapped", func() {
+		It("should forward health checks to the wrapped store", func() {
+			wrapped := NewAuditingStore(lagertest.NewTestLogger("auditing"), NewSoftDeleteStore(lagertest.NewTestLogger("soft-delete"), store, SoftDeleteOptions{}), nil, "")
+			Expect(wrapped.Health().ServerVersion).To(Equal("2.12.0"))
+			Expect(wrapped.Ping(context.Background())).To(Succeed())
+		})
+
+		It("should report a wrapped store without health checks as unhealthy", func() {
+			wrapped := NewSoftDeleteStore(lagertest.NewTestLogger("soft-delete"), &brokerstorefakes.FakeStore{}, SoftDeleteOptions{})
+			Expect(wrapped.Health().Healthy).To(BeFalse())
+			Expect(wrapped.Ping(context.Background())).To(HaveOccurred())
+		})
+	})
+
+	Context("NewHealthHandler", func() {
+		var (
+			recorder *httptest.ResponseRecorder
+			request  *http.Request
+		)
+
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/health", nil)
+		})
+
+		JustBeforeEach(func() {
+			recorder = httptest.NewRecorder()
+			NewHealthHandler(store).ServeHTTP(recorder, request)
+		})
+
+		It("should return 200 with the status as JSON", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var body map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+			Expect(body).To(HaveKeyWithValue("healthy", true))
+			Expect(body).To(HaveKeyWithValue("server_version", "2.12.0"))
+			Expect(body).To(HaveKey("latency_ms"))
+		})
+
+		Context("when unhealthy", func() {
+			BeforeEach(func() {
+				fakeCredhub.InfoReturns(nil, errors.New("connection refused"))
+			})
+
+			It("should return 503", func() {
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"error":"connection refused"`))
+			})
+		})
+
+		Context("when the request is done before CredHub answers", func() {
+			var release chan struct{}
+
+			BeforeEach(func() {
+				release = make(chan struct{})
+				fakeCredhub.InfoStub = func() (*server.Info, error) {
+					<-release
+					return &server.Info{}, nil
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				DeferCleanup(cancel)
+				request = request.WithContext(ctx)
+			})
+
+			AfterEach(func() {
+				close(release)
+			})
+
+			It("should return 503 without waiting", func() {
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"error":"context deadline exceeded"`))
+			})
+		})
+	})
+})
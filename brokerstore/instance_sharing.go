@@ -0,0 +1,117 @@
+package brokerstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+)
+
+// ErrSpaceGUIDRequired is returned when sharing or unsharing an instance
+// without naming a space.
+var ErrSpaceGUIDRequired = errors.New("space GUID is required")
+
+// BindingSpaceError is returned when a binding is requested from a space that
+// neither owns the instance nor has had it shared into it.
+type BindingSpaceError struct {
+	InstanceID string
+	SpaceGUID  string
+}
+
+func (e *BindingSpaceError) Error() string {
+	return fmt.Sprintf("space %s does not own %s %s and it is not shared into it", e.SpaceGUID, InstanceRecord, e.InstanceID)
+}
+
+// ShareInstance records that the instance has been shared into spaceGUID.
+// Sharing into the owning space, or into a space already shared with, does
+// nothing.
+func ShareInstance(s Store, id, spaceGUID string) error {
+	if spaceGUID == "" {
+		return ErrSpaceGUIDRequired
+	}
+
+	instance, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		return err
+	}
+	if instance.SpaceGUID == spaceGUID || slices.Contains(instance.SharedSpaceGUIDs, spaceGUID) {
+		return nil
+	}
+
+	instance.SharedSpaceGUIDs = append(instance.SharedSpaceGUIDs, spaceGUID)
+	slices.Sort(instance.SharedSpaceGUIDs)
+	instance.recordChange(InstanceChange{ChangedAt: time.Now().UTC(), SharedSpaceGUID: spaceGUID})
+	return s.CreateInstanceDetails(id, instance)
+}
+
+// UnshareInstance records that the instance is no longer shared into
+// spaceGUID. Bindings already made from that space are left for the caller
+// to delete.
+func UnshareInstance(s Store, id, spaceGUID string) error {
+	if spaceGUID == "" {
+		return ErrSpaceGUIDRequired
+	}
+
+	instance, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(instance.SharedSpaceGUIDs, spaceGUID) {
+		return nil
+	}
+
+	instance.SharedSpaceGUIDs = slices.DeleteFunc(instance.SharedSpaceGUIDs, func(shared string) bool {
+		return shared == spaceGUID
+	})
+	if len(instance.SharedSpaceGUIDs) == 0 {
+		instance.SharedSpaceGUIDs = nil
+	}
+	instance.recordChange(InstanceChange{ChangedAt: time.Now().UTC(), UnsharedSpaceGUID: spaceGUID})
+	return s.CreateInstanceDetails(id, instance)
+}
+
+// CheckBindingSpace checks, before a binding is stored, that the space it is
+// made from owns the instance or has had it shared into it. The space is
+// taken from the bind resource, falling back to the platform context for
+// bindings without an app such as service keys. Bindings that name no space
+// are not checked.
+func CheckBindingSpace(s Store, instanceID string, details domain.BindDetails) error {
+	spaceGUID := bindingSpaceGUID(details)
+	if spaceGUID == "" {
+		return nil
+	}
+
+	instance, err := s.RetrieveInstanceDetails(instanceID)
+	if err != nil {
+		return err
+	}
+	if !instance.IsVisibleIn(spaceGUID) {
+		return &BindingSpaceError{InstanceID: instanceID, SpaceGUID: spaceGUID}
+	}
+	return nil
+}
+
+// IsVisibleIn reports whether the instance is owned by or shared into spaceGUID
+func (si ServiceInstance) IsVisibleIn(spaceGUID string) bool {
+	return si.SpaceGUID == spaceGUID || slices.Contains(si.SharedSpaceGUIDs, spaceGUID)
+}
+
+func bindingSpaceGUID(details domain.BindDetails) string {
+	if details.BindResource != nil && details.BindResource.SpaceGuid != "" {
+		return details.BindResource.SpaceGuid
+	}
+	if len(details.RawContext) == 0 {
+		return ""
+	}
+
+	var context struct {
+		SpaceGUID string `json:"space_guid"`
+	}
+	if err := json.Unmarshal(details.RawContext, &context); err != nil {
+		return ""
+	}
+	return context.SpaceGUID
+}
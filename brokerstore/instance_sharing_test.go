@@ -0,0 +1,112 @@
+package brokerstore_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Instance sharing", func() {
+	var (
+		fakeStore *brokerstorefakes.FakeStore
+		instances map[string]ServiceInstance
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, _ = backStoreWithMemory(fakeStore)
+		Expect(fakeStore.CreateInstanceDetails("instance-1", ServiceInstance{
+			ServiceID:        "service-id",
+			PlanID:           "plan-id",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "owner-space",
+		})).To(Succeed())
+	})
+
+	It("should record each share target once, with the share in the history", func() {
+		Expect(ShareInstance(fakeStore, "instance-1", "space-b")).To(Succeed())
+		Expect(ShareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+		Expect(ShareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+		Expect(ShareInstance(fakeStore, "instance-1", "owner-space")).To(Succeed())
+
+		instance := instances["instance-1"]
+		Expect(instance.SharedSpaceGUIDs).To(Equal([]string{"space-a", "space-b"}))
+		Expect(instance.History).To(HaveLen(2))
+		Expect(instance.History[0].SharedSpaceGUID).To(Equal("space-b"))
+		Expect(instance.History[1].SharedSpaceGUID).To(Equal("space-a"))
+		Expect(instance.IsVisibleIn("space-a")).To(BeTrue())
+		Expect(instance.IsVisibleIn("owner-space")).To(BeTrue())
+		Expect(instance.IsVisibleIn("space-c")).To(BeFalse())
+	})
+
+	It("should remove unshared spaces and record the unshare", func() {
+		Expect(ShareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+		Expect(UnshareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+		Expect(UnshareInstance(fakeStore, "instance-1", "space-b")).To(Succeed())
+
+		instance := instances["instance-1"]
+		Expect(instance.SharedSpaceGUIDs).To(BeNil())
+		Expect(instance.History).To(HaveLen(2))
+		Expect(instance.History[1].UnsharedSpaceGUID).To(Equal("space-a"))
+	})
+
+	It("should not let shares make a provision retry conflict", func() {
+		Expect(ShareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+
+		Expect(CheckInstanceConflict(fakeStore, "instance-1", ServiceInstance{
+			ServiceID:        "service-id",
+			PlanID:           "plan-id",
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "owner-space",
+		}).Status).To(Equal(ConflictIdentical))
+	})
+
+	It("should refuse to share or unshare without a space", func() {
+		Expect(ShareInstance(fakeStore, "instance-1", "")).To(MatchError(ErrSpaceGUIDRequired))
+		Expect(UnshareInstance(fakeStore, "instance-1", "")).To(MatchError(ErrSpaceGUIDRequired))
+		Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(1))
+	})
+
+	It("should return the read error when the instance does not exist", func() {
+		Expect(ShareInstance(fakeStore, "instance-2", "space-a")).To(HaveOccurred())
+	})
+
+	Describe("CheckBindingSpace", func() {
+		BeforeEach(func() {
+			Expect(ShareInstance(fakeStore, "instance-1", "space-a")).To(Succeed())
+		})
+
+		It("should allow bindings from the owning space or a share target", func() {
+			Expect(CheckBindingSpace(fakeStore, "instance-1", domain.BindDetails{
+				BindResource: &domain.BindResource{SpaceGuid: "owner-space"},
+			})).To(Succeed())
+			Expect(CheckBindingSpace(fakeStore, "instance-1", domain.BindDetails{
+				BindResource: &domain.BindResource{SpaceGuid: "space-a"},
+			})).To(Succeed())
+		})
+
+		It("should reject bindings from any other space", func() {
+			err := CheckBindingSpace(fakeStore, "instance-1", domain.BindDetails{
+				BindResource: &domain.BindResource{SpaceGuid: "space-c"},
+			})
+
+			var spaceErr *BindingSpaceError
+			Expect(errors.As(err, &spaceErr)).To(BeTrue())
+			Expect(spaceErr).To(Equal(&BindingSpaceError{InstanceID: "instance-1", SpaceGUID: "space-c"}))
+		})
+
+		It("should take the space from the context for service keys", func() {
+			rawContext, _ := json.Marshal(map[string]string{"platform": "cloudfoundry", "space_guid": "space-c"})
+			Expect(CheckBindingSpace(fakeStore, "instance-1", domain.BindDetails{RawContext: rawContext})).To(HaveOccurred())
+		})
+
+		It("should not check bindings that name no space", func() {
+			Expect(CheckBindingSpace(fakeStore, "instance-1", domain.BindDetails{AppGUID: "app-guid"})).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,156 @@
+package brokerstore
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// InstrumentedStore records the count, errors and latency of every call to
+// the wrapped store in a StoreMetrics. The instance and binding gauges are
+// updated whenever the store is listed, including by RefreshCounts.
+type InstrumentedStore struct {
+	store   Store
+	metrics *StoreMetrics
+}
+
+func NewInstrumentedStore(store Store, metrics *StoreMetrics) *InstrumentedStore {
+	return &InstrumentedStore{
+		store:   store,
+		metrics: metrics,
+	}
+}
+
+func (s *InstrumentedStore) Metrics() *StoreMetrics {
+	return s.metrics
+}
+
+// RefreshCounts lists the wrapped store to update the record count gauges.
+// Brokers typically call it on a timer rather than on every scrape.
+func (s *InstrumentedStore) RefreshCounts() error {
+	if _, err := s.RetrieveAllInstanceDetails(); err != nil {
+		return err
+	}
+	_, err := s.RetrieveAllBindingDetails()
+	return err
+}
+
+func (s *InstrumentedStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *InstrumentedStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *InstrumentedStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	start := time.Now()
+	details, err := s.store.RetrieveInstanceDetails(id)
+	s.metrics.observe("retrieve_instance_details", time.Since(start), err)
+	return details, err
+}
+
+func (s *InstrumentedStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	start := time.Now()
+	details, err := s.store.RetrieveBindingDetails(id)
+	s.metrics.observe("retrieve_binding_details", time.Since(start), err)
+	return details, err
+}
+
+func (s *InstrumentedStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	start := time.Now()
+	instances, err := s.store.RetrieveAllInstanceDetails()
+	s.metrics.observe("retrieve_all_instance_details", time.Since(start), err)
+	if err == nil {
+		s.metrics.setCounts(len(instances), -1)
+	}
+	return instances, err
+}
+
+func (s *InstrumentedStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	start := time.Now()
+	bindings, err := s.store.RetrieveAllBindingDetails()
+	s.metrics.observe("retrieve_all_binding_details", time.Since(start), err)
+	if err == nil {
+		s.metrics.setCounts(-1, len(bindings))
+	}
+	return bindings, err
+}
+
+func (s *InstrumentedStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	start := time.Now()
+	err := s.store.CreateInstanceDetails(id, details)
+	s.metrics.observe("create_instance_details", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	start := time.Now()
+	err := s.store.CreateBindingDetails(id, details)
+	s.metrics.observe("create_binding_details", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	start := time.Now()
+	err := s.store.UpdateInstanceDetails(id, details)
+	s.metrics.observe("update_instance_details", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) DeleteInstanceDetails(id string) error {
+	start := time.Now()
+	err := s.store.DeleteInstanceDetails(id)
+	s.metrics.observe("delete_instance_details", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) DeleteBindingDetails(id string) error {
+	start := time.Now()
+	err := s.store.DeleteBindingDetails(id)
+	s.metrics.observe("delete_binding_details", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	start := time.Now()
+	conflict := s.store.IsInstanceConflict(id, details)
+	s.metrics.observe("is_instance_conflict", time.Since(start), nil)
+	return conflict
+}
+
+func (s *InstrumentedStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	start := time.Now()
+	conflict := s.store.IsBindingConflict(id, details)
+	s.metrics.observe("is_binding_conflict", time.Since(start), nil)
+	return conflict
+}
+
+func (s *InstrumentedStore) Restore(logger lager.Logger) error {
+	start := time.Now()
+	err := s.store.Restore(logger)
+	s.metrics.observe("restore", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) Save(logger lager.Logger) error {
+	start := time.Now()
+	err := s.store.Save(logger)
+	s.metrics.observe("save", time.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedStore) Cleanup() error {
+	start := time.Now()
+	err := s.store.Cleanup()
+	s.metrics.observe("cleanup", time.Since(start), err)
+	return err
+}
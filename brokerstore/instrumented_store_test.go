@@ -0,0 +1,106 @@
+package brokerstore_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InstrumentedStore", func() {
+	var (
+		fakeStore *brokerstorefakes.FakeStore
+		metrics   *StoreMetrics
+		store     *InstrumentedStore
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		metrics = NewStoreMetrics([]float64{0.1, 1})
+		store = NewInstrumentedStore(fakeStore, metrics)
+	})
+
+	scrape := func() string {
+		buffer := &bytes.Buffer{}
+		_, err := metrics.WriteTo(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		return buffer.String()
+	}
+
+	It("should count operations and record their latency", func() {
+		Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", ServiceInstance{})).To(Succeed())
+
+		output := scrape()
+		Expect(output).To(ContainSubstring(`brokerstore_operations_total{operation="create_instance_details"} 2`))
+		Expect(output).To(ContainSubstring(`brokerstore_operation_duration_seconds_bucket{operation="create_instance_details",le="0.1"} 2`))
+		Expect(output).To(ContainSubstring(`brokerstore_operation_duration_seconds_bucket{operation="create_instance_details",le="+Inf"} 2`))
+		Expect(output).To(ContainSubstring(`brokerstore_operation_duration_seconds_count{operation="create_instance_details"} 2`))
+	})
+
+	It("should count errors by class", func() {
+		fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, &credhub.NotFoundError{Description: "not found"})
+		fakeStore.DeleteBindingDetailsReturns(errors.New("boom"))
+
+		_, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).To(HaveOccurred())
+		Expect(store.DeleteBindingDetails("binding-1")).NotTo(Succeed())
+
+		output := scrape()
+		Expect(output).To(ContainSubstring(`brokerstore_operation_errors_total{operation="delete_binding_details",class="other"} 1`))
+		Expect(output).To(ContainSubstring(`brokerstore_operation_errors_total{operation="retrieve_instance_details",class="not_found"} 1`))
+	})
+
+	It("should update the record count gauges when listing", func() {
+		fakeStore.RetrieveAllInstanceDetailsReturns(map[string]ServiceInstance{"a": {}, "b": {}}, nil)
+		fakeStore.RetrieveAllBindingDetailsReturns(map[string]domain.BindDetails{"c": {}}, nil)
+
+		Expect(store.RefreshCounts()).To(Succeed())
+
+		output := scrape()
+		Expect(output).To(ContainSubstring("brokerstore_instances 2\n"))
+		Expect(output).To(ContainSubstring("brokerstore_bindings 1\n"))
+	})
+
+	It("should pass results through untouched", func() {
+		fakeStore.IsBindingConflictReturns(true)
+		Expect(store.IsBindingConflict("binding-1", domain.BindDetails{})).To(BeTrue())
+		Expect(fakeStore.IsBindingConflictCallCount()).To(Equal(1))
+	})
+
+	Context("#Handler", func() {
+		It("should serve the metrics in Prometheus text format", func() {
+			Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+
+			server := httptest.NewServer(metrics.Handler())
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(ContainSubstring("# TYPE brokerstore_operation_duration_seconds histogram"))
+			Expect(string(body)).To(ContainSubstring(`brokerstore_operations_total{operation="delete_instance_details"} 1`))
+		})
+	})
+
+	Context("ErrorClass", func() {
+		It("should classify store errors", func() {
+			Expect(ErrorClass(&credhub.Error{Name: "forbidden"})).To(Equal("credhub"))
+			Expect(ErrorClass(&IntegrityError{})).To(Equal("integrity"))
+			Expect(ErrorClass(ErrUnknownKeyID)).To(Equal("encryption"))
+			Expect(ErrorClass(errors.New("boom"))).To(Equal("other"))
+		})
+	})
+})
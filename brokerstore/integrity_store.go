@@ -0,0 +1,265 @@
+package brokerstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	integrityMACKey   = "hmac"
+	integrityValueKey = "value"
+)
+
+// IntegrityError is returned when a record read back from the store does not
+// carry a valid HMAC, i.e. it was written or edited outside this store.
+type IntegrityError struct {
+	Kind   RecordKind
+	ID     string
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check failed for %s %s: %s", e.Kind, e.ID, e.Reason)
+}
+
+type IntegrityOptions struct {
+	// Lenient logs failed checks instead of returning an IntegrityError. It is
+	// meant for the migration period while existing records gain an HMAC.
+	Lenient bool
+}
+
+// IntegrityStore attaches an HMAC-SHA256 over the canonical JSON of each
+// record on write, and checks it on read. The MAC travels in the wrapped
+// record alongside the original ServiceFingerPrint for instances, and
+// alongside the original RawContext for bindings.
+type IntegrityStore struct {
+	logger lager.Logger
+	store  Store
+	key    []byte
+	opts   IntegrityOptions
+}
+
+func NewIntegrityStore(logger lager.Logger, store Store, key []byte, opts IntegrityOptions) *IntegrityStore {
+	return &IntegrityStore{
+		logger: logger,
+		store:  store,
+		key:    append([]byte(nil), key...),
+		opts:   opts,
+	}
+}
+
+func (s *IntegrityStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *IntegrityStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *IntegrityStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *IntegrityStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	stored, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	return s.checkInstance(id, stored)
+}
+
+func (s *IntegrityStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	stored, err := s.store.RetrieveBindingDetails(id)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+	return s.checkBinding(id, stored)
+}
+
+func (s *IntegrityStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	stored, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]ServiceInstance, len(stored))
+	for id, details := range stored {
+		if instances[id], err = s.checkInstance(id, details); err != nil {
+			return nil, err
+		}
+	}
+	return instances, nil
+}
+
+func (s *IntegrityStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	stored, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make(map[string]domain.BindDetails, len(stored))
+	for id, details := range stored {
+		if bindings[id], err = s.checkBinding(id, details); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+func (s *IntegrityStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	mac, err := s.mac(InstanceRecord, id, details)
+	if err != nil {
+		return err
+	}
+
+	details.ServiceFingerPrint = map[string]interface{}{
+		integrityValueKey: details.ServiceFingerPrint,
+		integrityMACKey:   mac,
+	}
+	return s.store.CreateInstanceDetails(id, details)
+}
+
+func (s *IntegrityStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	mac, err := s.mac(BindingRecord, id, details)
+	if err != nil {
+		return err
+	}
+
+	wrapped := map[string]interface{}{integrityMACKey: mac}
+	if len(details.RawContext) > 0 {
+		wrapped[integrityValueKey] = details.RawContext
+	}
+	if details.RawContext, err = json.Marshal(wrapped); err != nil {
+		return err
+	}
+	return s.store.CreateBindingDetails(id, details)
+}
+
+func (s *IntegrityStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	return updateInstanceDetails(s, id, details)
+}
+
+func (s *IntegrityStore) DeleteInstanceDetails(id string) error {
+	return s.store.DeleteInstanceDetails(id)
+}
+
+func (s *IntegrityStore) DeleteBindingDetails(id string) error {
+	return s.store.DeleteBindingDetails(id)
+}
+
+func (s *IntegrityStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	return isInstanceConflict(s, id, details)
+}
+
+func (s *IntegrityStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return isBindingConflict(s, id, details)
+}
+
+func (s *IntegrityStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *IntegrityStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *IntegrityStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+func (s *IntegrityStore) checkInstance(id string, stored ServiceInstance) (ServiceInstance, error) {
+	wrapped, _ := stored.ServiceFingerPrint.(map[string]interface{})
+	mac, ok := wrapped[integrityMACKey].(string)
+	if !ok {
+		if err := s.fail(InstanceRecord, id, "missing hmac"); err != nil {
+			return ServiceInstance{}, err
+		}
+		return stored, nil
+	}
+
+	details := stored
+	details.ServiceFingerPrint = wrapped[integrityValueKey]
+	if err := s.check(InstanceRecord, id, details, mac); err != nil {
+		return ServiceInstance{}, err
+	}
+	return details, nil
+}
+
+func (s *IntegrityStore) checkBinding(id string, stored domain.BindDetails) (domain.BindDetails, error) {
+	var wrapped struct {
+		MAC   string          `json:"hmac"`
+		Value json.RawMessage `json:"value"`
+	}
+	if len(stored.RawContext) == 0 || json.Unmarshal(stored.RawContext, &wrapped) != nil || wrapped.MAC == "" {
+		if err := s.fail(BindingRecord, id, "missing hmac"); err != nil {
+			return domain.BindDetails{}, err
+		}
+		return stored, nil
+	}
+
+	details := stored
+	details.RawContext = wrapped.Value
+	if err := s.check(BindingRecord, id, details, wrapped.MAC); err != nil {
+		return domain.BindDetails{}, err
+	}
+	return details, nil
+}
+
+func (s *IntegrityStore) check(kind RecordKind, id string, details interface{}, mac string) error {
+	expected, err := s.mac(kind, id, details)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(mac)) {
+		return s.fail(kind, id, "hmac mismatch")
+	}
+	return nil
+}
+
+// fail returns an IntegrityError, or logs it and returns nil when lenient
+func (s *IntegrityStore) fail(kind RecordKind, id, reason string) error {
+	err := &IntegrityError{Kind: kind, ID: id, Reason: reason}
+	if s.opts.Lenient {
+		s.logger.Error("integrity-check-failed", err, lager.Data{"kind": kind, "id": id})
+		return nil
+	}
+	return err
+}
+
+func (s *IntegrityStore) mac(kind RecordKind, id string, record interface{}) (string, error) {
+	canonical, err := canonicalJSON(record)
+	if err != nil {
+		return "", err
+	}
+
+	h := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(h, "%s\n%s\n", kind, id)
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalJSON encodes v with sorted keys, no insignificant whitespace and
+// numbers normalised, so that a record hashes the same before it is written
+// and after it has been read back from any backend.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
@@ -0,0 +1,149 @@
+package brokerstore_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("IntegrityStore", func() {
+	var (
+		logger          *lagertest.TestLogger
+		fakeStore       *brokerstorefakes.FakeStore
+		instances       map[string]ServiceInstance
+		bindings        map[string]domain.BindDetails
+		opts            IntegrityOptions
+		store           *IntegrityStore
+		serviceInstance ServiceInstance
+		bindDetails     domain.BindDetails
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("integrity-store")
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, bindings = backStoreWithMemory(fakeStore)
+		opts = IntegrityOptions{}
+
+		serviceInstance = ServiceInstance{
+			ServiceID:          "service-id",
+			PlanID:             "plan-id",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"size": float64(10)},
+		}
+		bindDetails = domain.BindDetails{
+			AppGUID:       "app-guid",
+			PlanID:        "plan-id",
+			ServiceID:     "service-id",
+			RawContext:    json.RawMessage(`{"platform": "cloudfoundry"}`),
+			RawParameters: json.RawMessage(`{"paramsHash": "some-hash"}`),
+		}
+	})
+
+	JustBeforeEach(func() {
+		store = NewIntegrityStore(logger, fakeStore, []byte("some-key"), opts)
+		Expect(store.CreateInstanceDetails("instance-1", serviceInstance)).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-1", bindDetails)).To(Succeed())
+	})
+
+	It("should round trip untouched records", func() {
+		retrievedInstance, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrievedInstance).To(Equal(serviceInstance))
+
+		retrievedBinding, err := store.RetrieveBindingDetails("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrievedBinding.AppGUID).To(Equal("app-guid"))
+		Expect(retrievedBinding.RawContext).To(MatchJSON(`{"platform": "cloudfoundry"}`))
+	})
+
+	It("should not report a conflict for the same record", func() {
+		Expect(store.IsInstanceConflict("instance-1", serviceInstance)).To(BeFalse())
+	})
+
+	Context("when an instance is edited directly in the backend", func() {
+		JustBeforeEach(func() {
+			tampered := instances["instance-1"]
+			tampered.PlanID = "other-plan-id"
+			instances["instance-1"] = tampered
+		})
+
+		It("should return an IntegrityError", func() {
+			_, err := store.RetrieveInstanceDetails("instance-1")
+			var integrityErr *IntegrityError
+			Expect(err).To(BeAssignableToTypeOf(integrityErr))
+			Expect(err).To(MatchError("integrity check failed for instance instance-1: hmac mismatch"))
+		})
+
+		It("should fail listing as well", func() {
+			_, err := store.RetrieveAllInstanceDetails()
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when lenient", func() {
+			BeforeEach(func() {
+				opts.Lenient = true
+			})
+
+			It("should log the failure and return the record", func() {
+				retrieved, err := store.RetrieveInstanceDetails("instance-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrieved.PlanID).To(Equal("other-plan-id"))
+				Expect(logger).To(gbytes.Say("integrity-check-failed"))
+			})
+		})
+	})
+
+	Context("when a binding is edited directly in the backend", func() {
+		JustBeforeEach(func() {
+			tampered := bindings["binding-1"]
+			tampered.AppGUID = "other-app-guid"
+			bindings["binding-1"] = tampered
+		})
+
+		It("should return an IntegrityError", func() {
+			_, err := store.RetrieveBindingDetails("binding-1")
+			Expect(err).To(MatchError("integrity check failed for binding binding-1: hmac mismatch"))
+		})
+	})
+
+	Context("when a record has no hmac", func() {
+		JustBeforeEach(func() {
+			instances["legacy"] = serviceInstance
+		})
+
+		It("should return an IntegrityError", func() {
+			_, err := store.RetrieveInstanceDetails("legacy")
+			Expect(err).To(MatchError("integrity check failed for instance legacy: missing hmac"))
+		})
+
+		Context("when lenient", func() {
+			BeforeEach(func() {
+				opts.Lenient = true
+			})
+
+			It("should return the record as stored", func() {
+				retrieved, err := store.RetrieveInstanceDetails("legacy")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrieved).To(Equal(serviceInstance))
+			})
+		})
+	})
+
+	Context("when a record is copied to another ID", func() {
+		JustBeforeEach(func() {
+			instances["instance-2"] = instances["instance-1"]
+		})
+
+		It("should return an IntegrityError", func() {
+			_, err := store.RetrieveInstanceDetails("instance-2")
+			Expect(err).To(MatchError(ContainSubstring("hmac mismatch")))
+		})
+	})
+})
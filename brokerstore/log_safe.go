@@ -0,0 +1,87 @@
+package brokerstore
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Redacted replaces sensitive values in log output
+const Redacted = "[REDACTED]"
+
+// LogSafe returns the instance as lager.Data with every ServiceFingerPrint
+// value masked. The fingerprint's keys are kept so that log lines still show
+// its shape.
+func (si ServiceInstance) LogSafe() lager.Data {
+	data := lager.Data{
+		"service_id":          si.ServiceID,
+		"plan_id":             si.PlanID,
+		"organization_guid":   si.OrganizationGUID,
+		"space_guid":          si.SpaceGUID,
+		"service_fingerprint": redact(si.ServiceFingerPrint),
+	}
+	if len(si.SharedSpaceGUIDs) > 0 {
+		data["shared_space_guids"] = si.SharedSpaceGUIDs
+	}
+	return data
+}
+
+// LogSafeBindDetails returns the binding as lager.Data. Parameters get the
+// same treatment as in storage, where they are only kept as a bcrypt hash:
+// their keys are shown but every value, including the hash, is masked.
+func LogSafeBindDetails(details domain.BindDetails) lager.Data {
+	data := lager.Data{
+		"app_guid":   details.AppGUID,
+		"plan_id":    details.PlanID,
+		"service_id": details.ServiceID,
+	}
+
+	if details.BindResource != nil {
+		data["bind_resource"] = lager.Data{
+			"app_guid":   details.BindResource.AppGuid,
+			"space_guid": details.BindResource.SpaceGuid,
+			"route":      details.BindResource.Route,
+		}
+	}
+	if len(details.RawParameters) > 0 {
+		data["parameters"] = redactRawJSON(details.RawParameters)
+	}
+	if len(details.RawContext) > 0 {
+		data["context"] = redactRawJSON(details.RawContext)
+	}
+
+	return data
+}
+
+// recordData identifies a record in log lines without revealing its contents
+func recordData(kind RecordKind, id string) lager.Data {
+	return lager.Data{"kind": kind, "id": id}
+}
+
+func redactRawJSON(raw json.RawMessage) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return Redacted
+	}
+	return redact(decoded)
+}
+
+// redact keeps the keys of objects, recursively, and masks every other value
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			redacted[key] = redact(nested)
+		}
+		return redacted
+	default:
+		if asMap, err := toMap(v); err == nil && asMap != nil {
+			return redact(asMap)
+		}
+		return Redacted
+	}
+}
@@ -0,0 +1,93 @@
+package brokerstore_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("LogSafe", func() {
+	Context("ServiceInstance#LogSafe", func() {
+		It("should mask the fingerprint values but keep its keys", func() {
+			data := ServiceInstance{
+				ServiceID:        "service-id",
+				PlanID:           "plan-id",
+				OrganizationGUID: "org-guid",
+				SpaceGUID:        "space-guid",
+				ServiceFingerPrint: map[string]interface{}{
+					"username": "a-username",
+					"password": "a-password",
+					"nested":   map[string]interface{}{"token": "a-token"},
+				},
+			}.LogSafe()
+
+			Expect(data).To(Equal(lager.Data{
+				"service_id":        "service-id",
+				"plan_id":           "plan-id",
+				"organization_guid": "org-guid",
+				"space_guid":        "space-guid",
+				"service_fingerprint": map[string]interface{}{
+					"username": Redacted,
+					"password": Redacted,
+					"nested":   map[string]interface{}{"token": Redacted},
+				},
+			}))
+		})
+
+		It("should mask fingerprints that are not objects", func() {
+			Expect(ServiceInstance{ServiceFingerPrint: "a-secret"}.LogSafe()).To(HaveKeyWithValue("service_fingerprint", Redacted))
+		})
+
+		It("should mask fingerprints held as structs", func() {
+			fingerprint := struct {
+				Password string `json:"password"`
+			}{"a-password"}
+			Expect(ServiceInstance{ServiceFingerPrint: fingerprint}.LogSafe()).To(HaveKeyWithValue("service_fingerprint", map[string]interface{}{"password": Redacted}))
+		})
+	})
+
+	Context("LogSafeBindDetails", func() {
+		It("should mask parameter and context values", func() {
+			data := LogSafeBindDetails(domain.BindDetails{
+				AppGUID:       "app-guid",
+				PlanID:        "plan-id",
+				ServiceID:     "service-id",
+				BindResource:  &domain.BindResource{AppGuid: "app-guid", SpaceGuid: "space-guid"},
+				RawParameters: json.RawMessage(`{"uid": "1000", "password": "a-password"}`),
+				RawContext:    json.RawMessage(`not-json`),
+			})
+
+			Expect(data).To(HaveKeyWithValue("app_guid", "app-guid"))
+			Expect(data).To(HaveKeyWithValue("bind_resource", lager.Data{"app_guid": "app-guid", "space_guid": "space-guid", "route": ""}))
+			Expect(data).To(HaveKeyWithValue("parameters", map[string]interface{}{"uid": Redacted, "password": Redacted}))
+			Expect(data).To(HaveKeyWithValue("context", Redacted))
+		})
+
+		It("should omit absent parameters", func() {
+			Expect(LogSafeBindDetails(domain.BindDetails{})).NotTo(HaveKey("parameters"))
+		})
+	})
+
+	Context("CredhubStore log lines", func() {
+		It("should identify the record by kind and ID", func() {
+			logger := lagertest.NewTestLogger("credhub-store")
+			fakeCredhub := &credhub_fakes.FakeCredhub{}
+			fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, nil)
+			store := NewCredhubStore(logger, fakeCredhub, "some-store-id")
+
+			_, err := store.RetrieveBindingDetails("binding-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logger).To(gbytes.Say(`retrieve-binding-details.start.*"id":"binding-1","kind":"binding"`))
+			Expect(logger).To(gbytes.Say(`retrieve-binding-details.end.*"id":"binding-1","kind":"binding"`))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package brokerstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets. They span a cached read up to a slow UAA token fetch.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// StoreMetrics collects per-operation counters, error counts and latency
+// histograms, plus record count gauges, and renders them in the Prometheus
+// text exposition format.
+type StoreMetrics struct {
+	mutex      sync.Mutex
+	buckets    []float64
+	operations map[string]uint64
+	errors     map[[2]string]uint64
+	latencies  map[string]*histogram
+	instances  float64
+	bindings   float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewStoreMetrics(buckets []float64) *StoreMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &StoreMetrics{
+		buckets:    buckets,
+		operations: map[string]uint64{},
+		errors:     map[[2]string]uint64{},
+		latencies:  map[string]*histogram{},
+	}
+}
+
+func (m *StoreMetrics) observe(operation string, duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.operations[operation]++
+	if err != nil {
+		m.errors[[2]string{operation, ErrorClass(err)}]++
+	}
+
+	h, ok := m.latencies[operation]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[operation] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *StoreMetrics) setCounts(instances, bindings int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if instances >= 0 {
+		m.instances = float64(instances)
+	}
+	if bindings >= 0 {
+		m.bindings = float64(bindings)
+	}
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format
+func (m *StoreMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cw := &countingWriter{w: w}
+
+	fmt.Fprintln(cw, "# HELP brokerstore_operations_total Store operations performed, by operation.")
+	fmt.Fprintln(cw, "# TYPE brokerstore_operations_total counter")
+	for _, operation := range sortedKeys(m.operations) {
+		fmt.Fprintf(cw, "brokerstore_operations_total{operation=%q} %d\n", operation, m.operations[operation])
+	}
+
+	fmt.Fprintln(cw, "# HELP brokerstore_operation_errors_total Store operations that failed, by operation and error class.")
+	fmt.Fprintln(cw, "# TYPE brokerstore_operation_errors_total counter")
+	errorKeys := make([][2]string, 0, len(m.errors))
+	for key := range m.errors {
+		errorKeys = append(errorKeys, key)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i][0] != errorKeys[j][0] {
+			return errorKeys[i][0] < errorKeys[j][0]
+		}
+		return errorKeys[i][1] < errorKeys[j][1]
+	})
+	for _, key := range errorKeys {
+		fmt.Fprintf(cw, "brokerstore_operation_errors_total{operation=%q,class=%q} %d\n", key[0], key[1], m.errors[key])
+	}
+
+	fmt.Fprintln(cw, "# HELP brokerstore_operation_duration_seconds Store operation latency, by operation.")
+	fmt.Fprintln(cw, "# TYPE brokerstore_operation_duration_seconds histogram")
+	for _, operation := range sortedKeys(m.latencies) {
+		h := m.latencies[operation]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(cw, "brokerstore_operation_duration_seconds_bucket{operation=%q,le=%q} %d\n", operation, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(cw, "brokerstore_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", operation, h.count)
+		fmt.Fprintf(cw, "brokerstore_operation_duration_seconds_sum{operation=%q} %s\n", operation, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "brokerstore_operation_duration_seconds_count{operation=%q} %d\n", operation, h.count)
+	}
+
+	fmt.Fprintln(cw, "# HELP brokerstore_instances Service instance records in the store.")
+	fmt.Fprintln(cw, "# TYPE brokerstore_instances gauge")
+	fmt.Fprintf(cw, "brokerstore_instances %s\n", strconv.FormatFloat(m.instances, 'g', -1, 64))
+	fmt.Fprintln(cw, "# HELP brokerstore_bindings Service binding records in the store.")
+	fmt.Fprintln(cw, "# TYPE brokerstore_bindings gauge")
+	fmt.Fprintf(cw, "brokerstore_bindings %s\n", strconv.FormatFloat(m.bindings, 'g', -1, 64))
+
+	return cw.n, cw.err
+}
+
+// Handler serves the metrics in the Prometheus text exposition format
+func (m *StoreMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// ErrorClass buckets an error returned by a store into a small, fixed set of
+// label values suitable for metrics.
+func ErrorClass(err error) string {
+	var credhubErr *credhub.Error
+	var integrityErr *IntegrityError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return ""
+	case isNotFound(err):
+		return "not_found"
+	case errors.As(err, &integrityErr):
+		return "integrity"
+	case errors.Is(err, ErrUnknownKeyID):
+		return "encryption"
+	case errors.As(err, &credhubErr):
+		return "credhub"
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,381 @@
+package brokerstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// ErrQuotaExceeded matches, with errors.Is, every QuotaExceededError. Brokers
+// typically answer it with 422 Unprocessable Entity.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type QuotaScope string
+
+const (
+	// QuotaOrg, QuotaSpace and QuotaPlan limit instances
+	QuotaOrg   QuotaScope = "org"
+	QuotaSpace QuotaScope = "space"
+	QuotaPlan  QuotaScope = "plan"
+	// QuotaInstance limits bindings
+	QuotaInstance QuotaScope = "instance"
+)
+
+// QuotaLimit is the limit for every org, space, plan or instance, unless
+// overridden for its GUID or ID. Zero means unlimited.
+type QuotaLimit struct {
+	Default   int
+	Overrides map[string]int
+}
+
+func (l QuotaLimit) limitFor(key string) int {
+	if limit, ok := l.Overrides[key]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+type QuotaLimits struct {
+	InstancesPerOrg     QuotaLimit
+	InstancesPerSpace   QuotaLimit
+	InstancesPerPlan    QuotaLimit
+	BindingsPerInstance QuotaLimit
+}
+
+type QuotaExceededError struct {
+	Scope QuotaScope
+	Key   string
+	Limit int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %s %s allows at most %d", ErrQuotaExceeded, e.Scope, e.Key, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaStore rejects creates that would take an org, space or plan over its
+// instance limit, or an instance over its binding limit. It keeps counters
+// rather than listing the store on every create; they are loaded from the
+// wrapped store on first use, or by RefreshCounts, and then maintained on
+// every create, update and delete made through it. Records written around
+// it are only counted after the next RefreshCounts.
+//
+// Bindings are counted against the instance recorded by WithBindingInstance;
+// bindings without one are stored but not counted.
+type QuotaStore struct {
+	logger   lager.Logger
+	store    Store
+	limits   QuotaLimits
+	counters *quotaCounters
+}
+
+type quotaCounters struct {
+	mutex     sync.Mutex
+	loaded    bool
+	instances map[string]ServiceInstance
+	bindings  map[string]string
+	counts    map[QuotaScope]map[string]int
+}
+
+func NewQuotaStore(logger lager.Logger, store Store, limits QuotaLimits) *QuotaStore {
+	return &QuotaStore{
+		logger:   logger,
+		store:    store,
+		limits:   limits,
+		counters: &quotaCounters{},
+	}
+}
+
+func (s *QuotaStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *QuotaStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *QuotaStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+// RefreshCounts recounts every instance and binding in the wrapped store
+func (s *QuotaStore) RefreshCounts() error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+	return s.load()
+}
+
+// Count returns how many instances an org, space or plan has, or how many
+// bindings an instance has.
+func (s *QuotaStore) Count(scope QuotaScope, key string) (int, error) {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	return s.counters.counts[scope][key], nil
+}
+
+func (s *QuotaStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	return s.store.RetrieveInstanceDetails(id)
+}
+
+func (s *QuotaStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	return s.store.RetrieveBindingDetails(id)
+}
+
+func (s *QuotaStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	return s.store.RetrieveAllInstanceDetails()
+}
+
+func (s *QuotaStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	return s.store.RetrieveAllBindingDetails()
+}
+
+func (s *QuotaStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+
+	// A record being replaced does not count against its own quota
+	previous, replacing := s.counters.instances[id]
+	if replacing {
+		s.uncountInstance(id)
+	}
+	err := s.checkInstance(details)
+	if err == nil {
+		err = s.store.CreateInstanceDetails(id, details)
+	}
+	if err != nil {
+		if replacing {
+			s.countInstance(id, previous)
+		}
+		return err
+	}
+
+	s.countInstance(id, details)
+	return nil
+}
+
+func (s *QuotaStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+
+	previous, replacing := s.counters.bindings[id]
+	if replacing {
+		s.uncountBinding(id)
+	}
+	instanceID := BindingInstance(details)
+	err := s.check(QuotaInstance, instanceID, s.limits.BindingsPerInstance)
+	if err == nil {
+		err = s.store.CreateBindingDetails(id, details)
+	}
+	if err != nil {
+		if replacing {
+			s.countBinding(id, previous)
+		}
+		return err
+	}
+
+	s.countBinding(id, instanceID)
+	return nil
+}
+
+// UpdateInstanceDetails checks the plan quota when the update changes plan
+func (s *QuotaStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+
+	previous, known := s.counters.instances[id]
+	if known && details.PlanID != "" && details.PlanID != previous.PlanID {
+		if err := s.check(QuotaPlan, details.PlanID, s.limits.InstancesPerPlan); err != nil {
+			return err
+		}
+	}
+
+	if err := s.store.UpdateInstanceDetails(id, details); err != nil {
+		return err
+	}
+
+	if known && details.PlanID != "" {
+		updated := previous
+		updated.PlanID = details.PlanID
+		s.uncountInstance(id)
+		s.countInstance(id, updated)
+	}
+	return nil
+}
+
+func (s *QuotaStore) DeleteInstanceDetails(id string) error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.store.DeleteInstanceDetails(id); err != nil {
+		return err
+	}
+	if s.counters.loaded {
+		s.uncountInstance(id)
+	}
+	return nil
+}
+
+func (s *QuotaStore) DeleteBindingDetails(id string) error {
+	s.counters.mutex.Lock()
+	defer s.counters.mutex.Unlock()
+
+	if err := s.store.DeleteBindingDetails(id); err != nil {
+		return err
+	}
+	if s.counters.loaded {
+		s.uncountBinding(id)
+	}
+	return nil
+}
+
+func (s *QuotaStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	return s.store.IsInstanceConflict(id, details)
+}
+
+func (s *QuotaStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return s.store.IsBindingConflict(id, details)
+}
+
+func (s *QuotaStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *QuotaStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *QuotaStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+func (s *QuotaStore) checkInstance(details ServiceInstance) error {
+	if err := s.check(QuotaOrg, details.OrganizationGUID, s.limits.InstancesPerOrg); err != nil {
+		return err
+	}
+	if err := s.check(QuotaSpace, details.SpaceGUID, s.limits.InstancesPerSpace); err != nil {
+		return err
+	}
+	if err := s.check(QuotaPlan, details.PlanID, s.limits.InstancesPerPlan); err != nil {
+		return err
+	}
+	return nil
+}
+
+// check fails if one more record for key would exceed its limit
+func (s *QuotaStore) check(scope QuotaScope, key string, limit QuotaLimit) error {
+	if key == "" {
+		return nil
+	}
+	allowed := limit.limitFor(key)
+	if allowed > 0 && s.counters.counts[scope][key] >= allowed {
+		s.logger.Info("quota-exceeded", lager.Data{"scope": scope, "key": key, "limit": allowed})
+		return &QuotaExceededError{Scope: scope, Key: key, Limit: allowed}
+	}
+	return nil
+}
+
+func (s *QuotaStore) ensureLoaded() error {
+	if s.counters.loaded {
+		return nil
+	}
+	return s.load()
+}
+
+func (s *QuotaStore) load() error {
+	instances, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return err
+	}
+	bindings, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return err
+	}
+
+	s.counters.instances = map[string]ServiceInstance{}
+	s.counters.bindings = map[string]string{}
+	s.counters.counts = map[QuotaScope]map[string]int{
+		QuotaOrg:      {},
+		QuotaSpace:    {},
+		QuotaPlan:     {},
+		QuotaInstance: {},
+	}
+	for id, details := range instances {
+		s.countInstance(id, details)
+	}
+	for id, details := range bindings {
+		s.countBinding(id, BindingInstance(details))
+	}
+	s.counters.loaded = true
+	return nil
+}
+
+func (s *QuotaStore) countInstance(id string, details ServiceInstance) {
+	s.counters.instances[id] = ServiceInstance{
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+		PlanID:           details.PlanID,
+	}
+	s.adjust(QuotaOrg, details.OrganizationGUID, 1)
+	s.adjust(QuotaSpace, details.SpaceGUID, 1)
+	s.adjust(QuotaPlan, details.PlanID, 1)
+}
+
+func (s *QuotaStore) uncountInstance(id string) {
+	details, ok := s.counters.instances[id]
+	if !ok {
+		return
+	}
+	delete(s.counters.instances, id)
+	s.adjust(QuotaOrg, details.OrganizationGUID, -1)
+	s.adjust(QuotaSpace, details.SpaceGUID, -1)
+	s.adjust(QuotaPlan, details.PlanID, -1)
+}
+
+func (s *QuotaStore) countBinding(id, instanceID string) {
+	s.counters.bindings[id] = instanceID
+	s.adjust(QuotaInstance, instanceID, 1)
+}
+
+func (s *QuotaStore) uncountBinding(id string) {
+	instanceID, ok := s.counters.bindings[id]
+	if !ok {
+		return
+	}
+	delete(s.counters.bindings, id)
+	s.adjust(QuotaInstance, instanceID, -1)
+}
+
+func (s *QuotaStore) adjust(scope QuotaScope, key string, delta int) {
+	if key == "" {
+		return
+	}
+	s.counters.counts[scope][key] += delta
+	if s.counters.counts[scope][key] <= 0 {
+		delete(s.counters.counts[scope], key)
+	}
+}
@@ -0,0 +1,152 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaStore", func() {
+	var (
+		fakeStore *brokerstorefakes.FakeStore
+		instances map[string]ServiceInstance
+		store     *QuotaStore
+		limits    QuotaLimits
+	)
+
+	instance := func(org, space, plan string) ServiceInstance {
+		return ServiceInstance{ServiceID: "service-id", PlanID: plan, OrganizationGUID: org, SpaceGUID: space}
+	}
+
+	binding := func(instanceID string) domain.BindDetails {
+		details, err := WithBindingInstance(domain.BindDetails{AppGUID: "app-guid"}, instanceID)
+		Expect(err).NotTo(HaveOccurred())
+		return details
+	}
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, _ = backStoreWithMemory(fakeStore)
+		limits = QuotaLimits{
+			InstancesPerOrg:     QuotaLimit{Default: 2, Overrides: map[string]int{"big-org": 0}},
+			InstancesPerPlan:    QuotaLimit{Overrides: map[string]int{"large": 1}},
+			BindingsPerInstance: QuotaLimit{Default: 1},
+		}
+	})
+
+	JustBeforeEach(func() {
+		store = NewQuotaStore(lagertest.NewTestLogger("quota-store"), fakeStore, limits)
+	})
+
+	It("should reject instances beyond the org limit with ErrQuotaExceeded", func() {
+		Expect(store.CreateInstanceDetails("instance-1", instance("org", "space", "small"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", instance("org", "space", "small"))).To(Succeed())
+
+		err := store.CreateInstanceDetails("instance-3", instance("org", "space", "small"))
+		Expect(errors.Is(err, ErrQuotaExceeded)).To(BeTrue())
+		Expect(err).To(MatchError(&QuotaExceededError{Scope: QuotaOrg, Key: "org", Limit: 2}))
+		Expect(instances).NotTo(HaveKey("instance-3"))
+
+		Expect(store.CreateInstanceDetails("instance-3", instance("other-org", "space", "small"))).To(Succeed())
+	})
+
+	It("should apply overrides, where zero is unlimited", func() {
+		for _, id := range []string{"instance-1", "instance-2", "instance-3"} {
+			Expect(store.CreateInstanceDetails(id, instance("big-org", "space", "small"))).To(Succeed())
+		}
+		Expect(store.CreateInstanceDetails("instance-4", instance("big-org", "space", "large"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-5", instance("big-org", "space", "large"))).To(MatchError(ErrQuotaExceeded))
+	})
+
+	It("should not count a replaced instance against its own quota", func() {
+		Expect(store.CreateInstanceDetails("instance-1", instance("org", "space", "small"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", instance("org", "space", "small"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", instance("org", "space", "medium"))).To(Succeed())
+		Expect(store.Count(QuotaOrg, "org")).To(Equal(2))
+	})
+
+	It("should free quota on delete", func() {
+		Expect(store.CreateInstanceDetails("instance-1", instance("org", "space", "small"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", instance("org", "space", "small"))).To(Succeed())
+		Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-3", instance("org", "space", "small"))).To(Succeed())
+	})
+
+	It("should check the plan quota on a plan change", func() {
+		Expect(store.CreateInstanceDetails("instance-1", instance("org", "space", "large"))).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-2", instance("org", "space", "small"))).To(Succeed())
+
+		err := store.UpdateInstanceDetails("instance-2", domain.UpdateDetails{PlanID: "large"})
+		Expect(err).To(MatchError(&QuotaExceededError{Scope: QuotaPlan, Key: "large", Limit: 1}))
+		Expect(fakeStore.UpdateInstanceDetailsCallCount()).To(Equal(0))
+
+		Expect(store.UpdateInstanceDetails("instance-1", domain.UpdateDetails{PlanID: "small"})).To(Succeed())
+		Expect(store.Count(QuotaPlan, "small")).To(Equal(2))
+		Expect(store.Count(QuotaPlan, "large")).To(Equal(0))
+	})
+
+	It("should limit bindings per recorded instance", func() {
+		Expect(store.CreateBindingDetails("binding-1", binding("instance-1"))).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-1", binding("instance-1"))).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-2", binding("instance-1"))).To(MatchError(&QuotaExceededError{Scope: QuotaInstance, Key: "instance-1", Limit: 1}))
+		Expect(store.CreateBindingDetails("binding-3", binding("instance-2"))).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-4", domain.BindDetails{AppGUID: "app-guid"})).To(Succeed())
+
+		Expect(store.DeleteBindingDetails("binding-1")).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-2", binding("instance-1"))).To(Succeed())
+	})
+
+	It("should not report a bind retried with the platform's own context as a conflict", func() {
+		fakeCredhub := &credhub_fakes.FakeCredhub{}
+		backCredhubWith(fakeCredhub, map[string]values.JSON{})
+		store = NewQuotaStore(lagertest.NewTestLogger("quota-store"), NewCredhubStore(lagertest.NewTestLogger("credhub-store"), fakeCredhub, "some-store-id"), limits)
+
+		platformDetails := domain.BindDetails{AppGUID: "app-guid", RawContext: []byte(`{"platform":"cloudfoundry"}`)}
+		recorded, err := WithBindingInstance(platformDetails, "instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.CreateBindingDetails("binding-1", recorded)).To(Succeed())
+
+		Expect(store.IsBindingConflict("binding-1", platformDetails)).To(BeFalse())
+		Expect(store.IsBindingConflict("binding-1", recorded)).To(BeFalse())
+	})
+
+	Context("when the wrapped store already holds records", func() {
+		BeforeEach(func() {
+			Expect(fakeStore.CreateInstanceDetails("instance-1", instance("org", "space", "small"))).To(Succeed())
+			Expect(fakeStore.CreateInstanceDetails("instance-2", instance("org", "space", "small"))).To(Succeed())
+			Expect(fakeStore.CreateBindingDetails("binding-1", binding("instance-1"))).To(Succeed())
+		})
+
+		It("should count them before the first create", func() {
+			Expect(store.CreateInstanceDetails("instance-3", instance("org", "space", "small"))).To(MatchError(ErrQuotaExceeded))
+			Expect(store.CreateBindingDetails("binding-2", binding("instance-1"))).To(MatchError(ErrQuotaExceeded))
+			Expect(fakeStore.RetrieveAllInstanceDetailsCallCount()).To(Equal(1))
+		})
+
+		It("should pick up records written around it on RefreshCounts", func() {
+			Expect(store.Count(QuotaOrg, "org")).To(Equal(2))
+			Expect(fakeStore.DeleteInstanceDetails("instance-2")).To(Succeed())
+			Expect(store.RefreshCounts()).To(Succeed())
+			Expect(store.Count(QuotaOrg, "org")).To(Equal(1))
+		})
+	})
+
+	Context("when the counts cannot be loaded", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveAllInstanceDetailsReturns(nil, errors.New("credhub-down"))
+			fakeStore.RetrieveAllInstanceDetailsStub = nil
+		})
+
+		It("should refuse to create rather than skip the quota", func() {
+			Expect(store.CreateInstanceDetails("instance-1", instance("org", "space", "small"))).To(MatchError("credhub-down"))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+		})
+	})
+})
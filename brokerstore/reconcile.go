@@ -0,0 +1,272 @@
+package brokerstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Inventory lists the instances and bindings the platform believes exist.
+// Expected attributes left empty are not compared against the store.
+type Inventory interface {
+	Instances() (map[string]InventoryInstance, error)
+	Bindings() (map[string]InventoryBinding, error)
+}
+
+type InventoryInstance struct {
+	ServiceID        string
+	PlanID           string
+	OrganizationGUID string
+	SpaceGUID        string
+}
+
+type InventoryBinding struct {
+	ServiceID string
+	PlanID    string
+	AppGUID   string
+}
+
+type ReconcileEventType string
+
+const (
+	// ReconcileAdd is emitted for records the platform has but the store lacks
+	ReconcileAdd ReconcileEventType = "add"
+	// ReconcileRemove is emitted for records the store has but the platform lacks
+	ReconcileRemove ReconcileEventType = "remove"
+	// ReconcileDrift is emitted when both have a record but their attributes differ
+	ReconcileDrift ReconcileEventType = "drift"
+)
+
+type ReconcileEvent struct {
+	Type ReconcileEventType `json:"type"`
+	Kind RecordKind         `json:"kind"`
+	ID   string             `json:"id"`
+	// Fields names the drifted attributes, using their JSON names
+	Fields []string `json:"fields,omitempty"`
+	// Cleaned is set when auto-cleanup deleted the record from the store
+	Cleaned bool `json:"cleaned,omitempty"`
+}
+
+type ReconcileOptions struct {
+	// AutoCleanup deletes records the platform no longer knows about
+	AutoCleanup bool
+
+	// MaxRemovals caps how many records a single auto-cleanup may delete, so
+	// that a truncated inventory cannot empty the store. Zero means no cap.
+	MaxRemovals int
+}
+
+// ErrCleanupRefused is returned, along with the events, when auto-cleanup
+// would delete more than looks safe. Nothing is deleted.
+var ErrCleanupRefused = errors.New("refusing to auto-clean up")
+
+type Reconciler struct {
+	logger    lager.Logger
+	store     Store
+	inventory Inventory
+	opts      ReconcileOptions
+}
+
+func NewReconciler(logger lager.Logger, store Store, inventory Inventory, opts ReconcileOptions) *Reconciler {
+	return &Reconciler{
+		logger:    logger,
+		store:     store,
+		inventory: inventory,
+		opts:      opts,
+	}
+}
+
+// Reconcile diffs the store against the inventory. Events are ordered with
+// bindings before instances so that auto-cleanup never leaves a binding
+// pointing at an already deleted instance.
+func (r *Reconciler) Reconcile() ([]ReconcileEvent, error) {
+	logger := r.logger.Session("reconcile", lager.Data{"auto-cleanup": r.opts.AutoCleanup})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	expectedInstances, err := r.inventory.Instances()
+	if err != nil {
+		return nil, err
+	}
+	expectedBindings, err := r.inventory.Bindings()
+	if err != nil {
+		return nil, err
+	}
+
+	storedInstances, err := r.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+	storedBindings, err := r.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	bindingEvents := []ReconcileEvent{}
+	for id, stored := range storedBindings {
+		expected, ok := expectedBindings[id]
+		if !ok {
+			bindingEvents = append(bindingEvents, ReconcileEvent{Type: ReconcileRemove, Kind: BindingRecord, ID: id})
+			continue
+		}
+		var fields []string
+		fields = appendIfDrifted(fields, "service_id", expected.ServiceID, stored.ServiceID)
+		fields = appendIfDrifted(fields, "plan_id", expected.PlanID, stored.PlanID)
+		fields = appendIfDrifted(fields, "app_guid", expected.AppGUID, stored.AppGUID)
+		if len(fields) > 0 {
+			bindingEvents = append(bindingEvents, ReconcileEvent{Type: ReconcileDrift, Kind: BindingRecord, ID: id, Fields: fields})
+		}
+	}
+	for id := range expectedBindings {
+		if _, ok := storedBindings[id]; !ok {
+			bindingEvents = append(bindingEvents, ReconcileEvent{Type: ReconcileAdd, Kind: BindingRecord, ID: id})
+		}
+	}
+
+	instanceEvents := []ReconcileEvent{}
+	for id, stored := range storedInstances {
+		expected, ok := expectedInstances[id]
+		if !ok {
+			instanceEvents = append(instanceEvents, ReconcileEvent{Type: ReconcileRemove, Kind: InstanceRecord, ID: id})
+			continue
+		}
+		var fields []string
+		fields = appendIfDrifted(fields, "service_id", expected.ServiceID, stored.ServiceID)
+		fields = appendIfDrifted(fields, "plan_id", expected.PlanID, stored.PlanID)
+		fields = appendIfDrifted(fields, "organization_guid", expected.OrganizationGUID, stored.OrganizationGUID)
+		fields = appendIfDrifted(fields, "space_guid", expected.SpaceGUID, stored.SpaceGUID)
+		if len(fields) > 0 {
+			instanceEvents = append(instanceEvents, ReconcileEvent{Type: ReconcileDrift, Kind: InstanceRecord, ID: id, Fields: fields})
+		}
+	}
+	for id := range expectedInstances {
+		if _, ok := storedInstances[id]; !ok {
+			instanceEvents = append(instanceEvents, ReconcileEvent{Type: ReconcileAdd, Kind: InstanceRecord, ID: id})
+		}
+	}
+
+	sortReconcileEvents(bindingEvents)
+	sortReconcileEvents(instanceEvents)
+	events := append(bindingEvents, instanceEvents...)
+
+	removals := 0
+	for _, event := range events {
+		logger.Info("event", lager.Data{"type": event.Type, "kind": event.Kind, "id": event.ID, "fields": event.Fields})
+		if event.Type == ReconcileRemove {
+			removals++
+		}
+	}
+	if !r.opts.AutoCleanup || removals == 0 {
+		return events, nil
+	}
+
+	// An empty inventory is far more likely a platform outage than every
+	// instance having been deleted behind the broker's back
+	if len(expectedInstances) == 0 && len(expectedBindings) == 0 {
+		err = fmt.Errorf("%w: the inventory is empty but the store holds %d records", ErrCleanupRefused, len(storedInstances)+len(storedBindings))
+	} else if r.opts.MaxRemovals > 0 && removals > r.opts.MaxRemovals {
+		err = fmt.Errorf("%w: %d records to remove exceeds the maximum of %d", ErrCleanupRefused, removals, r.opts.MaxRemovals)
+	}
+	if err != nil {
+		logger.Error("cleanup-refused", err)
+		return events, err
+	}
+
+	for i, event := range events {
+		if event.Type != ReconcileRemove {
+			continue
+		}
+
+		if event.Kind == BindingRecord {
+			err = r.store.DeleteBindingDetails(event.ID)
+		} else {
+			err = r.store.DeleteInstanceDetails(event.ID)
+		}
+		if err != nil {
+			logger.Error("failed-to-clean-up", err, lager.Data{"kind": event.Kind, "id": event.ID})
+			return events, err
+		}
+		events[i].Cleaned = true
+	}
+
+	return events, nil
+}
+
+func appendIfDrifted(fields []string, name, expected, stored string) []string {
+	if expected != "" && expected != stored {
+		return append(fields, name)
+	}
+	return fields
+}
+
+func sortReconcileEvents(events []ReconcileEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Type != events[j].Type {
+			return events[i].Type < events[j].Type
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
+// MemoryInventory is an Inventory held in memory, intended for tests
+type MemoryInventory struct {
+	mutex     sync.RWMutex
+	instances map[string]InventoryInstance
+	bindings  map[string]InventoryBinding
+}
+
+func NewMemoryInventory() *MemoryInventory {
+	return &MemoryInventory{
+		instances: map[string]InventoryInstance{},
+		bindings:  map[string]InventoryBinding{},
+	}
+}
+
+func (i *MemoryInventory) AddInstance(id string, instance InventoryInstance) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.instances[id] = instance
+}
+
+func (i *MemoryInventory) RemoveInstance(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.instances, id)
+}
+
+func (i *MemoryInventory) AddBinding(id string, binding InventoryBinding) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.bindings[id] = binding
+}
+
+func (i *MemoryInventory) RemoveBinding(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.bindings, id)
+}
+
+func (i *MemoryInventory) Instances() (map[string]InventoryInstance, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	instances := make(map[string]InventoryInstance, len(i.instances))
+	for id, instance := range i.instances {
+		instances[id] = instance
+	}
+	return instances, nil
+}
+
+func (i *MemoryInventory) Bindings() (map[string]InventoryBinding, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	bindings := make(map[string]InventoryBinding, len(i.bindings))
+	for id, binding := range i.bindings {
+		bindings[id] = binding
+	}
+	return bindings, nil
+}
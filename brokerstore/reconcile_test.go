@@ -0,0 +1,139 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reconciler", func() {
+	var (
+		fakeStore  *brokerstorefakes.FakeStore
+		inventory  *MemoryInventory
+		opts       ReconcileOptions
+		reconciler *Reconciler
+		events     []ReconcileEvent
+		err        error
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		fakeStore.RetrieveAllInstanceDetailsReturns(map[string]ServiceInstance{
+			"instance-1": {ServiceID: "service-id", PlanID: "plan-id", OrganizationGUID: "org-guid", SpaceGUID: "space-guid"},
+			"instance-2": {ServiceID: "service-id", PlanID: "plan-id", OrganizationGUID: "org-guid", SpaceGUID: "space-guid"},
+		}, nil)
+		fakeStore.RetrieveAllBindingDetailsReturns(map[string]domain.BindDetails{
+			"binding-1": {AppGUID: "app-guid", PlanID: "plan-id", ServiceID: "service-id"},
+			"binding-2": {AppGUID: "app-guid", PlanID: "plan-id", ServiceID: "service-id"},
+		}, nil)
+
+		inventory = NewMemoryInventory()
+		inventory.AddInstance("instance-1", InventoryInstance{PlanID: "other-plan-id", SpaceGUID: "space-guid"})
+		inventory.AddInstance("instance-3", InventoryInstance{})
+		inventory.AddBinding("binding-1", InventoryBinding{AppGUID: "app-guid"})
+
+		opts = ReconcileOptions{}
+	})
+
+	JustBeforeEach(func() {
+		reconciler = NewReconciler(lagertest.NewTestLogger("reconcile"), fakeStore, inventory, opts)
+		events, err = reconciler.Reconcile()
+	})
+
+	It("should emit add, remove and drift events with bindings first", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(Equal([]ReconcileEvent{
+			{Type: ReconcileRemove, Kind: BindingRecord, ID: "binding-2"},
+			{Type: ReconcileAdd, Kind: InstanceRecord, ID: "instance-3"},
+			{Type: ReconcileDrift, Kind: InstanceRecord, ID: "instance-1", Fields: []string{"plan_id"}},
+			{Type: ReconcileRemove, Kind: InstanceRecord, ID: "instance-2"},
+		}))
+	})
+
+	It("should not modify the store", func() {
+		Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+		Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+	})
+
+	Context("when auto-cleanup is enabled", func() {
+		BeforeEach(func() {
+			opts.AutoCleanup = true
+		})
+
+		It("should delete the records the platform no longer has", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+			Expect(fakeStore.DeleteBindingDetailsArgsForCall(0)).To(Equal("binding-2"))
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(1))
+			Expect(fakeStore.DeleteInstanceDetailsArgsForCall(0)).To(Equal("instance-2"))
+			Expect(events[0].Cleaned).To(BeTrue())
+			Expect(events[3].Cleaned).To(BeTrue())
+		})
+
+		Context("when the inventory is empty", func() {
+			BeforeEach(func() {
+				inventory = NewMemoryInventory()
+			})
+
+			It("should refuse to wipe the store", func() {
+				Expect(errors.Is(err, ErrCleanupRefused)).To(BeTrue())
+				Expect(events).To(HaveLen(4))
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+				Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when more records would be removed than allowed", func() {
+			BeforeEach(func() {
+				opts.MaxRemovals = 1
+			})
+
+			It("should refuse to clean up any of them", func() {
+				Expect(err).To(MatchError(ErrCleanupRefused))
+				Expect(events).To(HaveLen(4))
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+				Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when a delete fails", func() {
+			BeforeEach(func() {
+				fakeStore.DeleteBindingDetailsReturns(errors.New("bad-delete"))
+			})
+
+			It("should stop and return the error", func() {
+				Expect(err).To(MatchError("bad-delete"))
+				Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("when the store cannot be listed", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveAllInstanceDetailsReturns(nil, errors.New("bad-list"))
+		})
+
+		It("should return the error", func() {
+			Expect(err).To(MatchError("bad-list"))
+		})
+	})
+
+	Context("when the inventory and store agree", func() {
+		BeforeEach(func() {
+			inventory.RemoveInstance("instance-3")
+			inventory.AddInstance("instance-1", InventoryInstance{PlanID: "plan-id"})
+			inventory.AddInstance("instance-2", InventoryInstance{})
+			inventory.AddBinding("binding-2", InventoryBinding{})
+		})
+
+		It("should emit no events", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(BeEmpty())
+		})
+	})
+})
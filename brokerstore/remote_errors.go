@@ -0,0 +1,130 @@
+package brokerstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+)
+
+// Error types sent by a RemoteStoreServer. Each typed error is decoded by
+// RemoteStore back into the Go type the wrapped store returned.
+const (
+	remoteNotFound               = "not_found"
+	remoteQuotaExceeded          = "quota_exceeded"
+	remotePreviousValuesMismatch = "previous_values_mismatch"
+	remoteBindingSpace           = "binding_space"
+	remoteIntegrity              = "integrity"
+	remoteSchemaVersion          = "schema_version"
+	remoteUnsupportedFeature     = "unsupported_feature"
+	remoteUnauthorized           = "unauthorized"
+	remoteForbidden              = "forbidden"
+	remoteBadRequest             = "bad_request"
+	remoteInternal               = "internal"
+)
+
+// RemoteError is returned by RemoteStore for errors that have no Go type of
+// their own, including transport failures reported by the server.
+type RemoteError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote store responded %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+type remoteErrorBody struct {
+	Error remoteErrorDetail `json:"error"`
+}
+
+type remoteErrorDetail struct {
+	Type    string          `json:"type"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// encodeRemoteError returns the status and body sent for err. Untyped errors
+// are sent with a generic message, as they may describe the server's own
+// backend; RemoteStoreServer logs them in full.
+func encodeRemoteError(err error) (int, remoteErrorBody) {
+	var (
+		notFound    *credhub.NotFoundError
+		quota       *QuotaExceededError
+		mismatch    *PreviousValuesMismatchError
+		space       *BindingSpaceError
+		integrity   *IntegrityError
+		schema      *SchemaVersionError
+		unsupported *credhub_shims.UnsupportedFeatureError
+		remote      *RemoteError
+	)
+
+	status, errorType, detail := http.StatusInternalServerError, remoteInternal, interface{}(nil)
+	switch {
+	case errors.As(err, &notFound):
+		status, errorType, detail = http.StatusNotFound, remoteNotFound, notFound
+	case isNotFound(err):
+		status, errorType, detail = http.StatusNotFound, remoteNotFound, &credhub.NotFoundError{Description: err.Error()}
+	case errors.As(err, &quota):
+		status, errorType, detail = http.StatusUnprocessableEntity, remoteQuotaExceeded, quota
+	case errors.As(err, &mismatch):
+		status, errorType, detail = http.StatusConflict, remotePreviousValuesMismatch, mismatch
+	case errors.As(err, &space):
+		status, errorType, detail = http.StatusUnprocessableEntity, remoteBindingSpace, space
+	case errors.As(err, &integrity):
+		errorType, detail = remoteIntegrity, integrity
+	case errors.As(err, &schema):
+		errorType, detail = remoteSchemaVersion, schema
+	case errors.As(err, &unsupported):
+		status, errorType, detail = http.StatusNotImplemented, remoteUnsupportedFeature, unsupported
+	case errors.As(err, &remote):
+		// A RemoteStore served again keeps the upstream error as it was
+		status, errorType = remote.StatusCode, remote.Type
+	}
+
+	message := err.Error()
+	if errorType == remoteInternal {
+		message = "internal error"
+	}
+
+	body := remoteErrorBody{Error: remoteErrorDetail{Type: errorType, Message: message}}
+	if detail != nil {
+		body.Error.Detail, _ = json.Marshal(detail)
+	}
+	return status, body
+}
+
+// decodeRemoteError rebuilds the error a server responded with
+func decodeRemoteError(statusCode int, body remoteErrorBody) error {
+	var typed error
+	switch body.Error.Type {
+	case remoteNotFound:
+		typed = &credhub.NotFoundError{}
+	case remoteQuotaExceeded:
+		typed = &QuotaExceededError{}
+	case remotePreviousValuesMismatch:
+		typed = &PreviousValuesMismatchError{}
+	case remoteBindingSpace:
+		typed = &BindingSpaceError{}
+	case remoteIntegrity:
+		typed = &IntegrityError{}
+	case remoteSchemaVersion:
+		typed = &SchemaVersionError{}
+	case remoteUnsupportedFeature:
+		typed = &credhub_shims.UnsupportedFeatureError{}
+	}
+
+	if typed != nil && len(body.Error.Detail) > 0 && json.Unmarshal(body.Error.Detail, typed) == nil {
+		return typed
+	}
+
+	errorType := body.Error.Type
+	if errorType == "" {
+		errorType = remoteInternal
+	}
+	return &RemoteError{StatusCode: statusCode, Type: errorType, Message: body.Error.Message}
+}
@@ -0,0 +1,275 @@
+package brokerstore
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type RemoteServerOptions struct {
+	// Tokens maps each accepted bearer token to the name of the client using
+	// it, which is logged with its requests and attributed in audit entries
+	// when the served store is an AuditingStore. Unless LifecycleClients is
+	// set, every one of these clients can also restore, save and clean up the
+	// whole store.
+	Tokens map[string]string
+
+	// LifecycleClients, when set, names the only clients allowed to call
+	// Restore, Save and Cleanup; any other client is refused with 403.
+	LifecycleClients []string
+
+	// MaxBodyBytes limits request bodies. It defaults to 1 MiB.
+	MaxBodyBytes int64
+}
+
+const defaultRemoteMaxBodyBytes = 1 << 20
+
+// RemoteStoreServer serves a Store as HTTP/JSON for RemoteStore clients.
+// Every request must carry one of the configured bearer tokens. It is a
+// plain http.Handler: serve it over TLS, as the tokens travel in the clear.
+type RemoteStoreServer struct {
+	logger lager.Logger
+	store  Store
+	opts   RemoteServerOptions
+	mux    *http.ServeMux
+}
+
+func NewRemoteStoreServer(logger lager.Logger, store Store, opts RemoteServerOptions) *RemoteStoreServer {
+	s := &RemoteStoreServer{
+		logger: logger.Session("remote-store-server"),
+		store:  store,
+		opts:   opts,
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /v1/instances", s.retrieveAllInstances)
+	s.mux.HandleFunc("GET /v1/instances/{id}", s.retrieveInstance)
+	s.mux.HandleFunc("PUT /v1/instances/{id}", s.createInstance)
+	s.mux.HandleFunc("PATCH /v1/instances/{id}", s.updateInstance)
+	s.mux.HandleFunc("DELETE /v1/instances/{id}", s.deleteInstance)
+	s.mux.HandleFunc("POST /v1/instances/{id}/conflict", s.instanceConflict)
+
+	s.mux.HandleFunc("GET /v1/bindings", s.retrieveAllBindings)
+	s.mux.HandleFunc("GET /v1/bindings/{id}", s.retrieveBinding)
+	s.mux.HandleFunc("PUT /v1/bindings/{id}", s.createBinding)
+	s.mux.HandleFunc("DELETE /v1/bindings/{id}", s.deleteBinding)
+	s.mux.HandleFunc("POST /v1/bindings/{id}/conflict", s.bindingConflict)
+
+	s.mux.HandleFunc("GET /v1/health", s.health)
+
+	s.mux.HandleFunc("POST /v1/restore", s.lifecycle(func(store Store, logger lager.Logger) error { return store.Restore(logger) }))
+	s.mux.HandleFunc("POST /v1/save", s.lifecycle(func(store Store, logger lager.Logger) error { return store.Save(logger) }))
+	s.mux.HandleFunc("POST /v1/cleanup", s.lifecycle(func(store Store, _ lager.Logger) error { return store.Cleanup() }))
+	return s
+}
+
+func (s *RemoteStoreServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.authenticate(r)
+	if !ok {
+		s.logger.Info("unauthorized", lager.Data{"method": r.Method, "path": r.URL.Path})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="brokerstore"`)
+		writeRemoteJSON(w, http.StatusUnauthorized, remoteErrorBody{Error: remoteErrorDetail{Type: remoteUnauthorized, Message: "missing or invalid bearer token"}})
+		return
+	}
+
+	r = r.WithContext(withRemoteClient(r.Context(), client))
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticate compares the request's token with every configured token, in
+// constant time, and returns the matching client's name.
+func (s *RemoteStoreServer) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	client, found := "", false
+	for candidate, name := range s.opts.Tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			client, found = name, true
+		}
+	}
+	return client, found
+}
+
+// storeFor binds the request's context, and client for auditing, to the store
+func (s *RemoteStoreServer) storeFor(r *http.Request) (Store, lager.Logger) {
+	client := remoteClientOf(r.Context())
+	logger := s.logger.Session("request", lager.Data{"client": client, "method": r.Method, "path": r.URL.Path})
+
+	store := s.store
+	if auditing, ok := store.(*AuditingStore); ok {
+		store = auditing.WithActor(client)
+	}
+	return storeWithContext(store, r.Context()), logger
+}
+
+func (s *RemoteStoreServer) retrieveInstance(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	details, err := store.RetrieveInstanceDetails(r.PathValue("id"))
+	s.respond(w, logger, details, err)
+}
+
+func (s *RemoteStoreServer) retrieveAllInstances(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	instances, err := store.RetrieveAllInstanceDetails()
+	s.respond(w, logger, instances, err)
+}
+
+func (s *RemoteStoreServer) createInstance(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	var details ServiceInstance
+	if !s.decode(w, logger, r, &details) {
+		return
+	}
+	s.respond(w, logger, nil, store.CreateInstanceDetails(r.PathValue("id"), details))
+}
+
+func (s *RemoteStoreServer) updateInstance(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	var details domain.UpdateDetails
+	if !s.decode(w, logger, r, &details) {
+		return
+	}
+	s.respond(w, logger, nil, store.UpdateInstanceDetails(r.PathValue("id"), details))
+}
+
+func (s *RemoteStoreServer) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	s.respond(w, logger, nil, store.DeleteInstanceDetails(r.PathValue("id")))
+}
+
+func (s *RemoteStoreServer) instanceConflict(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	var details ServiceInstance
+	if !s.decode(w, logger, r, &details) {
+		return
+	}
+	s.respond(w, logger, remoteConflict{Conflict: store.IsInstanceConflict(r.PathValue("id"), details)}, nil)
+}
+
+func (s *RemoteStoreServer) retrieveBinding(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	details, err := store.RetrieveBindingDetails(r.PathValue("id"))
+	s.respond(w, logger, details, err)
+}
+
+func (s *RemoteStoreServer) retrieveAllBindings(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	bindings, err := store.RetrieveAllBindingDetails()
+	s.respond(w, logger, bindings, err)
+}
+
+func (s *RemoteStoreServer) createBinding(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	var details domain.BindDetails
+	if !s.decode(w, logger, r, &details) {
+		return
+	}
+	s.respond(w, logger, nil, store.CreateBindingDetails(r.PathValue("id"), details))
+}
+
+func (s *RemoteStoreServer) deleteBinding(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	s.respond(w, logger, nil, store.DeleteBindingDetails(r.PathValue("id")))
+}
+
+func (s *RemoteStoreServer) bindingConflict(w http.ResponseWriter, r *http.Request) {
+	store, logger := s.storeFor(r)
+	var details domain.BindDetails
+	if !s.decode(w, logger, r, &details) {
+		return
+	}
+	s.respond(w, logger, remoteConflict{Conflict: store.IsBindingConflict(r.PathValue("id"), details)}, nil)
+}
+
+// health responds 200 with the served store's HealthStatus, healthy or not,
+// so that clients can tell an unhealthy store from an unreachable server.
+func (s *RemoteStoreServer) health(w http.ResponseWriter, r *http.Request) {
+	store, _ := s.storeFor(r)
+
+	checker, ok := store.(HealthChecker)
+	if !ok {
+		writeRemoteJSON(w, http.StatusOK, storeHealth(store))
+		return
+	}
+	status, _ := healthWithin(r.Context(), checker)
+	writeRemoteJSON(w, http.StatusOK, status)
+}
+
+func (s *RemoteStoreServer) lifecycle(call func(Store, lager.Logger) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, logger := s.storeFor(r)
+		if len(s.opts.LifecycleClients) > 0 && !slices.Contains(s.opts.LifecycleClients, remoteClientOf(r.Context())) {
+			logger.Info("forbidden")
+			writeRemoteJSON(w, http.StatusForbidden, remoteErrorBody{Error: remoteErrorDetail{Type: remoteForbidden, Message: "client may not call lifecycle endpoints"}})
+			return
+		}
+		s.respond(w, logger, nil, call(store, logger))
+	}
+}
+
+func (s *RemoteStoreServer) decode(w http.ResponseWriter, logger lager.Logger, r *http.Request, into interface{}) bool {
+	maxBodyBytes := s.opts.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultRemoteMaxBodyBytes
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(into); err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		logger.Info("bad-request", lager.Data{"error": err.Error()})
+		writeRemoteJSON(w, status, remoteErrorBody{Error: remoteErrorDetail{Type: remoteBadRequest, Message: err.Error()}})
+		return false
+	}
+	return true
+}
+
+// respond writes body, or err encoded so that RemoteStore can rebuild it
+func (s *RemoteStoreServer) respond(w http.ResponseWriter, logger lager.Logger, body interface{}, err error) {
+	if err != nil {
+		status, errorBody := encodeRemoteError(err)
+		if status >= http.StatusInternalServerError {
+			logger.Error("failed", err)
+		}
+		writeRemoteJSON(w, status, errorBody)
+		return
+	}
+	if body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRemoteJSON(w, http.StatusOK, body)
+}
+
+type remoteClientKey struct{}
+
+func withRemoteClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, remoteClientKey{}, client)
+}
+
+func remoteClientOf(ctx context.Context) string {
+	client, _ := ctx.Value(remoteClientKey{}).(string)
+	return client
+}
+
+type remoteConflict struct {
+	Conflict bool `json:"conflict"`
+}
+
+func writeRemoteJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,243 @@
+package brokerstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type RemoteStoreOptions struct {
+	// URL of the RemoteStoreServer, e.g. https://store.internal:8443
+	URL   string
+	Token string
+	// CACert is a PEM bundle trusted in place of the system roots
+	CACert string
+	// ClientCert and ClientKey, in PEM, are presented to servers requiring
+	// mutual TLS.
+	ClientCert string
+	ClientKey  string
+	Timeout    time.Duration
+	// Client replaces the HTTP client built from the TLS options
+	Client *http.Client
+}
+
+// RemoteStore is a Store backed by a RemoteStoreServer. Errors the server's
+// store returned come back as the same Go types, such as
+// *credhub.NotFoundError or *QuotaExceededError, so callers handle them as
+// they would locally; anything else is a *RemoteError.
+type RemoteStore struct {
+	logger  lager.Logger
+	baseURL string
+	token   string
+	client  *http.Client
+	ctx     context.Context
+}
+
+func NewRemoteStore(logger lager.Logger, opts RemoteStoreOptions) (*RemoteStore, error) {
+	if opts.URL == "" {
+		return nil, errors.New("remote store URL is required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	client := opts.Client
+	if client == nil {
+		tlsConfig, err := remoteTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		}
+	}
+
+	return &RemoteStore{
+		logger:  logger.Session("remote-store", lager.Data{"url": opts.URL}),
+		baseURL: strings.TrimSuffix(opts.URL, "/"),
+		token:   opts.Token,
+		client:  client,
+		ctx:     context.Background(),
+	}, nil
+}
+
+func remoteTLSConfig(opts RemoteStoreOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(opts.CACert)) {
+			return nil, errors.New("remote store CA certificate is not valid PEM")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(opts.ClientCert), []byte(opts.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("loading remote store client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+func (s *RemoteStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.ctx = ctx
+	return &withContext
+}
+
+func (s *RemoteStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	var details ServiceInstance
+	err := s.call(http.MethodGet, "instances/"+url.PathEscape(id), nil, &details)
+	return details, err
+}
+
+func (s *RemoteStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	var details domain.BindDetails
+	err := s.call(http.MethodGet, "bindings/"+url.PathEscape(id), nil, &details)
+	return details, err
+}
+
+func (s *RemoteStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	instances := map[string]ServiceInstance{}
+	if err := s.call(http.MethodGet, "instances", nil, &instances); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (s *RemoteStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	bindings := map[string]domain.BindDetails{}
+	if err := s.call(http.MethodGet, "bindings", nil, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+func (s *RemoteStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	return s.call(http.MethodPut, "instances/"+url.PathEscape(id), details, nil)
+}
+
+func (s *RemoteStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	return s.call(http.MethodPut, "bindings/"+url.PathEscape(id), details, nil)
+}
+
+func (s *RemoteStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	return s.call(http.MethodPatch, "instances/"+url.PathEscape(id), details, nil)
+}
+
+func (s *RemoteStore) DeleteInstanceDetails(id string) error {
+	return s.call(http.MethodDelete, "instances/"+url.PathEscape(id), nil, nil)
+}
+
+func (s *RemoteStore) DeleteBindingDetails(id string) error {
+	return s.call(http.MethodDelete, "bindings/"+url.PathEscape(id), nil, nil)
+}
+
+// IsInstanceConflict treats an unreachable server as a conflict, so that a
+// failed check never lets a provision overwrite an instance.
+func (s *RemoteStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	var result remoteConflict
+	if err := s.call(http.MethodPost, "instances/"+url.PathEscape(id)+"/conflict", details, &result); err != nil {
+		s.logger.Error("failed-to-check-conflict", err, recordData(InstanceRecord, id))
+		return true
+	}
+	return result.Conflict
+}
+
+func (s *RemoteStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	var result remoteConflict
+	if err := s.call(http.MethodPost, "bindings/"+url.PathEscape(id)+"/conflict", details, &result); err != nil {
+		s.logger.Error("failed-to-check-conflict", err, recordData(BindingRecord, id))
+		return true
+	}
+	return result.Conflict
+}
+
+// Health reports the server's store as the server sees it, or the error
+// reaching the server, with the latency of the whole round trip.
+func (s *RemoteStore) Health() HealthStatus {
+	start := time.Now()
+
+	var status HealthStatus
+	if err := s.call(http.MethodGet, "health", nil, &status); err != nil {
+		s.logger.Error("failed-to-check-health", err)
+		status = HealthStatus{Error: err.Error()}
+	}
+	status.Latency = time.Since(start)
+	return status
+}
+
+func (s *RemoteStore) Ping(ctx context.Context) error {
+	return pingHealth(ctx, s.WithContext(ctx).(*RemoteStore))
+}
+
+// Restore, Save and Cleanup run on the server, logging to its logger
+func (s *RemoteStore) Restore(logger lager.Logger) error {
+	return s.call(http.MethodPost, "restore", nil, nil)
+}
+
+func (s *RemoteStore) Save(logger lager.Logger) error {
+	return s.call(http.MethodPost, "save", nil, nil)
+}
+
+func (s *RemoteStore) Cleanup() error {
+	return s.call(http.MethodPost, "cleanup", nil, nil)
+}
+
+// call sends body, if any, as JSON and decodes a successful response into
+// out, if given.
+func (s *RemoteStore) call(method, path string, body, out interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(s.ctx, method, s.baseURL+"/v1/"+path, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+s.token)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var errorBody remoteErrorBody
+		if err := json.NewDecoder(response.Body).Decode(&errorBody); err != nil {
+			errorBody.Error.Message = response.Status
+		}
+		return decodeRemoteError(response.StatusCode, errorBody)
+	}
+
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
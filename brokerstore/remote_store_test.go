@@ -0,0 +1,296 @@
+package brokerstore_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	credhubserver "code.cloudfoundry.org/credhub-cli/credhub/server"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("RemoteStore", func() {
+	var (
+		fakeStore    *brokerstorefakes.FakeStore
+		instances    map[string]ServiceInstance
+		serverLogger *lagertest.TestLogger
+		server       *httptest.Server
+		store        *RemoteStore
+		token        string
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, _ = backStoreWithMemory(fakeStore)
+		token = "broker-token"
+
+		serverLogger = lagertest.NewTestLogger("remote-store-server")
+		handler := NewRemoteStoreServer(serverLogger, fakeStore, RemoteServerOptions{
+			Tokens: map[string]string{"broker-token": "nfs-broker", "admin-token": "admin"},
+		})
+		server = httptest.NewTLSServer(handler)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		var err error
+		store, err = NewRemoteStore(lagertest.NewTestLogger("remote-store"), RemoteStoreOptions{
+			URL:    server.URL,
+			Token:  token,
+			CACert: string(caCert),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should create, retrieve, update and delete instances over TLS", func() {
+		instance := ServiceInstance{
+			ServiceID:          "service-id",
+			PlanID:             "small",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"share": "nfs://server/export"},
+		}
+		Expect(store.CreateInstanceDetails("instance-1", instance)).To(Succeed())
+		Expect(instances).To(HaveKey("instance-1"))
+
+		retrieved, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrieved).To(Equal(instance))
+
+		all, err := store.RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(all).To(HaveKey("instance-1"))
+
+		Expect(store.UpdateInstanceDetails("instance-1", domain.UpdateDetails{PlanID: "large"})).To(Succeed())
+		_, update := fakeStore.UpdateInstanceDetailsArgsForCall(0)
+		Expect(update.PlanID).To(Equal("large"))
+
+		Expect(store.IsInstanceConflict("instance-1", instance)).To(BeFalse())
+
+		Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+		Expect(instances).NotTo(HaveKey("instance-1"))
+	})
+
+	It("should create and retrieve bindings", func() {
+		binding := domain.BindDetails{AppGUID: "app-guid", PlanID: "plan-id", ServiceID: "service-id"}
+		Expect(store.CreateBindingDetails("binding-1", binding)).To(Succeed())
+
+		retrieved, err := store.RetrieveBindingDetails("binding-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrieved).To(Equal(binding))
+		Expect(store.IsBindingConflict("binding-1", binding)).To(BeFalse())
+	})
+
+	It("should run lifecycle calls on the server", func() {
+		Expect(store.Restore(nil)).To(Succeed())
+		Expect(store.Save(nil)).To(Succeed())
+		Expect(store.Cleanup()).To(Succeed())
+		Expect(fakeStore.RestoreCallCount()).To(Equal(1))
+		Expect(fakeStore.SaveCallCount()).To(Equal(1))
+		Expect(fakeStore.CleanupCallCount()).To(Equal(1))
+	})
+
+	Describe("errors", func() {
+		It("should return not-found as a credhub.NotFoundError", func() {
+			_, err := store.RetrieveInstanceDetails("missing")
+
+			var notFound *credhub.NotFoundError
+			Expect(errors.As(err, &notFound)).To(BeTrue())
+			Expect(notFound.Description).To(Equal("not found"))
+		})
+
+		It("should return typed store errors as their own types", func() {
+			fakeStore.CreateInstanceDetailsStub = nil
+			fakeStore.CreateInstanceDetailsReturns(&QuotaExceededError{Scope: QuotaOrg, Key: "org-guid", Limit: 2})
+			err := store.CreateInstanceDetails("instance-1", ServiceInstance{})
+			Expect(errors.Is(err, ErrQuotaExceeded)).To(BeTrue())
+			Expect(err).To(Equal(&QuotaExceededError{Scope: QuotaOrg, Key: "org-guid", Limit: 2}))
+
+			fakeStore.UpdateInstanceDetailsReturns(&PreviousValuesMismatchError{ID: "instance-1", Field: "plan_id", Stored: "small", Previous: "medium"})
+			err = store.UpdateInstanceDetails("instance-1", domain.UpdateDetails{})
+			Expect(err).To(Equal(&PreviousValuesMismatchError{ID: "instance-1", Field: "plan_id", Stored: "small", Previous: "medium"}))
+
+			fakeStore.DeleteBindingDetailsStub = nil
+			fakeStore.DeleteBindingDetailsReturns(&credhub_shims.UnsupportedFeatureError{Feature: "permissions-v2", ServerVersion: "1.9.0", MinVersion: "2.0.0"})
+			var unsupported *credhub_shims.UnsupportedFeatureError
+			Expect(errors.As(store.DeleteBindingDetails("binding-1"), &unsupported)).To(BeTrue())
+			Expect(unsupported.MinVersion).To(Equal("2.0.0"))
+		})
+
+		It("should return other errors as a RemoteError, logging the details on the server only", func() {
+			fakeStore.DeleteInstanceDetailsStub = nil
+			fakeStore.DeleteInstanceDetailsReturns(errors.New("credhub-down"))
+
+			err := store.DeleteInstanceDetails("instance-1")
+			Expect(err).To(Equal(&RemoteError{StatusCode: http.StatusInternalServerError, Type: "internal", Message: "internal error"}))
+			Expect(serverLogger).To(gbytes.Say("credhub-down"))
+		})
+
+		It("should treat a failed conflict check as a conflict", func() {
+			server.Close()
+			Expect(store.IsInstanceConflict("instance-1", ServiceInstance{})).To(BeTrue())
+		})
+
+		Context("when the token is not accepted", func() {
+			BeforeEach(func() {
+				token = "wrong-token"
+			})
+
+			It("should be rejected as unauthorized", func() {
+				_, err := store.RetrieveAllInstanceDetails()
+
+				var remoteErr *RemoteError
+				Expect(errors.As(err, &remoteErr)).To(BeTrue())
+				Expect(remoteErr.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(remoteErr.Type).To(Equal("unauthorized"))
+				Expect(fakeStore.RetrieveAllInstanceDetailsCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("when the served store is an AuditingStore", func() {
+		var sink *brokerstorefakes.FakeAuditSink
+
+		BeforeEach(func() {
+			server.Close()
+			sink = &brokerstorefakes.FakeAuditSink{}
+			auditing := NewAuditingStore(lagertest.NewTestLogger("auditing"), fakeStore, sink, "")
+			server = httptest.NewTLSServer(NewRemoteStoreServer(lagertest.NewTestLogger("remote-store-server"), auditing, RemoteServerOptions{
+				Tokens: map[string]string{"broker-token": "nfs-broker"},
+			}))
+		})
+
+		It("should attribute entries to the client", func() {
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+			Expect(sink.RecordCallCount()).To(Equal(1))
+			Expect(sink.RecordArgsForCall(0).Actor).To(Equal("nfs-broker"))
+		})
+	})
+
+	Context("when lifecycle calls are limited to some clients", func() {
+		BeforeEach(func() {
+			server.Close()
+			server = httptest.NewTLSServer(NewRemoteStoreServer(lagertest.NewTestLogger("remote-store-server"), fakeStore, RemoteServerOptions{
+				Tokens:           map[string]string{"broker-token": "nfs-broker", "admin-token": "admin"},
+				LifecycleClients: []string{"admin"},
+			}))
+		})
+
+		It("should refuse them from other clients", func() {
+			var remoteErr *RemoteError
+			Expect(errors.As(store.Cleanup(), &remoteErr)).To(BeTrue())
+			Expect(remoteErr.StatusCode).To(Equal(http.StatusForbidden))
+			Expect(remoteErr.Type).To(Equal("forbidden"))
+			Expect(fakeStore.CleanupCallCount()).To(Equal(0))
+
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+		})
+
+		Context("when the client is allowed", func() {
+			BeforeEach(func() {
+				token = "admin-token"
+			})
+
+			It("should run them", func() {
+				Expect(store.Cleanup()).To(Succeed())
+				Expect(fakeStore.CleanupCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("when a request body is over the limit", func() {
+		BeforeEach(func() {
+			server.Close()
+			server = httptest.NewTLSServer(NewRemoteStoreServer(lagertest.NewTestLogger("remote-store-server"), fakeStore, RemoteServerOptions{
+				Tokens:       map[string]string{"broker-token": "nfs-broker"},
+				MaxBodyBytes: 256,
+			}))
+		})
+
+		It("should refuse it without calling the store", func() {
+			err := store.CreateInstanceDetails("instance-1", ServiceInstance{ServiceFingerPrint: strings.Repeat("x", 512)})
+
+			var remoteErr *RemoteError
+			Expect(errors.As(err, &remoteErr)).To(BeTrue())
+			Expect(remoteErr.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{ServiceID: "service-id"})).To(Succeed())
+		})
+	})
+
+	Context("health", func() {
+		var fakeCredhub *credhub_fakes.FakeCredhub
+
+		BeforeEach(func() {
+			fakeCredhub = &credhub_fakes.FakeCredhub{}
+			fakeCredhub.InfoReturns(&credhubserver.Info{}, nil)
+			fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.12.0")), nil)
+
+			server.Close()
+			served := NewCredhubStore(lagertest.NewTestLogger("credhub-store"), fakeCredhub, "some-store-id")
+			server = httptest.NewTLSServer(NewRemoteStoreServer(lagertest.NewTestLogger("remote-store-server"), served, RemoteServerOptions{
+				Tokens: map[string]string{"broker-token": "nfs-broker"},
+			}))
+		})
+
+		It("should report the server's store health", func() {
+			status := store.Health()
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.ServerVersion).To(Equal("2.12.0"))
+			Expect(status.Latency).To(BeNumerically(">", 0))
+			Expect(store.Ping(context.Background())).To(Succeed())
+		})
+
+		It("should let decorators stacked on it report it healthy", func() {
+			Expect(NewQuotaStore(lagertest.NewTestLogger("quota-store"), store, QuotaLimits{}).Ping(context.Background())).To(Succeed())
+		})
+
+		Context("when the server's store is unhealthy", func() {
+			BeforeEach(func() {
+				fakeCredhub.InfoReturns(nil, errors.New("connection refused"))
+			})
+
+			It("should report it unhealthy", func() {
+				status := store.Health()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Error).To(Equal("connection refused"))
+
+				var healthErr *HealthError
+				Expect(errors.As(store.Ping(context.Background()), &healthErr)).To(BeTrue())
+			})
+		})
+
+		Context("when the server cannot be reached", func() {
+			It("should report it unhealthy", func() {
+				server.Close()
+				status := store.Health()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Error).NotTo(BeEmpty())
+			})
+		})
+	})
+
+	It("should refuse a CA certificate that is not PEM", func() {
+		_, err := NewRemoteStore(lagertest.NewTestLogger("remote-store"), RemoteStoreOptions{URL: server.URL, CACert: "not-pem"})
+		Expect(err).To(HaveOccurred())
+	})
+})
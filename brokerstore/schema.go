@@ -0,0 +1,244 @@
+package brokerstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// BaseSchemaVersion is the schema version of records written before any
+// upgrade was registered. Records stored before envelopes were introduced
+// are read as this version.
+const BaseSchemaVersion = 1
+
+// RecordEnvelope is how CredhubStore persists every instance and binding, so
+// that a record's shape can be told apart from the shape of the Go types
+// currently decoding it.
+type RecordEnvelope struct {
+	SchemaVersion int                    `json:"schema_version"`
+	Kind          RecordKind             `json:"kind"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+	Record        map[string]interface{} `json:"record"`
+}
+
+// UpgradeFunc migrates a record from the schema version it was registered
+// for to the next one.
+type UpgradeFunc func(record map[string]interface{}) (map[string]interface{}, error)
+
+// SchemaVersionError is returned for records that cannot be brought up to
+// the current schema version: either an upgrade is missing, or the record
+// was written by a newer broker.
+type SchemaVersionError struct {
+	Kind      RecordKind
+	ID        string
+	Version   int
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	if e.Version > e.Supported {
+		return fmt.Sprintf("%s %s has schema version %d, newer than the supported %d", e.Kind, e.ID, e.Version, e.Supported)
+	}
+	return fmt.Sprintf("%s %s has schema version %d and no upgrade to %d is registered", e.Kind, e.ID, e.Version, e.Supported)
+}
+
+type UpgradeReport struct {
+	Upgraded []string      `json:"upgraded"`
+	Failed   []RecordError `json:"failed"`
+}
+
+type recordSchema struct {
+	mutex    sync.RWMutex
+	upgrades map[RecordKind]map[int]UpgradeFunc
+	versions map[RecordKind]int
+}
+
+func newRecordSchema() *recordSchema {
+	return &recordSchema{
+		upgrades: map[RecordKind]map[int]UpgradeFunc{},
+		versions: map[RecordKind]int{},
+	}
+}
+
+// RegisterUpgrade registers an upgrade of kind records from version from to
+// from+1, raising the schema version records of that kind are written at.
+// Upgrades should be registered before the store is used. Older records are
+// upgraded in memory whenever they are read, and rewritten at the current
+// version by UpgradeAll or when they are next written.
+func (s *CredhubStore) RegisterUpgrade(kind RecordKind, from int, upgrade UpgradeFunc) {
+	s.schema.mutex.Lock()
+	defer s.schema.mutex.Unlock()
+
+	if s.schema.upgrades[kind] == nil {
+		s.schema.upgrades[kind] = map[int]UpgradeFunc{}
+	}
+	s.schema.upgrades[kind][from] = upgrade
+	if from+1 > s.schema.versions[kind] {
+		s.schema.versions[kind] = from + 1
+	}
+}
+
+// SchemaVersion returns the version kind records are written at
+func (s *CredhubStore) SchemaVersion(kind RecordKind) int {
+	s.schema.mutex.RLock()
+	defer s.schema.mutex.RUnlock()
+	return s.schemaVersion(kind)
+}
+
+func (s *CredhubStore) schemaVersion(kind RecordKind) int {
+	if version, ok := s.schema.versions[kind]; ok {
+		return version
+	}
+	return BaseSchemaVersion
+}
+
+// UpgradeAll rewrites every record stored below the current schema version,
+// or stored before envelopes were introduced, at the current version.
+func (s *CredhubStore) UpgradeAll() (UpgradeReport, error) {
+	logger := s.logger.Session("upgrade-all")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := UpgradeReport{Upgraded: []string{}, Failed: []RecordError{}}
+
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return report, err
+	}
+
+	for _, id := range sortedKeys(records) {
+		record := records[id]
+		switch {
+		case record.Err != nil:
+			report.Failed = append(report.Failed, RecordError{ID: id, Err: record.Err})
+			continue
+		case record.Kind == UnknownRecord:
+			continue
+		case record.Enveloped && record.StoredVersion == record.SchemaVersion:
+			continue
+		}
+
+		if err := s.writeEnvelope(id, RecordEnvelope{
+			SchemaVersion: record.SchemaVersion,
+			Kind:          record.Kind,
+			CreatedAt:     record.CreatedAt,
+			UpdatedAt:     time.Now().UTC(),
+			Record:        record.Value.Value,
+		}); err != nil {
+			logger.Error("failed-to-upgrade-record", err, recordData(record.Kind, id))
+			report.Failed = append(report.Failed, RecordError{ID: id, Err: err})
+			continue
+		}
+		logger.Info("upgraded-record", lager.Data{"kind": record.Kind, "id": id, "from": record.StoredVersion, "to": record.SchemaVersion})
+		report.Upgraded = append(report.Upgraded, id)
+	}
+
+	sort.Strings(report.Upgraded)
+	return report, nil
+}
+
+// openRecord unwraps a stored record from its envelope, if it has one, and
+// upgrades it to the current schema version. The returned record's Value
+// holds the bare record.
+func (s *CredhubStore) openRecord(id string, creds credentials.JSON) (credhubRecord, error) {
+	record := credhubRecord{
+		Kind:          recordKindOf(creds.Value),
+		Value:         creds,
+		StoredVersion: BaseSchemaVersion,
+	}
+
+	if envelope, ok := envelopeOf(creds); ok {
+		record.Kind = envelope.Kind
+		record.Value.Value = envelope.Record
+		record.Enveloped = true
+		record.StoredVersion = envelope.SchemaVersion
+		record.CreatedAt = envelope.CreatedAt
+		record.UpdatedAt = envelope.UpdatedAt
+	}
+	record.SchemaVersion = record.StoredVersion
+
+	if record.Kind == UnknownRecord {
+		return record, nil
+	}
+
+	s.schema.mutex.RLock()
+	defer s.schema.mutex.RUnlock()
+
+	current := s.schemaVersion(record.Kind)
+	for record.SchemaVersion < current {
+		upgrade, ok := s.schema.upgrades[record.Kind][record.SchemaVersion]
+		if !ok {
+			break
+		}
+
+		upgraded, err := upgrade(record.Value.Value)
+		if err != nil {
+			return credhubRecord{}, fmt.Errorf("upgrading %s %s from schema version %d: %w", record.Kind, id, record.SchemaVersion, err)
+		}
+		record.Value.Value = upgraded
+		record.SchemaVersion++
+	}
+
+	if record.SchemaVersion != current {
+		return credhubRecord{}, &SchemaVersionError{Kind: record.Kind, ID: id, Version: record.SchemaVersion, Supported: current}
+	}
+	return record, nil
+}
+
+// retrieveRecord reads and opens a single record
+func (s *CredhubStore) retrieveRecord(id string) (credhubRecord, error) {
+	creds, err := s.credhubShim.GetLatestJSON(s.namespaced(id))
+	if err != nil {
+		return credhubRecord{}, err
+	}
+	return s.openRecord(id, creds)
+}
+
+// writeRecord stores record in an envelope at the current schema version,
+// keeping the creation time of any record it replaces.
+func (s *CredhubStore) writeRecord(kind RecordKind, id string, record map[string]interface{}) error {
+	now := time.Now().UTC()
+	envelope := RecordEnvelope{
+		SchemaVersion: s.SchemaVersion(kind),
+		Kind:          kind,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Record:        record,
+	}
+
+	if existing, err := s.credhubShim.GetLatestJSON(s.namespaced(id)); err == nil {
+		if previous, ok := envelopeOf(existing); ok && !previous.CreatedAt.IsZero() {
+			envelope.CreatedAt = previous.CreatedAt
+		}
+	}
+
+	return s.writeEnvelope(id, envelope)
+}
+
+func (s *CredhubStore) writeEnvelope(id string, envelope RecordEnvelope) error {
+	mapped, err := toMap(envelope)
+	if err != nil {
+		return err
+	}
+	_, err = s.credhubShim.SetJSON(s.namespaced(id), mapped)
+	return err
+}
+
+func envelopeOf(creds credentials.JSON) (RecordEnvelope, bool) {
+	_, hasVersion := creds.Value["schema_version"]
+	_, hasRecord := creds.Value["record"].(map[string]interface{})
+	if !hasVersion || !hasRecord {
+		return RecordEnvelope{}, false
+	}
+
+	var envelope RecordEnvelope
+	if err := toStruct(creds, &envelope); err != nil {
+		return RecordEnvelope{}, false
+	}
+	return envelope, true
+}
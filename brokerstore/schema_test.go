@@ -0,0 +1,163 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema versioning", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		records     map[string]values.JSON
+		store       *CredhubStore
+	)
+
+	// renameSize moves a fingerprint field the way a broker might after
+	// changing its fingerprint type
+	renameSize := func(record map[string]interface{}) (map[string]interface{}, error) {
+		fingerPrint, _ := record["ServiceFingerPrint"].(map[string]interface{})
+		if size, ok := fingerPrint["size"]; ok {
+			fingerPrint["size_gb"] = size
+			delete(fingerPrint, "size")
+		}
+		return record, nil
+	}
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		records = map[string]values.JSON{
+			"/some-store-id/legacy-instance": {
+				"organization_guid":  "org-guid",
+				"space_guid":         "space-guid",
+				"ServiceFingerPrint": map[string]interface{}{"size": float64(10)},
+			},
+			"/some-store-id/legacy-binding": {"app_guid": "app-guid"},
+			"/some-store-id/enveloped-instance": {
+				"schema_version": float64(1),
+				"kind":           "instance",
+				"created_at":     "2026-01-01T00:00:00Z",
+				"updated_at":     "2026-02-01T00:00:00Z",
+				"record": map[string]interface{}{
+					"organization_guid":  "org-guid",
+					"ServiceFingerPrint": map[string]interface{}{"size": float64(20)},
+				},
+			},
+		}
+		backCredhubWith(fakeCredhub, records)
+		store = NewCredhubStore(lagertest.NewTestLogger("schema"), fakeCredhub, "some-store-id")
+	})
+
+	It("should read records stored with and without an envelope", func() {
+		legacy, err := store.RetrieveInstanceDetails("legacy-instance")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(legacy.OrganizationGUID).To(Equal("org-guid"))
+
+		enveloped, err := store.RetrieveInstanceDetails("enveloped-instance")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enveloped.ServiceFingerPrint).To(Equal(map[string]interface{}{"size": float64(20)}))
+
+		Expect(store.RetrieveAllInstanceDetails()).To(HaveLen(2))
+		Expect(store.RetrieveAllBindingDetails()).To(HaveKey("legacy-binding"))
+	})
+
+	It("should keep the creation time when a record is rewritten", func() {
+		Expect(store.CreateInstanceDetails("enveloped-instance", ServiceInstance{OrganizationGUID: "org-guid"})).To(Succeed())
+
+		rewritten := records["/some-store-id/enveloped-instance"]
+		Expect(rewritten["created_at"]).To(Equal("2026-01-01T00:00:00Z"))
+		Expect(rewritten["updated_at"]).NotTo(Equal("2026-02-01T00:00:00Z"))
+		Expect(rewritten["kind"]).To(Equal("instance"))
+	})
+
+	Context("with an upgrade registered", func() {
+		BeforeEach(func() {
+			store.RegisterUpgrade(InstanceRecord, 1, renameSize)
+		})
+
+		It("should raise the schema version of that kind only", func() {
+			Expect(store.SchemaVersion(InstanceRecord)).To(Equal(2))
+			Expect(store.SchemaVersion(BindingRecord)).To(Equal(BaseSchemaVersion))
+		})
+
+		It("should upgrade older records when they are read, without rewriting them", func() {
+			legacy, err := store.RetrieveInstanceDetails("legacy-instance")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy.ServiceFingerPrint).To(Equal(map[string]interface{}{"size_gb": float64(10)}))
+
+			all, err := store.RetrieveAllInstanceDetails()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all["enveloped-instance"].ServiceFingerPrint).To(Equal(map[string]interface{}{"size_gb": float64(20)}))
+			Expect(fakeCredhub.SetJSONCallCount()).To(Equal(0))
+		})
+
+		It("should write new records at the current version", func() {
+			Expect(store.CreateInstanceDetails("new-instance", ServiceInstance{OrganizationGUID: "org-guid"})).To(Succeed())
+			Expect(records["/some-store-id/new-instance"]["schema_version"]).To(BeEquivalentTo(2))
+		})
+
+		It("should upgrade every old record on UpgradeAll", func() {
+			report, err := store.UpgradeAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Upgraded).To(Equal([]string{"enveloped-instance", "legacy-binding", "legacy-instance"}))
+			Expect(report.Failed).To(BeEmpty())
+
+			upgraded := records["/some-store-id/enveloped-instance"]
+			Expect(upgraded["schema_version"]).To(BeEquivalentTo(2))
+			Expect(upgraded["created_at"]).To(Equal("2026-01-01T00:00:00Z"))
+			Expect(upgraded["record"]).To(HaveKeyWithValue("ServiceFingerPrint", map[string]interface{}{"size_gb": float64(20)}))
+
+			Expect(records["/some-store-id/legacy-binding"]["kind"]).To(Equal("binding"))
+			Expect(records["/some-store-id/legacy-binding"]["schema_version"]).To(BeEquivalentTo(BaseSchemaVersion))
+
+			report, err = store.UpgradeAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Upgraded).To(BeEmpty())
+		})
+
+		Context("when an upgrade fails", func() {
+			BeforeEach(func() {
+				store.RegisterUpgrade(InstanceRecord, 1, func(map[string]interface{}) (map[string]interface{}, error) {
+					return nil, errors.New("bad-upgrade")
+				})
+			})
+
+			It("should fail reads of that record and skip it in listings", func() {
+				_, err := store.RetrieveInstanceDetails("legacy-instance")
+				Expect(err).To(MatchError(ContainSubstring("upgrading instance legacy-instance from schema version 1: bad-upgrade")))
+				Expect(store.RetrieveAllInstanceDetails()).To(BeEmpty())
+
+				report, err := store.UpgradeAll()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Failed).To(HaveLen(2))
+				Expect(report.Upgraded).To(Equal([]string{"legacy-binding"}))
+			})
+		})
+	})
+
+	It("should refuse records it has no upgrade path for", func() {
+		store.RegisterUpgrade(InstanceRecord, 2, renameSize)
+
+		_, err := store.RetrieveInstanceDetails("legacy-instance")
+		var versionErr *SchemaVersionError
+		Expect(errors.As(err, &versionErr)).To(BeTrue())
+		Expect(versionErr.Version).To(Equal(1))
+		Expect(versionErr.Supported).To(Equal(3))
+	})
+
+	It("should refuse records written by a newer schema", func() {
+		records["/some-store-id/future-instance"] = values.JSON{
+			"schema_version": float64(5),
+			"kind":           "instance",
+			"record":         map[string]interface{}{"organization_guid": "org-guid"},
+		}
+
+		_, err := store.RetrieveInstanceDetails("future-instance")
+		Expect(err).To(MatchError("instance future-instance has schema version 5, newer than the supported 1"))
+	})
+})
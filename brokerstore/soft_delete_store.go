@@ -0,0 +1,377 @@
+package brokerstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	softDeletedAtKey     = "soft_deleted_at"
+	softDeletedMarkerKey = "tombstone"
+	softDeletedValue     = "value"
+)
+
+// Tombstone is a record that was soft deleted, with its last value
+type Tombstone struct {
+	Kind      RecordKind
+	ID        string
+	DeletedAt time.Time
+	Instance  ServiceInstance
+	Binding   domain.BindDetails
+}
+
+type SoftDeleteOptions struct {
+	// Retention is how long tombstones are kept before Purge removes them
+	Retention time.Duration
+}
+
+// SoftDeleteStore turns deletes into tombstones: the record is rewritten in
+// place with the time it was deleted, and reads treat it as not found until
+// it is undeleted or purged. The instance's ServiceFingerPrint, or the
+// binding's RawContext, is wrapped together with the deletion time.
+type SoftDeleteStore struct {
+	logger lager.Logger
+	store  Store
+	opts   SoftDeleteOptions
+}
+
+func NewSoftDeleteStore(logger lager.Logger, store Store, opts SoftDeleteOptions) *SoftDeleteStore {
+	return &SoftDeleteStore{
+		logger: logger,
+		store:  store,
+		opts:   opts,
+	}
+}
+
+func (s *SoftDeleteStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *SoftDeleteStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *SoftDeleteStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *SoftDeleteStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	details, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	if _, deleted := instanceTombstone(details); deleted {
+		return ServiceInstance{}, softDeletedError(InstanceRecord, id)
+	}
+	return details, nil
+}
+
+func (s *SoftDeleteStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	details, err := s.store.RetrieveBindingDetails(id)
+	if err != nil {
+		return domain.BindDetails{}, err
+	}
+	if _, deleted := bindingTombstone(details); deleted {
+		return domain.BindDetails{}, softDeletedError(BindingRecord, id)
+	}
+	return details, nil
+}
+
+func (s *SoftDeleteStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	stored, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]ServiceInstance{}
+	for id, details := range stored {
+		if _, deleted := instanceTombstone(details); !deleted {
+			instances[id] = details
+		}
+	}
+	return instances, nil
+}
+
+func (s *SoftDeleteStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	stored, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := map[string]domain.BindDetails{}
+	for id, details := range stored {
+		if _, deleted := bindingTombstone(details); !deleted {
+			bindings[id] = details
+		}
+	}
+	return bindings, nil
+}
+
+func (s *SoftDeleteStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	return s.store.CreateInstanceDetails(id, details)
+}
+
+func (s *SoftDeleteStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	return s.store.CreateBindingDetails(id, details)
+}
+
+func (s *SoftDeleteStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	return updateInstanceDetails(s, id, details)
+}
+
+func (s *SoftDeleteStore) DeleteInstanceDetails(id string) error {
+	logger := s.logger.Session("soft-delete-instance-details", recordData(InstanceRecord, id))
+
+	details, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		return err
+	}
+
+	details.ServiceFingerPrint = map[string]interface{}{
+		softDeletedMarkerKey: true,
+		softDeletedAtKey:     time.Now().UTC().Format(time.RFC3339Nano),
+		softDeletedValue:     details.ServiceFingerPrint,
+	}
+	if err := s.store.CreateInstanceDetails(id, details); err != nil {
+		logger.Error("failed-to-write-tombstone", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SoftDeleteStore) DeleteBindingDetails(id string) error {
+	logger := s.logger.Session("soft-delete-binding-details", recordData(BindingRecord, id))
+
+	details, err := s.RetrieveBindingDetails(id)
+	if err != nil {
+		return err
+	}
+
+	wrapped := map[string]interface{}{
+		softDeletedMarkerKey: true,
+		softDeletedAtKey:     time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if len(details.RawContext) > 0 {
+		wrapped[softDeletedValue] = details.RawContext
+	}
+	if details.RawContext, err = json.Marshal(wrapped); err != nil {
+		return err
+	}
+	if err := s.store.CreateBindingDetails(id, details); err != nil {
+		logger.Error("failed-to-write-tombstone", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SoftDeleteStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	return isInstanceConflict(s, id, details)
+}
+
+func (s *SoftDeleteStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return isBindingConflict(s, id, details)
+}
+
+func (s *SoftDeleteStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *SoftDeleteStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *SoftDeleteStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// ListDeleted returns every tombstone in the store, oldest first
+func (s *SoftDeleteStore) ListDeleted() ([]Tombstone, error) {
+	instances, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := s.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	tombstones := []Tombstone{}
+	for id, details := range instances {
+		if tombstone, deleted := instanceTombstone(details); deleted {
+			tombstone.ID = id
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	for id, details := range bindings {
+		if tombstone, deleted := bindingTombstone(details); deleted {
+			tombstone.ID = id
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+
+	sort.Slice(tombstones, func(i, j int) bool {
+		if !tombstones[i].DeletedAt.Equal(tombstones[j].DeletedAt) {
+			return tombstones[i].DeletedAt.Before(tombstones[j].DeletedAt)
+		}
+		return tombstones[i].ID < tombstones[j].ID
+	})
+	return tombstones, nil
+}
+
+// UndeleteInstance restores a soft deleted instance to its last value
+func (s *SoftDeleteStore) UndeleteInstance(id string) error {
+	details, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return err
+	}
+	tombstone, deleted := instanceTombstone(details)
+	if !deleted {
+		return &credhub.NotFoundError{Description: fmt.Sprintf("no deleted %s %s", InstanceRecord, id)}
+	}
+
+	s.logger.Info("undelete-instance-details", recordData(InstanceRecord, id))
+	return s.store.CreateInstanceDetails(id, tombstone.Instance)
+}
+
+// UndeleteBinding restores a soft deleted binding to its last value
+func (s *SoftDeleteStore) UndeleteBinding(id string) error {
+	details, err := s.store.RetrieveBindingDetails(id)
+	if err != nil {
+		return err
+	}
+	tombstone, deleted := bindingTombstone(details)
+	if !deleted {
+		return &credhub.NotFoundError{Description: fmt.Sprintf("no deleted %s %s", BindingRecord, id)}
+	}
+
+	s.logger.Info("undelete-binding-details", recordData(BindingRecord, id))
+	return s.store.CreateBindingDetails(id, tombstone.Binding)
+}
+
+// Purge permanently deletes tombstones older than the retention period and
+// returns how many were removed.
+func (s *SoftDeleteStore) Purge() (int, error) {
+	return s.PurgeBefore(time.Now().Add(-s.opts.Retention))
+}
+
+// PurgeBefore permanently deletes tombstones written before cutoff. It keeps
+// going past individual failures and returns the first error.
+func (s *SoftDeleteStore) PurgeBefore(cutoff time.Time) (int, error) {
+	logger := s.logger.Session("purge-tombstones", lager.Data{"cutoff": cutoff})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	tombstones, err := s.ListDeleted()
+	if err != nil {
+		return 0, err
+	}
+
+	var firstErr error
+	purged := 0
+	for _, tombstone := range tombstones {
+		if !tombstone.DeletedAt.Before(cutoff) {
+			continue
+		}
+
+		var err error
+		if tombstone.Kind == InstanceRecord {
+			err = s.store.DeleteInstanceDetails(tombstone.ID)
+		} else {
+			err = s.store.DeleteBindingDetails(tombstone.ID)
+		}
+		if err != nil {
+			logger.Error("failed-to-purge-tombstone", err, recordData(tombstone.Kind, tombstone.ID))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		purged++
+	}
+
+	logger.Info("purged", lager.Data{"count": purged})
+	return purged, firstErr
+}
+
+// RunPurge calls Purge every interval until ctx is done. Failures are logged
+// and retried on the next tick.
+func (s *SoftDeleteStore) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Purge(); err != nil {
+				s.logger.Error("failed-to-purge", err)
+			}
+		}
+	}
+}
+
+func instanceTombstone(details ServiceInstance) (Tombstone, bool) {
+	wrapped, _ := details.ServiceFingerPrint.(map[string]interface{})
+	deletedAt, ok := tombstoneDeletedAt(wrapped)
+	if !ok {
+		return Tombstone{}, false
+	}
+
+	details.ServiceFingerPrint = wrapped[softDeletedValue]
+	return Tombstone{Kind: InstanceRecord, DeletedAt: deletedAt, Instance: details}, true
+}
+
+func bindingTombstone(details domain.BindDetails) (Tombstone, bool) {
+	var wrapped map[string]interface{}
+	var value struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if len(details.RawContext) == 0 || json.Unmarshal(details.RawContext, &wrapped) != nil {
+		return Tombstone{}, false
+	}
+	deletedAt, ok := tombstoneDeletedAt(wrapped)
+	if !ok || json.Unmarshal(details.RawContext, &value) != nil {
+		return Tombstone{}, false
+	}
+
+	details.RawContext = value.Value
+	return Tombstone{Kind: BindingRecord, DeletedAt: deletedAt, Binding: details}, true
+}
+
+// tombstoneDeletedAt returns the deletion time of a tombstone wrapper. Only
+// the exact wrapper written by SoftDeleteStore counts, so that records which
+// merely carry a soft_deleted_at key of their own are not taken as deleted.
+func tombstoneDeletedAt(wrapped map[string]interface{}) (time.Time, bool) {
+	if wrapped[softDeletedMarkerKey] != true {
+		return time.Time{}, false
+	}
+	for key := range wrapped {
+		if key != softDeletedMarkerKey && key != softDeletedAtKey && key != softDeletedValue {
+			return time.Time{}, false
+		}
+	}
+	return parseDeletedAt(wrapped[softDeletedAtKey])
+}
+
+func parseDeletedAt(value interface{}) (time.Time, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	deletedAt, err := time.Parse(time.RFC3339Nano, text)
+	return deletedAt, err == nil
+}
+
+func softDeletedError(kind RecordKind, id string) error {
+	return &credhub.NotFoundError{Description: fmt.Sprintf("%s %s has been deleted", kind, id)}
+}
@@ -0,0 +1,151 @@
+package brokerstore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SoftDeleteStore", func() {
+	var (
+		fakeStore       *brokerstorefakes.FakeStore
+		instances       map[string]ServiceInstance
+		bindings        map[string]domain.BindDetails
+		store           *SoftDeleteStore
+		serviceInstance ServiceInstance
+		bindDetails     domain.BindDetails
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, bindings = backStoreWithMemory(fakeStore)
+
+		serviceInstance = ServiceInstance{
+			ServiceID:          "service-id",
+			PlanID:             "plan-id",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"size": float64(10)},
+		}
+		bindDetails = domain.BindDetails{
+			AppGUID:       "app-guid",
+			PlanID:        "plan-id",
+			ServiceID:     "service-id",
+			RawContext:    json.RawMessage(`{"platform":"cloudfoundry"}`),
+			RawParameters: json.RawMessage(`{"paramsHash":"some-hash"}`),
+		}
+
+		store = NewSoftDeleteStore(lagertest.NewTestLogger("soft-delete-store"), fakeStore, SoftDeleteOptions{Retention: time.Hour})
+		Expect(store.CreateInstanceDetails("instance-1", serviceInstance)).To(Succeed())
+		Expect(store.CreateBindingDetails("binding-1", bindDetails)).To(Succeed())
+	})
+
+	Context("after records are deleted", func() {
+		BeforeEach(func() {
+			Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+			Expect(store.DeleteBindingDetails("binding-1")).To(Succeed())
+		})
+
+		It("should keep tombstones in the underlying store", func() {
+			Expect(instances).To(HaveKey("instance-1"))
+			Expect(bindings).To(HaveKey("binding-1"))
+			Expect(fakeStore.DeleteInstanceDetailsCallCount()).To(Equal(0))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should report them as not found", func() {
+			var notFoundErr *credhub.NotFoundError
+
+			_, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(errors.As(err, &notFoundErr)).To(BeTrue())
+			_, err = store.RetrieveBindingDetails("binding-1")
+			Expect(errors.As(err, &notFoundErr)).To(BeTrue())
+
+			Expect(store.RetrieveAllInstanceDetails()).To(BeEmpty())
+			Expect(store.RetrieveAllBindingDetails()).To(BeEmpty())
+			Expect(store.IsInstanceConflict("instance-1", serviceInstance)).To(BeFalse())
+		})
+
+		It("should not delete them twice", func() {
+			var notFoundErr *credhub.NotFoundError
+			Expect(errors.As(store.DeleteInstanceDetails("instance-1"), &notFoundErr)).To(BeTrue())
+		})
+
+		It("should list them with their last values", func() {
+			tombstones, err := store.ListDeleted()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tombstones).To(HaveLen(2))
+
+			byID := map[string]Tombstone{}
+			for _, tombstone := range tombstones {
+				Expect(tombstone.DeletedAt).To(BeTemporally("~", time.Now(), time.Minute))
+				byID[tombstone.ID] = tombstone
+			}
+			Expect(byID["instance-1"].Kind).To(Equal(InstanceRecord))
+			Expect(byID["instance-1"].Instance).To(Equal(serviceInstance))
+			Expect(byID["binding-1"].Kind).To(Equal(BindingRecord))
+			Expect(byID["binding-1"].Binding).To(Equal(bindDetails))
+		})
+
+		It("should undelete them", func() {
+			Expect(store.UndeleteInstance("instance-1")).To(Succeed())
+			Expect(store.UndeleteBinding("binding-1")).To(Succeed())
+
+			Expect(store.RetrieveInstanceDetails("instance-1")).To(Equal(serviceInstance))
+			Expect(store.RetrieveBindingDetails("binding-1")).To(Equal(bindDetails))
+			Expect(store.ListDeleted()).To(BeEmpty())
+		})
+
+		It("should only purge tombstones older than the retention period", func() {
+			Expect(store.Purge()).To(Equal(0))
+			Expect(instances).To(HaveKey("instance-1"))
+
+			Expect(store.PurgeBefore(time.Now().Add(time.Minute))).To(Equal(2))
+			Expect(instances).To(BeEmpty())
+			Expect(bindings).To(BeEmpty())
+		})
+
+		Context("when purging a tombstone fails", func() {
+			BeforeEach(func() {
+				fakeStore.DeleteInstanceDetailsReturns(errors.New("bad-delete"))
+			})
+
+			It("should purge the rest and return the error", func() {
+				purged, err := store.PurgeBefore(time.Now().Add(time.Minute))
+				Expect(err).To(MatchError("bad-delete"))
+				Expect(purged).To(Equal(1))
+				Expect(bindings).To(BeEmpty())
+			})
+		})
+	})
+
+	It("should refuse to undelete a live record", func() {
+		var notFoundErr *credhub.NotFoundError
+		Expect(errors.As(store.UndeleteInstance("instance-1"), &notFoundErr)).To(BeTrue())
+	})
+
+	It("should not take records carrying a soft_deleted_at key of their own as deleted", func() {
+		serviceInstance.ServiceFingerPrint = map[string]interface{}{"soft_deleted_at": time.Now().UTC().Format(time.RFC3339Nano)}
+		Expect(store.CreateInstanceDetails("instance-2", serviceInstance)).To(Succeed())
+		bindDetails.RawContext = json.RawMessage(`{"soft_deleted_at":"2024-01-01T00:00:00Z","value":{}}`)
+		Expect(store.CreateBindingDetails("binding-2", bindDetails)).To(Succeed())
+
+		Expect(store.RetrieveInstanceDetails("instance-2")).To(Equal(serviceInstance))
+		Expect(store.RetrieveBindingDetails("binding-2")).To(Equal(bindDetails))
+		Expect(store.ListDeleted()).To(BeEmpty())
+	})
+
+	It("should let a deleted record be recreated", func() {
+		Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+		Expect(store.CreateInstanceDetails("instance-1", serviceInstance)).To(Succeed())
+		Expect(store.RetrieveInstanceDetails("instance-1")).To(Equal(serviceInstance))
+	})
+})
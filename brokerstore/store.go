@@ -1,11 +1,13 @@
 package brokerstore
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"reflect"
 
 	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
 	"golang.org/x/crypto/bcrypt"
@@ -17,8 +19,25 @@ type ServiceInstance struct {
 	OrganizationGUID   string `json:"organization_guid"`
 	SpaceGUID          string `json:"space_guid"`
 	ServiceFingerPrint interface{}
+
+	MaintenanceInfo *domain.MaintenanceInfo `json:"maintenance_info,omitempty"`
+	// SharedSpaceGUIDs are the spaces, other than SpaceGUID, the instance has
+	// been shared into; see ShareInstance.
+	SharedSpaceGUIDs []string `json:"shared_space_guids,omitempty"`
+	// History holds the most recent updates, shares and unshares, oldest
+	// first.
+	History []InstanceChange `json:"history,omitempty"`
 }
 
+// ErrNotFound is what a Store returns, possibly wrapped, for a record that
+// does not exist. A *credhub.NotFoundError means the same.
+var ErrNotFound = errors.New("record not found")
+
+// Store persists service instances and bindings. Retrieving a record that
+// does not exist must return an error matching ErrNotFound, or a
+// *credhub.NotFoundError: conflict checks and updates take any other error
+// to mean the record's state is unknown, and refuse to go ahead.
+//
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 //counterfeiter:generate -o ./brokerstorefakes/fake_store.go . Store
 type Store interface {
@@ -31,6 +50,8 @@ type Store interface {
 	CreateInstanceDetails(id string, details ServiceInstance) error
 	CreateBindingDetails(id string, details domain.BindDetails) error
 
+	UpdateInstanceDetails(id string, details domain.UpdateDetails) error
+
 	DeleteInstanceDetails(id string) error
 	DeleteBindingDetails(id string) error
 
@@ -42,6 +63,21 @@ type Store interface {
 	Cleanup() error
 }
 
+// ContextualStore is implemented by stores that can carry a context down to
+// the backend, e.g. to parent tracing spans. Decorators pass the context on
+// to the store they wrap.
+type ContextualStore interface {
+	WithContext(ctx context.Context) Store
+}
+
+// storeWithContext binds ctx to store if it supports contexts
+func storeWithContext(store Store, ctx context.Context) Store {
+	if contextual, ok := store.(ContextualStore); ok {
+		return contextual.WithContext(ctx)
+	}
+	return store
+}
+
 func NewStore(
 	logger lager.Logger,
 	credhubURL,
@@ -56,24 +92,63 @@ func NewStore(
 		if err != nil {
 			logger.Fatal("failed-creating-credhub-store", err)
 		}
-		return NewCredhubStore(logger, ch, storeID)
+		store := NewCredhubStore(logger, ch, storeID)
+		if _, err := store.Capabilities(); err != nil {
+			logger.Error("failed-detecting-credhub-capabilities", err)
+		}
+		return store
 	}
 	logger.Fatal("failed-creating-broker-store", errors.New("invalid brokerstore configuration"))
 	return nil
 }
 
+// isNotFound reports whether err means the record does not exist
+func isNotFound(err error) bool {
+	var notFoundErr *credhub.NotFoundError
+	return errors.Is(err, ErrNotFound) || errors.As(err, &notFoundErr)
+}
+
+// RecordKind identifies whether a stored record holds instance or binding details
+type RecordKind string
+
+const (
+	InstanceRecord RecordKind = "instance"
+	BindingRecord  RecordKind = "binding"
+	UnknownRecord  RecordKind = "unknown"
+)
+
+// recordKindOf classifies a serialized record by the keys that only one of
+// ServiceInstance or domain.BindDetails ever writes.
+func recordKindOf(record map[string]interface{}) RecordKind {
+	_, hasOrg := record["organization_guid"]
+	_, hasApp := record["app_guid"]
+
+	switch {
+	case hasOrg && !hasApp:
+		return InstanceRecord
+	case hasApp && !hasOrg:
+		return BindingRecord
+	default:
+		return UnknownRecord
+	}
+}
+
 // Utility methods for storing bindings with secrets stripped out
 const HashKey = "paramsHash"
 
+// isInstanceConflict treats an instance that cannot be compared as a
+// conflict, so that a failing read never lets a provision overwrite it.
 func isInstanceConflict(s Store, id string, details ServiceInstance) bool {
-	if existing, err := s.RetrieveInstanceDetails(id); err == nil {
-		if !reflect.DeepEqual(details, existing) {
-			return true
-		}
+	switch CheckInstanceConflict(s, id, details).Status {
+	case ConflictDetected, ConflictUnknown:
+		return true
+	default:
+		return false
 	}
-	return false
 }
 
+// isBindingConflict leaves RawContext out of the comparison: stores and
+// WithBindingInstance add to it, so it differs from what the platform resends.
 func isBindingConflict(s Store, id string, details domain.BindDetails) bool {
 	if existing, err := s.RetrieveBindingDetails(id); err == nil {
 		if existing.AppGUID != details.AppGUID {
@@ -0,0 +1,139 @@
+// Package tracing is the small tracing abstraction used by the broker store
+// and the CredHub shim. It is deliberately narrow so that brokers can adapt
+// an OpenTelemetry tracer to it without this module depending on one.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type Tracer interface {
+	// Start begins a span as a child of any span carried by ctx, and returns
+	// a context carrying the new span.
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	// End finishes the span, recording err as its status if it is not nil
+	End(err error)
+}
+
+// NoopTracer starts spans that record nothing
+type NoopTracer struct{}
+
+func (NoopTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) End(error)                  {}
+
+type FinishedSpan struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Attributes map[string]string
+	Err        error
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// MemoryTracer keeps finished spans in memory, intended for tests
+type MemoryTracer struct {
+	mutex sync.Mutex
+	spans []FinishedSpan
+}
+
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+type spanContextKey struct{}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	span := &memorySpan{
+		tracer: t,
+		finished: FinishedSpan{
+			Name:       name,
+			SpanID:     newID(8),
+			Attributes: map[string]string{},
+			StartTime:  time.Now(),
+		},
+	}
+
+	if parent, ok := ctx.Value(spanContextKey{}).(*memorySpan); ok {
+		span.finished.TraceID = parent.finished.TraceID
+		span.finished.ParentID = parent.finished.SpanID
+	} else {
+		span.finished.TraceID = newID(16)
+	}
+	span.SetAttributes(attributes...)
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// Spans returns the spans finished so far, in the order they ended
+func (t *MemoryTracer) Spans() []FinishedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]FinishedSpan(nil), t.spans...)
+}
+
+func (t *MemoryTracer) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.spans = nil
+}
+
+type memorySpan struct {
+	tracer   *MemoryTracer
+	mutex    sync.Mutex
+	finished FinishedSpan
+}
+
+func (s *memorySpan) SetAttributes(attributes ...Attribute) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, attribute := range attributes {
+		s.finished.Attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (s *memorySpan) End(err error) {
+	s.mutex.Lock()
+	s.finished.Err = err
+	s.finished.EndTime = time.Now()
+	finished := s.finished
+	finished.Attributes = make(map[string]string, len(s.finished.Attributes))
+	for key, value := range s.finished.Attributes {
+		finished.Attributes[key] = value
+	}
+	s.mutex.Unlock()
+
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.tracer.spans = append(s.tracer.spans, finished)
+}
+
+func newID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
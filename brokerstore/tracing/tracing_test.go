@@ -0,0 +1,61 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/service-broker-store/brokerstore/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemoryTracer", func() {
+	var tracer *tracing.MemoryTracer
+
+	BeforeEach(func() {
+		tracer = tracing.NewMemoryTracer()
+	})
+
+	It("should record finished spans with their attributes and error", func() {
+		_, span := tracer.Start(context.Background(), "some-span", tracing.String("kind", "instance"))
+		span.SetAttributes(tracing.String("outcome", "error"))
+		span.End(errors.New("boom"))
+
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("some-span"))
+		Expect(spans[0].Attributes).To(Equal(map[string]string{"kind": "instance", "outcome": "error"}))
+		Expect(spans[0].Err).To(MatchError("boom"))
+		Expect(spans[0].ParentID).To(BeEmpty())
+	})
+
+	It("should parent spans started from a span's context", func() {
+		ctx, parent := tracer.Start(context.Background(), "parent")
+		_, child := tracer.Start(ctx, "child")
+		child.End(nil)
+		parent.End(nil)
+
+		spans := tracer.Spans()
+		Expect(spans[0].Name).To(Equal("child"))
+		Expect(spans[0].TraceID).To(Equal(spans[1].TraceID))
+		Expect(spans[0].ParentID).To(Equal(spans[1].SpanID))
+	})
+
+	It("should forget spans on Reset", func() {
+		_, span := tracer.Start(context.Background(), "some-span")
+		span.End(nil)
+		tracer.Reset()
+		Expect(tracer.Spans()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("NoopTracer", func() {
+	It("should return the context unchanged", func() {
+		ctx := context.WithValue(context.Background(), contextKey{}, "value")
+		returned, span := tracing.NoopTracer{}.Start(ctx, "some-span")
+		span.End(nil)
+		Expect(returned).To(Equal(ctx))
+	})
+})
+
+type contextKey struct{}
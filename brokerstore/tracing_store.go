@@ -0,0 +1,153 @@
+package brokerstore
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/tracing"
+)
+
+// TracingStore starts a span around every call to the wrapped store, carrying
+// the record kind, ID and outcome. Spans are parented to the span in the
+// context given to WithContext, and the span's own context is passed on to
+// the wrapped store so that backend spans nest beneath it.
+type TracingStore struct {
+	store  Store
+	tracer tracing.Tracer
+	ctx    context.Context
+}
+
+func NewTracingStore(store Store, tracer tracing.Tracer) *TracingStore {
+	return &TracingStore{
+		store:  store,
+		tracer: tracer,
+		ctx:    context.Background(),
+	}
+}
+
+func (s *TracingStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.ctx = ctx
+	return &withContext
+}
+
+func (s *TracingStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *TracingStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *TracingStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	store, span := s.start("RetrieveInstanceDetails", InstanceRecord, id)
+	details, err := store.RetrieveInstanceDetails(id)
+	endStoreSpan(span, err)
+	return details, err
+}
+
+func (s *TracingStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	store, span := s.start("RetrieveBindingDetails", BindingRecord, id)
+	details, err := store.RetrieveBindingDetails(id)
+	endStoreSpan(span, err)
+	return details, err
+}
+
+func (s *TracingStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	store, span := s.start("RetrieveAllInstanceDetails", InstanceRecord, "")
+	instances, err := store.RetrieveAllInstanceDetails()
+	endStoreSpan(span, err)
+	return instances, err
+}
+
+func (s *TracingStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	store, span := s.start("RetrieveAllBindingDetails", BindingRecord, "")
+	bindings, err := store.RetrieveAllBindingDetails()
+	endStoreSpan(span, err)
+	return bindings, err
+}
+
+func (s *TracingStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	store, span := s.start("CreateInstanceDetails", InstanceRecord, id)
+	err := store.CreateInstanceDetails(id, details)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	store, span := s.start("CreateBindingDetails", BindingRecord, id)
+	err := store.CreateBindingDetails(id, details)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	store, span := s.start("UpdateInstanceDetails", InstanceRecord, id)
+	err := store.UpdateInstanceDetails(id, details)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) DeleteInstanceDetails(id string) error {
+	store, span := s.start("DeleteInstanceDetails", InstanceRecord, id)
+	err := store.DeleteInstanceDetails(id)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) DeleteBindingDetails(id string) error {
+	store, span := s.start("DeleteBindingDetails", BindingRecord, id)
+	err := store.DeleteBindingDetails(id)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *TracingStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	store, span := s.start("IsInstanceConflict", InstanceRecord, id)
+	conflict := store.IsInstanceConflict(id, details)
+	span.SetAttributes(tracing.String("outcome", string(conflictOutcome(conflict))))
+	span.End(nil)
+	return conflict
+}
+
+func (s *TracingStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	store, span := s.start("IsBindingConflict", BindingRecord, id)
+	conflict := store.IsBindingConflict(id, details)
+	span.SetAttributes(tracing.String("outcome", string(conflictOutcome(conflict))))
+	span.End(nil)
+	return conflict
+}
+
+func (s *TracingStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *TracingStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *TracingStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// start begins a span for operation and returns the wrapped store bound to
+// the span's context
+func (s *TracingStore) start(operation string, kind RecordKind, id string) (Store, tracing.Span) {
+	attributes := []tracing.Attribute{tracing.String("record.kind", string(kind))}
+	if id != "" {
+		attributes = append(attributes, tracing.String("record.id", id))
+	}
+
+	ctx, span := s.tracer.Start(s.ctx, "brokerstore."+operation, attributes...)
+	return storeWithContext(s.store, ctx), span
+}
+
+func endStoreSpan(span tracing.Span, err error) {
+	if err != nil {
+		span.SetAttributes(tracing.String("outcome", "error"), tracing.String("error.class", ErrorClass(err)))
+	} else {
+		span.SetAttributes(tracing.String("outcome", "success"))
+	}
+	span.End(err)
+}
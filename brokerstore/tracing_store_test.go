@@ -0,0 +1,84 @@
+package brokerstore_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TracingStore", func() {
+	var (
+		tracer      *tracing.MemoryTracer
+		fakeCredhub *credhub_fakes.FakeCredhub
+		store       Store
+	)
+
+	BeforeEach(func() {
+		tracer = tracing.NewMemoryTracer()
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		credhubStore := NewCredhubStore(
+			lagertest.NewTestLogger("tracing-store"),
+			credhub_shims.NewTracingCredhub(fakeCredhub, tracer),
+			"some-store-id",
+		)
+		store = NewTracingStore(credhubStore, tracer)
+	})
+
+	It("should nest CredHub spans beneath the store span", func() {
+		ctx, root := tracer.Start(context.Background(), "bind")
+		Expect(store.(ContextualStore).WithContext(ctx).CreateBindingDetails("binding-1", domain.BindDetails{AppGUID: "app-guid"})).To(Succeed())
+		root.End(nil)
+
+		// The store reads the record first to keep its creation time
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(4))
+		Expect(spans[0].Name).To(Equal("credhub.GetLatestJSON"))
+		credhubSpan, storeSpan, rootSpan := spans[1], spans[2], spans[3]
+
+		Expect(credhubSpan.Name).To(Equal("credhub.SetJSON"))
+		Expect(credhubSpan.Attributes).To(HaveKeyWithValue("credhub.name", "/some-store-id/binding-1"))
+		Expect(credhubSpan.ParentID).To(Equal(storeSpan.SpanID))
+
+		Expect(storeSpan.Name).To(Equal("brokerstore.CreateBindingDetails"))
+		Expect(storeSpan.Attributes).To(Equal(map[string]string{
+			"record.kind": "binding",
+			"record.id":   "binding-1",
+			"outcome":     "success",
+		}))
+		Expect(storeSpan.ParentID).To(Equal(rootSpan.SpanID))
+		Expect(storeSpan.TraceID).To(Equal(rootSpan.TraceID))
+	})
+
+	It("should record failures on both spans", func() {
+		fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, errors.New("bad-get-latest-json"))
+
+		_, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).To(HaveOccurred())
+
+		spans := tracer.Spans()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Attributes).To(HaveKeyWithValue("outcome", "error"))
+		Expect(spans[1].Err).To(MatchError("bad-get-latest-json"))
+		Expect(spans[1].Attributes).To(HaveKeyWithValue("error.class", "other"))
+	})
+
+	It("should record conflict decisions as the outcome", func() {
+		fakeCredhub.GetLatestJSONReturns(credentials.JSON{}, &credhub.NotFoundError{})
+
+		Expect(store.IsInstanceConflict("instance-1", ServiceInstance{})).To(BeFalse())
+
+		spans := tracer.Spans()
+		Expect(spans[len(spans)-1].Name).To(Equal("brokerstore.IsInstanceConflict"))
+		Expect(spans[len(spans)-1].Attributes).To(HaveKeyWithValue("outcome", "no-conflict"))
+	})
+})
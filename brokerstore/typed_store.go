@@ -0,0 +1,210 @@
+package brokerstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// TypedInstance is a ServiceInstance whose fingerprint is a broker-specific
+// Go type rather than whatever the backend decoded it as.
+type TypedInstance[F any] struct {
+	ServiceID          string
+	PlanID             string
+	OrganizationGUID   string
+	SpaceGUID          string
+	ServiceFingerPrint F
+	MaintenanceInfo    *domain.MaintenanceInfo
+	SharedSpaceGUIDs   []string
+	History            []InstanceChange
+}
+
+// Untyped returns the instance as stored by a Store
+func (i TypedInstance[F]) Untyped() ServiceInstance {
+	return ServiceInstance{
+		ServiceID:          i.ServiceID,
+		PlanID:             i.PlanID,
+		OrganizationGUID:   i.OrganizationGUID,
+		SpaceGUID:          i.SpaceGUID,
+		ServiceFingerPrint: i.ServiceFingerPrint,
+		MaintenanceInfo:    i.MaintenanceInfo,
+		SharedSpaceGUIDs:   i.SharedSpaceGUIDs,
+		History:            i.History,
+	}
+}
+
+// Equaler lets a fingerprint type define its own equality for conflict checks
+type Equaler[F any] interface {
+	Equal(other F) bool
+}
+
+// TypedStore wraps a Store so that instance fingerprints round-trip as F.
+// Fingerprints are converted through their JSON encoding, the same one every
+// backend stores, so F's json tags decide the stored shape. Bindings pass
+// straight through.
+type TypedStore[F any] struct {
+	logger lager.Logger
+	store  Store
+}
+
+func NewTypedStore[F any](logger lager.Logger, store Store) *TypedStore[F] {
+	return &TypedStore[F]{
+		logger: logger,
+		store:  store,
+	}
+}
+
+// Untyped returns the wrapped store
+func (s *TypedStore[F]) Untyped() Store {
+	return s.store
+}
+
+func (s *TypedStore[F]) WithContext(ctx context.Context) *TypedStore[F] {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *TypedStore[F]) RetrieveInstanceDetails(id string) (TypedInstance[F], error) {
+	details, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		return TypedInstance[F]{}, err
+	}
+	return typedInstance[F](id, details)
+}
+
+// RetrieveAllInstanceDetails skips, and logs, instances whose fingerprint
+// does not decode as F.
+func (s *TypedStore[F]) RetrieveAllInstanceDetails() (map[string]TypedInstance[F], error) {
+	stored, err := s.store.RetrieveAllInstanceDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]TypedInstance[F]{}
+	for id, details := range stored {
+		instance, err := typedInstance[F](id, details)
+		if err != nil {
+			s.logger.Error("failed-to-decode-fingerprint", err, recordData(InstanceRecord, id))
+			continue
+		}
+		instances[id] = instance
+	}
+	return instances, nil
+}
+
+func (s *TypedStore[F]) CreateInstanceDetails(id string, details TypedInstance[F]) error {
+	return s.store.CreateInstanceDetails(id, details.Untyped())
+}
+
+func (s *TypedStore[F]) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	return s.store.UpdateInstanceDetails(id, details)
+}
+
+func (s *TypedStore[F]) DeleteInstanceDetails(id string) error {
+	return s.store.DeleteInstanceDetails(id)
+}
+
+// IsInstanceConflict compares fingerprints as F, so that e.g. a stored
+// float64 and a requested int of the same value do not conflict. Fingerprint
+// types implementing Equaler decide equality themselves. As with
+// CheckInstanceConflict, only a missing instance is no conflict: one that
+// cannot be read or decoded is treated as conflicting.
+func (s *TypedStore[F]) IsInstanceConflict(id string, details TypedInstance[F]) bool {
+	existing, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		if isNotFound(err) {
+			return false
+		}
+		s.logger.Error("failed-to-check-conflict", err, recordData(InstanceRecord, id))
+		return true
+	}
+
+	if existing.ServiceID != details.ServiceID ||
+		existing.PlanID != details.PlanID ||
+		existing.OrganizationGUID != details.OrganizationGUID ||
+		existing.SpaceGUID != details.SpaceGUID ||
+		!maintenanceInfoEqual(existing.MaintenanceInfo, details.MaintenanceInfo) {
+		return true
+	}
+
+	if equaler, ok := any(existing.ServiceFingerPrint).(Equaler[F]); ok {
+		return !equaler.Equal(details.ServiceFingerPrint)
+	}
+
+	// Normalise the requested fingerprint the same way the stored one was, so
+	// that only differences visible in storage count
+	requested, err := typedFingerPrint[F](details.ServiceFingerPrint)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(existing.ServiceFingerPrint, requested)
+}
+
+func (s *TypedStore[F]) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	return s.store.RetrieveBindingDetails(id)
+}
+
+func (s *TypedStore[F]) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	return s.store.RetrieveAllBindingDetails()
+}
+
+func (s *TypedStore[F]) CreateBindingDetails(id string, details domain.BindDetails) error {
+	return s.store.CreateBindingDetails(id, details)
+}
+
+func (s *TypedStore[F]) DeleteBindingDetails(id string) error {
+	return s.store.DeleteBindingDetails(id)
+}
+
+func (s *TypedStore[F]) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return s.store.IsBindingConflict(id, details)
+}
+
+func (s *TypedStore[F]) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *TypedStore[F]) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *TypedStore[F]) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+func typedInstance[F any](id string, details ServiceInstance) (TypedInstance[F], error) {
+	fingerPrint, err := typedFingerPrint[F](details.ServiceFingerPrint)
+	if err != nil {
+		return TypedInstance[F]{}, fmt.Errorf("decoding fingerprint of %s %s: %w", InstanceRecord, id, err)
+	}
+
+	return TypedInstance[F]{
+		ServiceID:          details.ServiceID,
+		PlanID:             details.PlanID,
+		OrganizationGUID:   details.OrganizationGUID,
+		SpaceGUID:          details.SpaceGUID,
+		ServiceFingerPrint: fingerPrint,
+		MaintenanceInfo:    details.MaintenanceInfo,
+		SharedSpaceGUIDs:   details.SharedSpaceGUIDs,
+		History:            details.History,
+	}, nil
+}
+
+func typedFingerPrint[F any](fingerPrint interface{}) (F, error) {
+	var typed F
+	if fingerPrint == nil {
+		return typed, nil
+	}
+
+	encoded, err := json.Marshal(fingerPrint)
+	if err != nil {
+		return typed, err
+	}
+	err = json.Unmarshal(encoded, &typed)
+	return typed, err
+}
@@ -0,0 +1,131 @@
+package brokerstore_test
+
+import (
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type nfsFingerPrint struct {
+	Share   string   `json:"share"`
+	Version int      `json:"version"`
+	Options []string `json:"options,omitempty"`
+}
+
+type caseInsensitiveFingerPrint struct {
+	Name string `json:"name"`
+}
+
+func (f caseInsensitiveFingerPrint) Equal(other caseInsensitiveFingerPrint) bool {
+	return strings.EqualFold(f.Name, other.Name)
+}
+
+var _ = Describe("TypedStore", func() {
+	var (
+		fakeStore *brokerstorefakes.FakeStore
+		instances map[string]ServiceInstance
+		store     *TypedStore[nfsFingerPrint]
+		instance  TypedInstance[nfsFingerPrint]
+	)
+
+	BeforeEach(func() {
+		fakeStore = &brokerstorefakes.FakeStore{}
+		instances, _ = backStoreWithMemory(fakeStore)
+		store = NewTypedStore[nfsFingerPrint](lagertest.NewTestLogger("typed-store"), fakeStore)
+
+		instance = TypedInstance[nfsFingerPrint]{
+			ServiceID:          "service-id",
+			PlanID:             "plan-id",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: nfsFingerPrint{Share: "nfs://server/export", Version: 4},
+		}
+		Expect(store.CreateInstanceDetails("instance-1", instance)).To(Succeed())
+	})
+
+	It("should store the fingerprint in its JSON shape", func() {
+		Expect(instances["instance-1"].ServiceFingerPrint).To(Equal(map[string]interface{}{
+			"share":   "nfs://server/export",
+			"version": float64(4),
+		}))
+	})
+
+	It("should round trip the fingerprint as its concrete type", func() {
+		retrieved, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrieved).To(Equal(instance))
+
+		all, err := store.RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(all).To(Equal(map[string]TypedInstance[nfsFingerPrint]{"instance-1": instance}))
+	})
+
+	It("should skip instances whose fingerprint does not decode", func() {
+		Expect(fakeStore.CreateInstanceDetails("instance-2", ServiceInstance{ServiceFingerPrint: "not-an-object"})).To(Succeed())
+
+		_, err := store.RetrieveInstanceDetails("instance-2")
+		Expect(err).To(MatchError(ContainSubstring("decoding fingerprint of instance instance-2")))
+
+		all, err := store.RetrieveAllInstanceDetails()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(all).To(HaveLen(1))
+	})
+
+	Context("#IsInstanceConflict", func() {
+		It("should not conflict with the same fingerprint despite number types", func() {
+			Expect(store.IsInstanceConflict("instance-1", instance)).To(BeFalse())
+
+			untyped := NewTypedStore[map[string]interface{}](lagertest.NewTestLogger("typed-store"), fakeStore)
+			Expect(untyped.IsInstanceConflict("instance-1", TypedInstance[map[string]interface{}]{
+				ServiceID:          "service-id",
+				PlanID:             "plan-id",
+				OrganizationGUID:   "org-guid",
+				SpaceGUID:          "space-guid",
+				ServiceFingerPrint: map[string]interface{}{"share": "nfs://server/export", "version": 4},
+			})).To(BeFalse())
+		})
+
+		It("should not conflict where only an omitted empty value differs", func() {
+			instance.ServiceFingerPrint.Options = []string{}
+			Expect(store.IsInstanceConflict("instance-1", instance)).To(BeFalse())
+		})
+
+		It("should conflict on a different fingerprint", func() {
+			instance.ServiceFingerPrint.Version = 3
+			Expect(store.IsInstanceConflict("instance-1", instance)).To(BeTrue())
+		})
+
+		It("should conflict on a different plan", func() {
+			instance.PlanID = "other-plan"
+			Expect(store.IsInstanceConflict("instance-1", instance)).To(BeTrue())
+		})
+
+		It("should not conflict with an instance that does not exist", func() {
+			Expect(store.IsInstanceConflict("instance-2", instance)).To(BeFalse())
+		})
+
+		It("should conflict with an instance that cannot be read", func() {
+			fakeStore.RetrieveInstanceDetailsStub = nil
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, errors.New("credhub-down"))
+			Expect(store.IsInstanceConflict("instance-1", instance)).To(BeTrue())
+		})
+
+		It("should conflict with an instance whose fingerprint cannot be decoded", func() {
+			Expect(fakeStore.CreateInstanceDetails("instance-3", ServiceInstance{ServiceFingerPrint: "not-an-object"})).To(Succeed())
+			Expect(store.IsInstanceConflict("instance-3", instance)).To(BeTrue())
+		})
+
+		It("should use the fingerprint's own equality when it has one", func() {
+			named := NewTypedStore[caseInsensitiveFingerPrint](lagertest.NewTestLogger("typed-store"), fakeStore)
+			Expect(named.CreateInstanceDetails("instance-2", TypedInstance[caseInsensitiveFingerPrint]{ServiceFingerPrint: caseInsensitiveFingerPrint{Name: "Share"}})).To(Succeed())
+
+			Expect(named.IsInstanceConflict("instance-2", TypedInstance[caseInsensitiveFingerPrint]{ServiceFingerPrint: caseInsensitiveFingerPrint{Name: "SHARE"}})).To(BeFalse())
+			Expect(named.IsInstanceConflict("instance-2", TypedInstance[caseInsensitiveFingerPrint]{ServiceFingerPrint: caseInsensitiveFingerPrint{Name: "other"}})).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,151 @@
+package brokerstore
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+)
+
+// MaxInstanceHistory is how many changes are kept on an instance; older
+// changes are dropped first.
+const MaxInstanceHistory = 10
+
+// InstanceChange is one update of an instance's plan or maintenance info, or
+// one share or unshare of it. Fields that were not changed are left empty.
+type InstanceChange struct {
+	ChangedAt               time.Time               `json:"changed_at"`
+	PreviousPlanID          string                  `json:"previous_plan_id,omitempty"`
+	PlanID                  string                  `json:"plan_id,omitempty"`
+	PreviousMaintenanceInfo *domain.MaintenanceInfo `json:"previous_maintenance_info,omitempty"`
+	MaintenanceInfo         *domain.MaintenanceInfo `json:"maintenance_info,omitempty"`
+	SharedSpaceGUID         string                  `json:"shared_space_guid,omitempty"`
+	UnsharedSpaceGUID       string                  `json:"unshared_space_guid,omitempty"`
+}
+
+// PreviousValuesMismatchError is returned when an update's previous values,
+// or its service ID, do not match the stored instance: the platform's view
+// of the instance is out of date.
+type PreviousValuesMismatchError struct {
+	ID       string
+	Field    string
+	Stored   interface{}
+	Previous interface{}
+}
+
+func (e *PreviousValuesMismatchError) Error() string {
+	return fmt.Sprintf("%s %s has %s %v, but the update expected %v", InstanceRecord, e.ID, e.Field, e.Stored, e.Previous)
+}
+
+// updateInstanceDetails applies an update through s's own reads and writes,
+// so that decorators wrapping the stored record see it as any other create.
+// Only the plan and maintenance info are taken from the update; brokers that
+// keep parameters in the fingerprint store it themselves afterwards. A
+// retried update that was already applied succeeds without another change.
+func updateInstanceDetails(s Store, id string, details domain.UpdateDetails) error {
+	existing, err := s.RetrieveInstanceDetails(id)
+	if err != nil {
+		return err
+	}
+	if err := checkPreviousValues(id, existing, details); err != nil {
+		if updateAlreadyApplied(id, existing, details) {
+			return nil
+		}
+		return err
+	}
+
+	change := InstanceChange{ChangedAt: time.Now().UTC()}
+	changed := false
+	if details.PlanID != "" && details.PlanID != existing.PlanID {
+		change.PreviousPlanID = existing.PlanID
+		change.PlanID = details.PlanID
+		existing.PlanID = details.PlanID
+		changed = true
+	}
+	if details.MaintenanceInfo != nil && !maintenanceInfoEqual(existing.MaintenanceInfo, details.MaintenanceInfo) {
+		change.PreviousMaintenanceInfo = existing.MaintenanceInfo
+		change.MaintenanceInfo = details.MaintenanceInfo
+		existing.MaintenanceInfo = details.MaintenanceInfo
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	existing.recordChange(change)
+	return s.CreateInstanceDetails(id, existing)
+}
+
+func (si *ServiceInstance) recordChange(change InstanceChange) {
+	si.History = append(si.History, change)
+	if len(si.History) > MaxInstanceHistory {
+		si.History = si.History[len(si.History)-MaxInstanceHistory:]
+	}
+}
+
+// checkPreviousValues compares every previous value the platform sent with
+// the stored instance. Maintenance info is only compared when the instance
+// has some, as instances stored before it was tracked do not.
+func checkPreviousValues(id string, existing ServiceInstance, details domain.UpdateDetails) error {
+	previous := details.PreviousValues
+	mismatch := func(field string, stored, expected interface{}) error {
+		return &PreviousValuesMismatchError{ID: id, Field: field, Stored: stored, Previous: expected}
+	}
+
+	switch {
+	case details.ServiceID != "" && details.ServiceID != existing.ServiceID:
+		return mismatch("service_id", existing.ServiceID, details.ServiceID)
+	case previous.ServiceID != "" && previous.ServiceID != existing.ServiceID:
+		return mismatch("service_id", existing.ServiceID, previous.ServiceID)
+	case previous.PlanID != "" && previous.PlanID != existing.PlanID:
+		return mismatch("plan_id", existing.PlanID, previous.PlanID)
+	case previous.OrgID != "" && previous.OrgID != existing.OrganizationGUID:
+		return mismatch("organization_guid", existing.OrganizationGUID, previous.OrgID)
+	case previous.SpaceID != "" && previous.SpaceID != existing.SpaceGUID:
+		return mismatch("space_guid", existing.SpaceGUID, previous.SpaceID)
+	case previous.MaintenanceInfo != nil && existing.MaintenanceInfo != nil &&
+		!maintenanceInfoEqual(existing.MaintenanceInfo, previous.MaintenanceInfo):
+		return mismatch("maintenance_info", existing.MaintenanceInfo.Version, previous.MaintenanceInfo.Version)
+	}
+	return nil
+}
+
+// updateAlreadyApplied reports whether the last change recorded on existing
+// is this update: undoing it gives an instance the update's previous values
+// match, and redoing the update from there gives existing.
+func updateAlreadyApplied(id string, existing ServiceInstance, details domain.UpdateDetails) bool {
+	if len(existing.History) == 0 {
+		return false
+	}
+	last := existing.History[len(existing.History)-1]
+	if last.PlanID == "" && last.MaintenanceInfo == nil {
+		return false
+	}
+
+	before := existing
+	if last.PlanID != "" {
+		if details.PlanID != last.PlanID {
+			return false
+		}
+		before.PlanID = last.PreviousPlanID
+	} else if details.PlanID != "" && details.PlanID != existing.PlanID {
+		return false
+	}
+	if last.MaintenanceInfo != nil {
+		if !maintenanceInfoEqual(details.MaintenanceInfo, last.MaintenanceInfo) {
+			return false
+		}
+		before.MaintenanceInfo = last.PreviousMaintenanceInfo
+	} else if details.MaintenanceInfo != nil && !maintenanceInfoEqual(existing.MaintenanceInfo, details.MaintenanceInfo) {
+		return false
+	}
+
+	return checkPreviousValues(id, before, details) == nil
+}
+
+func maintenanceInfoEqual(a, b *domain.MaintenanceInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equals(*b)
+}
@@ -0,0 +1,227 @@
+package brokerstore_test
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpdateInstanceDetails", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		records     map[string]values.JSON
+		store       *CredhubStore
+		update      domain.UpdateDetails
+		err         error
+	)
+
+	BeforeEach(func() {
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		records = map[string]values.JSON{}
+		backCredhubWith(fakeCredhub, records)
+		store = NewCredhubStore(lagertest.NewTestLogger("update-instance"), fakeCredhub, "some-store-id")
+
+		Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{
+			ServiceID:          "service-id",
+			PlanID:             "small",
+			OrganizationGUID:   "org-guid",
+			SpaceGUID:          "space-guid",
+			ServiceFingerPrint: map[string]interface{}{"share": "nfs://server/export"},
+			MaintenanceInfo:    &domain.MaintenanceInfo{Version: "1.0.0"},
+		})).To(Succeed())
+
+		update = domain.UpdateDetails{
+			ServiceID:       "service-id",
+			PlanID:          "large",
+			MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0"},
+			PreviousValues: domain.PreviousValues{
+				PlanID:          "small",
+				ServiceID:       "service-id",
+				OrgID:           "org-guid",
+				SpaceID:         "space-guid",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = store.UpdateInstanceDetails("instance-1", update)
+	})
+
+	It("should change the plan and maintenance info and record the change", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		instance, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.PlanID).To(Equal("large"))
+		Expect(instance.MaintenanceInfo).To(Equal(&domain.MaintenanceInfo{Version: "1.1.0"}))
+		Expect(instance.ServiceFingerPrint).To(Equal(map[string]interface{}{"share": "nfs://server/export"}))
+
+		Expect(instance.History).To(HaveLen(1))
+		Expect(instance.History[0].ChangedAt).NotTo(BeZero())
+		Expect(instance.History[0].PreviousPlanID).To(Equal("small"))
+		Expect(instance.History[0].PlanID).To(Equal("large"))
+		Expect(instance.History[0].PreviousMaintenanceInfo).To(Equal(&domain.MaintenanceInfo{Version: "1.0.0"}))
+		Expect(instance.History[0].MaintenanceInfo).To(Equal(&domain.MaintenanceInfo{Version: "1.1.0"}))
+	})
+
+	It("should not treat a provision retry as conflicting because of the history", func() {
+		instance, err := store.RetrieveInstanceDetails("instance-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		instance.History = nil
+		Expect(store.IsInstanceConflict("instance-1", instance)).To(BeFalse())
+	})
+
+	Context("when an update that was already applied is retried", func() {
+		JustBeforeEach(func() {
+			Expect(err).NotTo(HaveOccurred())
+			err = store.UpdateInstanceDetails("instance-1", update)
+		})
+
+		It("should succeed without recording the change again", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			instance, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instance.PlanID).To(Equal("large"))
+			Expect(instance.History).To(HaveLen(1))
+		})
+	})
+
+	Context("when a different update is sent with values from before the last one", func() {
+		JustBeforeEach(func() {
+			Expect(err).NotTo(HaveOccurred())
+			update.PlanID = "medium"
+			update.MaintenanceInfo = nil
+			err = store.UpdateInstanceDetails("instance-1", update)
+		})
+
+		It("should return a mismatch error", func() {
+			var mismatch *PreviousValuesMismatchError
+			Expect(errors.As(err, &mismatch)).To(BeTrue())
+			Expect(mismatch.Field).To(Equal("plan_id"))
+		})
+	})
+
+	Context("when the previous values do not match the stored instance", func() {
+		BeforeEach(func() {
+			update.PreviousValues.PlanID = "medium"
+		})
+
+		It("should return a mismatch error and leave the instance alone", func() {
+			var mismatch *PreviousValuesMismatchError
+			Expect(errors.As(err, &mismatch)).To(BeTrue())
+			Expect(mismatch.Field).To(Equal("plan_id"))
+			Expect(mismatch.Stored).To(Equal("small"))
+			Expect(mismatch.Previous).To(Equal("medium"))
+
+			instance, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instance.PlanID).To(Equal("small"))
+			Expect(instance.History).To(BeEmpty())
+		})
+	})
+
+	Context("when the previous maintenance info is out of date", func() {
+		BeforeEach(func() {
+			update.PreviousValues.MaintenanceInfo = &domain.MaintenanceInfo{Version: "0.9.0"}
+		})
+
+		It("should return a mismatch error", func() {
+			Expect(err).To(MatchError(&PreviousValuesMismatchError{
+				ID:       "instance-1",
+				Field:    "maintenance_info",
+				Stored:   "1.0.0",
+				Previous: "0.9.0",
+			}))
+		})
+	})
+
+	Context("when the update asks for a different service", func() {
+		BeforeEach(func() {
+			update.ServiceID = "other-service-id"
+		})
+
+		It("should return a mismatch error", func() {
+			var mismatch *PreviousValuesMismatchError
+			Expect(errors.As(err, &mismatch)).To(BeTrue())
+			Expect(mismatch.Field).To(Equal("service_id"))
+		})
+	})
+
+	Context("when nothing tracked changes", func() {
+		BeforeEach(func() {
+			update.PlanID = "small"
+			update.MaintenanceInfo = nil
+		})
+
+		It("should not rewrite the instance", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCredhub.SetJSONCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the instance does not exist", func() {
+		JustBeforeEach(func() {
+			err = store.UpdateInstanceDetails("instance-2", update)
+		})
+
+		It("should return the read error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when more changes are made than are kept", func() {
+		JustBeforeEach(func() {
+			for i := 0; i < MaxInstanceHistory+2; i++ {
+				instance, err := store.RetrieveInstanceDetails("instance-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(store.UpdateInstanceDetails("instance-1", domain.UpdateDetails{
+					PlanID:         fmt.Sprintf("plan-%d", i),
+					PreviousValues: domain.PreviousValues{PlanID: instance.PlanID},
+				})).To(Succeed())
+			}
+		})
+
+		It("should keep only the most recent changes", func() {
+			instance, err := store.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instance.History).To(HaveLen(MaxInstanceHistory))
+			Expect(instance.History[0].PreviousPlanID).To(Equal("plan-1"))
+			Expect(instance.History[MaxInstanceHistory-1].PlanID).To(Equal(fmt.Sprintf("plan-%d", MaxInstanceHistory+1)))
+		})
+	})
+
+	Context("through a decorator that wraps the stored record", func() {
+		var (
+			fakeStore *brokerstorefakes.FakeStore
+			integrity *IntegrityStore
+		)
+
+		BeforeEach(func() {
+			fakeStore = &brokerstorefakes.FakeStore{}
+			backStoreWithMemory(fakeStore)
+			integrity = NewIntegrityStore(lagertest.NewTestLogger("update-instance"), fakeStore, []byte("a-key"), IntegrityOptions{})
+			Expect(integrity.CreateInstanceDetails("instance-1", ServiceInstance{ServiceID: "service-id", PlanID: "small"})).To(Succeed())
+		})
+
+		It("should leave a record that still verifies", func() {
+			update.PreviousValues = domain.PreviousValues{PlanID: "small"}
+			Expect(integrity.UpdateInstanceDetails("instance-1", update)).To(Succeed())
+
+			instance, err := integrity.RetrieveInstanceDetails("instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instance.PlanID).To(Equal("large"))
+			Expect(instance.History).To(HaveLen(1))
+		})
+	})
+})
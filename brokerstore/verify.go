@@ -0,0 +1,190 @@
+package brokerstore
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// VerifyOptions controls which consistency checks Verify runs and whether it
+// repairs what it finds.
+type VerifyOptions struct {
+	// InstanceIDForBinding returns the ID of the instance a binding belongs to.
+	// Bindings store no reference to their instance, so orphan detection is
+	// skipped when this is nil or returns "".
+	InstanceIDForBinding func(bindingID string, details domain.BindDetails) string
+
+	// LegacyStoreIDs lists store IDs used by earlier deployments of the broker.
+	// Any records still stored under them are reported as stale.
+	LegacyStoreIDs []string
+
+	// Repair deletes orphan bindings, as DeleteBindingDetails does, and stale
+	// records once they are reported. Records that fail to decode or lack a
+	// params hash are only reported.
+	Repair bool
+}
+
+var errUnrecognisedRecord = errors.New("record is neither an instance nor a binding")
+
+type RecordError struct {
+	ID  string `json:"id"`
+	Err error  `json:"-"`
+}
+
+func (e RecordError) MarshalJSON() ([]byte, error) {
+	out := map[string]string{"id": e.ID}
+	if e.Err != nil {
+		out["error"] = e.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+type VerifyReport struct {
+	Instances         int           `json:"instances"`
+	Bindings          int           `json:"bindings"`
+	OrphanBindings    []string      `json:"orphan_bindings"`
+	DecodeFailures    []RecordError `json:"decode_failures"`
+	DuplicateIDs      []string      `json:"duplicate_ids"`
+	MissingParamsHash []string      `json:"missing_params_hash"`
+	StaleRecords      []string      `json:"stale_records"`
+	Repaired          []string      `json:"repaired"`
+}
+
+// Healthy reports whether Verify found no problems at all.
+func (r VerifyReport) Healthy() bool {
+	return len(r.OrphanBindings) == 0 &&
+		len(r.DecodeFailures) == 0 &&
+		len(r.DuplicateIDs) == 0 &&
+		len(r.MissingParamsHash) == 0 &&
+		len(r.StaleRecords) == 0
+}
+
+// Verify walks every record in the store and reports orphan bindings, records
+// that no longer decode, IDs stored as an instance under one store ID and as a
+// binding under another, bindings stored without a params hash, and records
+// left under legacy store IDs.
+func (s *CredhubStore) Verify(opts VerifyOptions) (VerifyReport, error) {
+	logger := s.logger.Session("verify", lager.Data{"repair": opts.Repair})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := VerifyReport{}
+
+	records, err := s.retrieveAllRecords(s.storeID)
+	if err != nil {
+		return report, err
+	}
+
+	kinds := map[string]RecordKind{}
+	instances := map[string]ServiceInstance{}
+	bindings := map[string]domain.BindDetails{}
+	for id, record := range records {
+		if record.Err != nil {
+			report.DecodeFailures = append(report.DecodeFailures, RecordError{ID: id, Err: record.Err})
+			continue
+		}
+
+		switch record.Kind {
+		case InstanceRecord:
+			var serviceInstance ServiceInstance
+			if err := toStruct(record.Value, &serviceInstance); err != nil {
+				report.DecodeFailures = append(report.DecodeFailures, RecordError{ID: id, Err: err})
+				continue
+			}
+			instances[id] = serviceInstance
+		case BindingRecord:
+			var bindDetails domain.BindDetails
+			if err := toStruct(record.Value, &bindDetails); err != nil {
+				report.DecodeFailures = append(report.DecodeFailures, RecordError{ID: id, Err: err})
+				continue
+			}
+			bindings[id] = bindDetails
+			if !hasParamsHash(bindDetails) {
+				report.MissingParamsHash = append(report.MissingParamsHash, id)
+			}
+		default:
+			report.DecodeFailures = append(report.DecodeFailures, RecordError{ID: id, Err: errUnrecognisedRecord})
+			continue
+		}
+		kinds[id] = record.Kind
+	}
+	report.Instances = len(instances)
+	report.Bindings = len(bindings)
+
+	if opts.InstanceIDForBinding != nil {
+		for id, details := range bindings {
+			instanceID := opts.InstanceIDForBinding(id, details)
+			if instanceID == "" {
+				continue
+			}
+			if _, ok := instances[instanceID]; !ok {
+				report.OrphanBindings = append(report.OrphanBindings, id)
+			}
+		}
+	}
+
+	for _, legacyStoreID := range opts.LegacyStoreIDs {
+		if legacyStoreID == s.storeID {
+			continue
+		}
+
+		legacyRecords, err := s.retrieveAllRecords(legacyStoreID)
+		if err != nil {
+			return report, err
+		}
+		for id, record := range legacyRecords {
+			report.StaleRecords = append(report.StaleRecords, namespacedIn(legacyStoreID, id))
+			if kind, ok := kinds[id]; ok && record.Kind != UnknownRecord && record.Kind != kind {
+				report.DuplicateIDs = append(report.DuplicateIDs, id)
+			}
+		}
+	}
+
+	sort.Strings(report.OrphanBindings)
+	sort.Strings(report.DuplicateIDs)
+	sort.Strings(report.MissingParamsHash)
+	sort.Strings(report.StaleRecords)
+	sort.Slice(report.DecodeFailures, func(i, j int) bool {
+		return report.DecodeFailures[i].ID < report.DecodeFailures[j].ID
+	})
+
+	if !opts.Repair {
+		return report, nil
+	}
+
+	for _, id := range report.OrphanBindings {
+		if err := s.DeleteBindingDetails(id); err != nil {
+			logger.Error("failed-to-delete-orphan-binding", err, lager.Data{"id": id})
+			return report, err
+		}
+		report.Repaired = append(report.Repaired, s.namespaced(id))
+	}
+	for _, name := range report.StaleRecords {
+		if err := s.credhubShim.Delete(name); err != nil {
+			logger.Error("failed-to-delete-stale-record", err, lager.Data{"name": name})
+			return report, err
+		}
+		report.Repaired = append(report.Repaired, name)
+	}
+
+	return report, nil
+}
+
+// hasParamsHash reports whether a binding's parameters were stored with the
+// secrets stripped out, i.e. as a single bcrypt hash under HashKey.
+func hasParamsHash(details domain.BindDetails) bool {
+	if len(details.RawParameters) == 0 {
+		return true
+	}
+
+	var opts map[string]interface{}
+	if err := json.Unmarshal(details.RawParameters, &opts); err != nil {
+		return false
+	}
+
+	_, ok := opts[HashKey].(string)
+	return ok
+}
@@ -0,0 +1,168 @@
+package brokerstore_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	"github.com/hashicorp/go-version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verify", func() {
+	var (
+		fakeCredhub *credhub_fakes.FakeCredhub
+		store       *CredhubStore
+		records     map[string]values.JSON
+		opts        VerifyOptions
+		report      VerifyReport
+		err         error
+	)
+
+	BeforeEach(func() {
+		records = map[string]values.JSON{
+			"/some-store-id/migrated-from-sql": nil,
+			"/some-store-id/instance-1": {
+				"service_id":        "service-id",
+				"plan_id":           "plan-id",
+				"organization_guid": "org-guid",
+				"space_guid":        "space-guid",
+			},
+			"/some-store-id/binding-1": {
+				"app_guid":   "app-guid",
+				"plan_id":    "plan-id",
+				"service_id": "service-id",
+				"parameters": map[string]interface{}{HashKey: "some-hash"},
+			},
+			"/some-store-id/binding-2": {
+				"app_guid":   "app-guid",
+				"plan_id":    "plan-id",
+				"service_id": "service-id",
+				"parameters": map[string]interface{}{"password": "a-password"},
+			},
+			"/some-store-id/undecodable": {
+				"organization_guid": 42,
+			},
+			"/some-store-id/both": {
+				"organization_guid": "org-guid",
+				"app_guid":          "app-guid",
+			},
+			"/old-store-id/binding-3": {
+				"app_guid": "app-guid",
+			},
+			"/old-store-id/instance-1": {
+				"app_guid": "app-guid",
+			},
+		}
+
+		fakeCredhub = &credhub_fakes.FakeCredhub{}
+		backCredhubWith(fakeCredhub, records)
+		store = NewCredhubStore(lagertest.NewTestLogger("verify"), fakeCredhub, "some-store-id")
+
+		instanceOf := map[string]string{"binding-1": "instance-1", "binding-2": "gone-instance"}
+		opts = VerifyOptions{
+			InstanceIDForBinding: func(bindingID string, _ domain.BindDetails) string {
+				return instanceOf[bindingID]
+			},
+			LegacyStoreIDs: []string{"old-store-id"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		report, err = store.Verify(opts)
+	})
+
+	It("should report every class of problem", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Instances).To(Equal(1))
+		Expect(report.Bindings).To(Equal(2))
+		Expect(report.OrphanBindings).To(ConsistOf("binding-2"))
+		Expect(report.MissingParamsHash).To(ConsistOf("binding-2"))
+		Expect(report.DuplicateIDs).To(ConsistOf("instance-1"))
+		Expect(report.StaleRecords).To(ConsistOf("/old-store-id/binding-3", "/old-store-id/instance-1"))
+		Expect(report.DecodeFailures).To(HaveLen(2))
+		Expect(report.DecodeFailures[0].ID).To(Equal("both"))
+		Expect(report.DecodeFailures[0].Err).To(MatchError("record is neither an instance nor a binding"))
+		Expect(report.DecodeFailures[1].ID).To(Equal("undecodable"))
+		Expect(report.Healthy()).To(BeFalse())
+		Expect(report.Repaired).To(BeEmpty())
+	})
+
+	It("should not modify the store", func() {
+		Expect(fakeCredhub.DeleteCallCount()).To(Equal(0))
+		Expect(fakeCredhub.SetJSONCallCount()).To(Equal(0))
+	})
+
+	Context("when no instance resolver is supplied", func() {
+		BeforeEach(func() {
+			opts.InstanceIDForBinding = nil
+		})
+
+		It("should skip orphan detection", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.OrphanBindings).To(BeEmpty())
+		})
+	})
+
+	Context("when repairing", func() {
+		BeforeEach(func() {
+			opts.Repair = true
+		})
+
+		It("should delete orphan bindings and stale records", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Repaired).To(ConsistOf("/some-store-id/binding-2", "/old-store-id/binding-3", "/old-store-id/instance-1"))
+			Expect(records).NotTo(HaveKey("/some-store-id/binding-2"))
+			Expect(records).NotTo(HaveKey("/old-store-id/binding-3"))
+			Expect(records).To(HaveKey("/some-store-id/undecodable"))
+		})
+
+		Context("when indexes are enabled and the orphan has credentials", func() {
+			BeforeEach(func() {
+				store.EnableIndexes()
+				records["/some-store-id/binding-2"]["context"] = map[string]interface{}{BindingInstanceKey: "gone-instance"}
+				records["/some-store-id/.index/instance/gone-instance/binding-2"] = values.JSON{"kind": "instance", "key": "gone-instance", "id": "binding-2"}
+				records["/some-store-id/binding-2/credentials"] = values.JSON{"password": "a-password"}
+				fakeCredhub.ServerVersionReturns(version.Must(version.NewVersion("2.12.0")), nil)
+			})
+
+			It("should delete them along with the binding", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(records).NotTo(HaveKey("/some-store-id/binding-2/credentials"))
+				Expect(records).NotTo(HaveKey("/some-store-id/.index/instance/gone-instance/binding-2"))
+			})
+		})
+
+		Context("when a delete fails", func() {
+			BeforeEach(func() {
+				fakeCredhub.DeleteReturns(errors.New("bad-delete"))
+				fakeCredhub.DeleteStub = nil
+			})
+
+			It("should return the error with the partial report", func() {
+				Expect(err).To(MatchError("bad-delete"))
+				Expect(report.OrphanBindings).To(ConsistOf("binding-2"))
+				Expect(report.Repaired).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the store is consistent", func() {
+		BeforeEach(func() {
+			for name := range records {
+				if name != "/some-store-id/instance-1" && name != "/some-store-id/binding-1" {
+					delete(records, name)
+				}
+			}
+		})
+
+		It("should report a healthy store", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Healthy()).To(BeTrue())
+		})
+	})
+})
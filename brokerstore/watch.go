@@ -0,0 +1,253 @@
+package brokerstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+)
+
+// watchBufferSize is how many events a slow watcher may fall behind by
+// before further events are dropped for it.
+const watchBufferSize = 64
+
+// Event describes a change to a record. Instance or Binding holds the new
+// value for creates and updates, according to Kind. VersionID is set when the
+// backend versions its records.
+type Event struct {
+	Type      EventType
+	Kind      RecordKind
+	ID        string
+	VersionID string
+	Instance  ServiceInstance
+	Binding   domain.BindDetails
+}
+
+// WatchFilter selects the events delivered to a watcher. Empty fields match
+// everything.
+type WatchFilter struct {
+	Kinds []RecordKind
+	IDs   []string
+}
+
+func (f WatchFilter) Matches(event Event) bool {
+	return (len(f.Kinds) == 0 || slices.Contains(f.Kinds, event.Kind)) &&
+		(len(f.IDs) == 0 || slices.Contains(f.IDs, event.ID))
+}
+
+// Watcher is implemented by stores that can deliver change events. The
+// channel is closed once ctx is done.
+type Watcher interface {
+	Watch(ctx context.Context, filter WatchFilter) <-chan Event
+}
+
+// broadcaster fans events out to watchers. A watcher that falls more than
+// watchBufferSize events behind misses events rather than stalling writers.
+type broadcaster struct {
+	logger   lager.Logger
+	mutex    sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+	filter WatchFilter
+	events chan Event
+}
+
+func newBroadcaster(logger lager.Logger) *broadcaster {
+	return &broadcaster{
+		logger:   logger,
+		watchers: map[*watcher]struct{}{},
+	}
+}
+
+func (b *broadcaster) watch(ctx context.Context, filter WatchFilter) <-chan Event {
+	w := &watcher{filter: filter, events: make(chan Event, watchBufferSize)}
+
+	b.mutex.Lock()
+	b.watchers[w] = struct{}{}
+	b.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mutex.Lock()
+		delete(b.watchers, w)
+		close(w.events)
+		b.mutex.Unlock()
+	}()
+
+	return w.events
+}
+
+func (b *broadcaster) publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for w := range b.watchers {
+		if !w.filter.Matches(event) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			b.logger.Info("dropped-watch-event", lager.Data{"type": event.Type, "kind": event.Kind, "id": event.ID})
+		}
+	}
+}
+
+// NotifyingStore publishes an event to its watchers for every successful
+// create and delete made through it. It sees only its own writes, so it suits
+// stores owned by a single broker process; use CredhubStore.Watch to see
+// changes made by other processes.
+type NotifyingStore struct {
+	logger      lager.Logger
+	store       Store
+	broadcaster *broadcaster
+}
+
+func NewNotifyingStore(logger lager.Logger, store Store) *NotifyingStore {
+	logger = logger.Session("notifying-store")
+	return &NotifyingStore{
+		logger:      logger,
+		store:       store,
+		broadcaster: newBroadcaster(logger),
+	}
+}
+
+func (s *NotifyingStore) Watch(ctx context.Context, filter WatchFilter) <-chan Event {
+	return s.broadcaster.watch(ctx, filter)
+}
+
+func (s *NotifyingStore) WithContext(ctx context.Context) Store {
+	withContext := *s
+	withContext.store = storeWithContext(s.store, ctx)
+	return &withContext
+}
+
+func (s *NotifyingStore) Health() HealthStatus {
+	return storeHealth(s.store)
+}
+
+func (s *NotifyingStore) Ping(ctx context.Context) error {
+	return storePing(ctx, s.store)
+}
+
+func (s *NotifyingStore) RetrieveInstanceDetails(id string) (ServiceInstance, error) {
+	return s.store.RetrieveInstanceDetails(id)
+}
+
+func (s *NotifyingStore) RetrieveBindingDetails(id string) (domain.BindDetails, error) {
+	return s.store.RetrieveBindingDetails(id)
+}
+
+func (s *NotifyingStore) RetrieveAllInstanceDetails() (map[string]ServiceInstance, error) {
+	return s.store.RetrieveAllInstanceDetails()
+}
+
+func (s *NotifyingStore) RetrieveAllBindingDetails() (map[string]domain.BindDetails, error) {
+	return s.store.RetrieveAllBindingDetails()
+}
+
+func (s *NotifyingStore) CreateInstanceDetails(id string, details ServiceInstance) error {
+	_, err := s.store.RetrieveInstanceDetails(id)
+	eventType, err := createOrUpdate(err)
+	if err != nil {
+		s.logger.Error("failed-to-read-before-write", err, recordData(InstanceRecord, id))
+		return err
+	}
+
+	if err := s.store.CreateInstanceDetails(id, details); err != nil {
+		return err
+	}
+	s.broadcaster.publish(Event{Type: eventType, Kind: InstanceRecord, ID: id, Instance: details})
+	return nil
+}
+
+func (s *NotifyingStore) CreateBindingDetails(id string, details domain.BindDetails) error {
+	_, err := s.store.RetrieveBindingDetails(id)
+	eventType, err := createOrUpdate(err)
+	if err != nil {
+		s.logger.Error("failed-to-read-before-write", err, recordData(BindingRecord, id))
+		return err
+	}
+
+	if err := s.store.CreateBindingDetails(id, details); err != nil {
+		return err
+	}
+	s.broadcaster.publish(Event{Type: eventType, Kind: BindingRecord, ID: id, Binding: details})
+	return nil
+}
+
+func (s *NotifyingStore) UpdateInstanceDetails(id string, details domain.UpdateDetails) error {
+	if err := s.store.UpdateInstanceDetails(id, details); err != nil {
+		return err
+	}
+	updated, err := s.store.RetrieveInstanceDetails(id)
+	if err != nil {
+		s.logger.Error("failed-to-read-updated-instance", err, recordData(InstanceRecord, id))
+		return fmt.Errorf("reading back updated %s %s: %w", InstanceRecord, id, err)
+	}
+	s.broadcaster.publish(Event{Type: EventUpdate, Kind: InstanceRecord, ID: id, Instance: updated})
+	return nil
+}
+
+func (s *NotifyingStore) DeleteInstanceDetails(id string) error {
+	if err := s.store.DeleteInstanceDetails(id); err != nil {
+		return err
+	}
+	s.broadcaster.publish(Event{Type: EventDelete, Kind: InstanceRecord, ID: id})
+	return nil
+}
+
+func (s *NotifyingStore) DeleteBindingDetails(id string) error {
+	if err := s.store.DeleteBindingDetails(id); err != nil {
+		return err
+	}
+	s.broadcaster.publish(Event{Type: EventDelete, Kind: BindingRecord, ID: id})
+	return nil
+}
+
+func (s *NotifyingStore) IsInstanceConflict(id string, details ServiceInstance) bool {
+	return s.store.IsInstanceConflict(id, details)
+}
+
+func (s *NotifyingStore) IsBindingConflict(id string, details domain.BindDetails) bool {
+	return s.store.IsBindingConflict(id, details)
+}
+
+func (s *NotifyingStore) Restore(logger lager.Logger) error {
+	return s.store.Restore(logger)
+}
+
+func (s *NotifyingStore) Save(logger lager.Logger) error {
+	return s.store.Save(logger)
+}
+
+func (s *NotifyingStore) Cleanup() error {
+	return s.store.Cleanup()
+}
+
+// createOrUpdate decides the event type of a write from the result of
+// reading the record beforehand. Only a record that is not found makes the
+// write a create; any other read error is returned, as the type is unknown.
+func createOrUpdate(retrieveErr error) (EventType, error) {
+	switch {
+	case retrieveErr == nil:
+		return EventUpdate, nil
+	case isNotFound(retrieveErr):
+		return EventCreate, nil
+	default:
+		return "", retrieveErr
+	}
+}
@@ -0,0 +1,233 @@
+package brokerstore_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/brokerstorefakes"
+	"code.cloudfoundry.org/service-broker-store/brokerstore/credhub_shims/credhub_fakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watch", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should filter events by kind and ID", func() {
+		filter := WatchFilter{Kinds: []RecordKind{BindingRecord}, IDs: []string{"binding-1"}}
+		Expect(filter.Matches(Event{Kind: BindingRecord, ID: "binding-1"})).To(BeTrue())
+		Expect(filter.Matches(Event{Kind: BindingRecord, ID: "binding-2"})).To(BeFalse())
+		Expect(filter.Matches(Event{Kind: InstanceRecord, ID: "binding-1"})).To(BeFalse())
+		Expect(WatchFilter{}.Matches(Event{Kind: InstanceRecord, ID: "anything"})).To(BeTrue())
+	})
+
+	Context("NotifyingStore", func() {
+		var (
+			fakeStore *brokerstorefakes.FakeStore
+			store     *NotifyingStore
+		)
+
+		BeforeEach(func() {
+			fakeStore = &brokerstorefakes.FakeStore{}
+			backStoreWithMemory(fakeStore)
+			store = NewNotifyingStore(lagertest.NewTestLogger("notifying-store"), fakeStore)
+		})
+
+		It("should deliver create, update and delete events to matching watchers", func() {
+			instanceEvents := store.Watch(ctx, WatchFilter{Kinds: []RecordKind{InstanceRecord}})
+			allEvents := store.Watch(ctx, WatchFilter{})
+
+			instance := ServiceInstance{OrganizationGUID: "org-guid", PlanID: "plan-1"}
+			Expect(store.CreateInstanceDetails("instance-1", instance)).To(Succeed())
+			instance.PlanID = "plan-2"
+			Expect(store.CreateInstanceDetails("instance-1", instance)).To(Succeed())
+			Expect(store.CreateBindingDetails("binding-1", domain.BindDetails{AppGUID: "app-guid"})).To(Succeed())
+			Expect(store.DeleteInstanceDetails("instance-1")).To(Succeed())
+
+			Expect(<-instanceEvents).To(Equal(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1", Instance: ServiceInstance{OrganizationGUID: "org-guid", PlanID: "plan-1"}}))
+			Expect(<-instanceEvents).To(Equal(Event{Type: EventUpdate, Kind: InstanceRecord, ID: "instance-1", Instance: instance}))
+			Expect(<-instanceEvents).To(Equal(Event{Type: EventDelete, Kind: InstanceRecord, ID: "instance-1"}))
+			Consistently(instanceEvents).ShouldNot(Receive())
+
+			var types []EventType
+			for i := 0; i < 4; i++ {
+				types = append(types, (<-allEvents).Type)
+			}
+			Expect(types).To(Equal([]EventType{EventCreate, EventUpdate, EventCreate, EventDelete}))
+		})
+
+		It("should not deliver events for failed writes", func() {
+			events := store.Watch(ctx, WatchFilter{})
+			fakeStore.DeleteBindingDetailsReturns(fmt.Errorf("bad-delete"))
+
+			Expect(store.DeleteBindingDetails("binding-1")).NotTo(Succeed())
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("should return an error rather than publish an update it cannot read back", func() {
+			events := store.Watch(ctx, WatchFilter{})
+			fakeStore.RetrieveInstanceDetailsStub = nil
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, fmt.Errorf("credhub-down"))
+
+			Expect(store.UpdateInstanceDetails("instance-1", domain.UpdateDetails{PlanID: "plan-2"})).To(MatchError(ContainSubstring("credhub-down")))
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("should not write when the record cannot be read beforehand", func() {
+			events := store.Watch(ctx, WatchFilter{})
+			fakeStore.RetrieveInstanceDetailsStub = nil
+			fakeStore.RetrieveInstanceDetailsReturns(ServiceInstance{}, fmt.Errorf("credhub-down"))
+
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(MatchError("credhub-down"))
+			Expect(fakeStore.CreateInstanceDetailsCallCount()).To(Equal(0))
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("should close the channel when the context is done", func() {
+			events := store.Watch(ctx, WatchFilter{})
+			cancel()
+			Eventually(events).Should(BeClosed())
+			Expect(store.CreateInstanceDetails("instance-1", ServiceInstance{})).To(Succeed())
+		})
+	})
+
+	Context("CredhubStore", func() {
+		var (
+			fakeCredhub *credhub_fakes.FakeCredhub
+			server      *versionedCredhub
+			store       *CredhubStore
+		)
+
+		BeforeEach(func() {
+			fakeCredhub = &credhub_fakes.FakeCredhub{}
+			server = newVersionedCredhub(fakeCredhub)
+			server.set("/some-store-id/existing", values.JSON{"organization_guid": "org-guid"})
+			server.set("/some-store-id/migrated-from-sql", values.JSON{})
+			store = NewCredhubStore(lagertest.NewTestLogger("credhub-watch"), fakeCredhub, "some-store-id")
+		})
+
+		It("should poll for changes after the baseline", func() {
+			events := store.WatchEvery(ctx, WatchFilter{}, 10*time.Millisecond)
+			Consistently(events, 50*time.Millisecond).ShouldNot(Receive())
+
+			server.set("/some-store-id/binding-1", values.JSON{"app_guid": "app-guid"})
+			var event Event
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventCreate))
+			Expect(event.Kind).To(Equal(BindingRecord))
+			Expect(event.ID).To(Equal("binding-1"))
+			Expect(event.VersionID).To(Equal("version-3"))
+			Expect(event.Binding.AppGUID).To(Equal("app-guid"))
+
+			server.set("/some-store-id/existing", values.JSON{"organization_guid": "org-guid", "plan_id": "new-plan"})
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(EventUpdate))
+			Expect(event.Kind).To(Equal(InstanceRecord))
+			Expect(event.Instance.PlanID).To(Equal("new-plan"))
+
+			server.delete("/some-store-id/binding-1")
+			Eventually(events).Should(Receive(&event))
+			Expect(event).To(Equal(Event{Type: EventDelete, Kind: BindingRecord, ID: "binding-1", VersionID: "version-3"}))
+		})
+
+		It("should ignore nested credentials", func() {
+			events := store.WatchEvery(ctx, WatchFilter{}, 10*time.Millisecond)
+			server.set("/some-store-id/binding-1/credentials", values.JSON{"password": "secret"})
+			Consistently(events, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should only deliver matching events", func() {
+			events := store.WatchEvery(ctx, WatchFilter{Kinds: []RecordKind{InstanceRecord}}, 10*time.Millisecond)
+			server.set("/some-store-id/binding-1", values.JSON{"app_guid": "app-guid"})
+			server.set("/some-store-id/instance-2", values.JSON{"organization_guid": "org-guid"})
+
+			var event Event
+			Eventually(events).Should(Receive(&event))
+			Expect(event.ID).To(Equal("instance-2"))
+			Consistently(events, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should close the channel when the context is done", func() {
+			events := store.WatchEvery(ctx, WatchFilter{}, 10*time.Millisecond)
+			cancel()
+			Eventually(events).Should(BeClosed())
+		})
+	})
+})
+
+// versionedCredhub makes the fake behave like a CredHub server that versions
+// its credentials, safely for use from a polling goroutine.
+type versionedCredhub struct {
+	mutex    sync.Mutex
+	versions int
+	records  map[string]credentials.JSON
+}
+
+func newVersionedCredhub(fakeCredhub *credhub_fakes.FakeCredhub) *versionedCredhub {
+	server := &versionedCredhub{records: map[string]credentials.JSON{}}
+
+	fakeCredhub.FindByPathStub = func(path string) (credentials.FindResults, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		results := credentials.FindResults{}
+		for name, creds := range server.records {
+			if strings.HasPrefix(name, path) {
+				results.Credentials = append(results.Credentials, struct {
+					Name             string `json:"name" yaml:"name"`
+					VersionCreatedAt string `json:"version_created_at" yaml:"version_created_at"`
+				}{Name: name, VersionCreatedAt: creds.VersionCreatedAt})
+			}
+		}
+		return results, nil
+	}
+	fakeCredhub.GetLatestJSONStub = func(name string) (credentials.JSON, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		creds, ok := server.records[name]
+		if !ok {
+			return credentials.JSON{}, &credhub.NotFoundError{}
+		}
+		return creds, nil
+	}
+	return server
+}
+
+func (c *versionedCredhub) set(name string, value values.JSON) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.versions++
+	creds := credentials.JSON{Value: value}
+	creds.Id = fmt.Sprintf("version-%d", c.versions)
+	creds.Name = name
+	creds.VersionCreatedAt = time.Date(2026, 1, 1, 0, 0, c.versions, 0, time.UTC).Format(time.RFC3339)
+	c.records[name] = creds
+}
+
+func (c *versionedCredhub) delete(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.records, name)
+}
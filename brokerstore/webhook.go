@@ -0,0 +1,416 @@
+package brokerstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	WebhookDeliveryHeader  = "X-Brokerstore-Delivery"
+	WebhookTimestampHeader = "X-Brokerstore-Timestamp"
+	WebhookSignatureHeader = "X-Brokerstore-Signature"
+
+	defaultWebhookQueueSize      = 1000
+	defaultWebhookInitialBackoff = time.Second
+	defaultWebhookMaxBackoff     = 5 * time.Minute
+)
+
+var ErrWebhookQueueFull = errors.New("webhook queue is full")
+
+// webhookDeadLetterDir is where undeliverable events are moved within
+// QueueDir, out of the way of the queue.
+const webhookDeadLetterDir = "dead-letter"
+
+// WebhookStatusError is returned when the receiver responds with a status
+// other than 2xx.
+type WebhookStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *WebhookStatusError) Error() string {
+	return fmt.Sprintf("webhook receiver responded %s", e.Status)
+}
+
+// Retryable reports whether a later delivery could succeed. Client errors
+// other than 408 and 429 mean the receiver will never accept the event.
+func (e *WebhookStatusError) Retryable() bool {
+	if e.StatusCode < 400 || e.StatusCode > 499 {
+		return true
+	}
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+type WebhookOptions struct {
+	URL string
+	// Secret signs every payload; see VerifyWebhookSignature
+	Secret []byte
+	// QueueDir keeps undelivered events on disk so they survive a restart.
+	// Events are only held in memory when it is empty.
+	QueueDir string
+	// MaxQueueSize bounds the undelivered events; Notify fails beyond it
+	MaxQueueSize   int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Client         *http.Client
+}
+
+// WebhookPayload is the JSON body POSTed for each event. Instance and Binding
+// carry the same redacted views used in log lines, never raw parameters.
+type WebhookPayload struct {
+	DeliveryID string     `json:"delivery_id"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Type       EventType  `json:"type"`
+	Kind       RecordKind `json:"kind"`
+	ID         string     `json:"id"`
+	VersionID  string     `json:"version_id,omitempty"`
+	Instance   lager.Data `json:"instance,omitempty"`
+	Binding    lager.Data `json:"binding,omitempty"`
+}
+
+// WebhookNotifier POSTs store events to an HTTP endpoint. Notify queues an
+// event and Run delivers the queue in order, retrying failed deliveries with
+// exponential backoff. Each request is signed with HMAC-SHA256 over the
+// timestamp and body, so receivers can reject forged or replayed payloads.
+type WebhookNotifier struct {
+	logger  lager.Logger
+	opts    WebhookOptions
+	queue   *webhookQueue
+	wake    chan struct{}
+	dropped atomic.Int64
+}
+
+func NewWebhookNotifier(logger lager.Logger, opts WebhookOptions) (*WebhookNotifier, error) {
+	if opts.MaxQueueSize <= 0 {
+		opts.MaxQueueSize = defaultWebhookQueueSize
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultWebhookInitialBackoff
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = max(defaultWebhookMaxBackoff, opts.InitialBackoff)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	logger = logger.Session("webhook-notifier", lager.Data{"url": opts.URL})
+	queue, err := loadWebhookQueue(opts.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	if queued := queue.len(); queued > 0 {
+		logger.Info("loaded-queued-events", lager.Data{"count": queued})
+	}
+
+	return &WebhookNotifier{
+		logger: logger,
+		opts:   opts,
+		queue:  queue,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify queues event for delivery, persisting it first when a QueueDir is
+// configured.
+func (n *WebhookNotifier) Notify(event Event) error {
+	payload := WebhookPayload{
+		DeliveryID: newDeliveryID(),
+		Timestamp:  time.Now().UTC(),
+		Type:       event.Type,
+		Kind:       event.Kind,
+		ID:         event.ID,
+		VersionID:  event.VersionID,
+	}
+	if event.Type != EventDelete {
+		switch event.Kind {
+		case InstanceRecord:
+			payload.Instance = event.Instance.LogSafe()
+		case BindingRecord:
+			payload.Binding = LogSafeBindDetails(event.Binding)
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := n.queue.push(n.opts.MaxQueueSize, payload.DeliveryID, body); err != nil {
+		n.logger.Error("failed-to-queue-event", err, recordData(event.Kind, event.ID))
+		return err
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Forward queues every event received from events, e.g. from a Watch, until
+// the channel is closed. It keeps going past events that cannot be queued,
+// which Notify logs, and returns the first error.
+func (n *WebhookNotifier) Forward(events <-chan Event) error {
+	var firstErr error
+	for event := range events {
+		if err := n.Notify(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Pending returns how many events are waiting to be delivered
+func (n *WebhookNotifier) Pending() int {
+	return n.queue.len()
+}
+
+// Dropped returns how many events Run gave up on as undeliverable
+func (n *WebhookNotifier) Dropped() int {
+	return int(n.dropped.Load())
+}
+
+// Run delivers queued events until ctx is done. Deliveries are retried until
+// they succeed, so a long receiver outage fills the queue rather than losing
+// events. An event the receiver rejects with a client error other than 408
+// or 429 is never retried: it is logged and dropped, or moved to the
+// dead-letter directory under QueueDir, so that it does not hold up the
+// events behind it.
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	backoff := n.opts.InitialBackoff
+
+	for {
+		delivery, ok := n.queue.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.wake:
+				continue
+			}
+		}
+
+		err := n.deliver(ctx, delivery)
+		if err == nil {
+			if err := n.queue.pop(delivery); err != nil {
+				n.logger.Error("failed-to-remove-delivered-event", err, lager.Data{"delivery-id": delivery.id})
+			}
+			backoff = n.opts.InitialBackoff
+			continue
+		}
+
+		var statusErr *WebhookStatusError
+		if errors.As(err, &statusErr) && !statusErr.Retryable() {
+			n.logger.Error("dropped-undeliverable-event", err, lager.Data{"delivery-id": delivery.id})
+			if err := n.queue.deadLetter(delivery); err != nil {
+				n.logger.Error("failed-to-dead-letter-event", err, lager.Data{"delivery-id": delivery.id})
+			}
+			n.dropped.Add(1)
+			backoff = n.opts.InitialBackoff
+			continue
+		}
+
+		n.logger.Error("failed-to-deliver-event", err, lager.Data{"delivery-id": delivery.id, "retry-in": backoff.String()})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, n.opts.MaxBackoff)
+	}
+}
+
+func (n *WebhookNotifier) deliver(ctx context.Context, delivery queuedDelivery) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.opts.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookDeliveryHeader, delivery.id)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(n.opts.Secret, timestamp, delivery.body))
+
+	response, err := n.opts.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &WebhookStatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+	return nil
+}
+
+// SignWebhook returns the signature header value for a payload sent at
+// timestamp, in unix seconds.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s.", timestamp)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a received payload's signature, and that its
+// timestamp is within tolerance of now.
+func VerifyWebhookSignature(secret []byte, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(sentAt, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type queuedDelivery struct {
+	id   string
+	body []byte
+	file string
+}
+
+// webhookQueue holds undelivered payloads in order, mirrored to one file per
+// payload in dir when dir is set. File names sort in queue order.
+type webhookQueue struct {
+	mutex      sync.Mutex
+	dir        string
+	next       uint64
+	deliveries []queuedDelivery
+}
+
+func loadWebhookQueue(dir string) (*webhookQueue, error) {
+	queue := &webhookQueue{dir: dir}
+	if dir == "" {
+		return queue, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sequence, id, ok := strings.Cut(strings.TrimSuffix(name, ".json"), "-")
+		next, err := strconv.ParseUint(sequence, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		queue.deliveries = append(queue.deliveries, queuedDelivery{id: id, body: body, file: name})
+		queue.next = next + 1
+	}
+	return queue, nil
+}
+
+func (q *webhookQueue) push(maxSize int, id string, body []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.deliveries) >= maxSize {
+		return ErrWebhookQueueFull
+	}
+
+	delivery := queuedDelivery{id: id, body: body}
+	if q.dir != "" {
+		delivery.file = fmt.Sprintf("%020d-%s.json", q.next, id)
+		if err := writeFileAtomically(filepath.Join(q.dir, delivery.file), body); err != nil {
+			return err
+		}
+	}
+	q.next++
+	q.deliveries = append(q.deliveries, delivery)
+	return nil
+}
+
+func (q *webhookQueue) peek() (queuedDelivery, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.deliveries) == 0 {
+		return queuedDelivery{}, false
+	}
+	return q.deliveries[0], true
+}
+
+func (q *webhookQueue) pop(delivery queuedDelivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.deliveries = q.deliveries[1:]
+	if q.dir == "" {
+		return nil
+	}
+	return os.Remove(filepath.Join(q.dir, delivery.file))
+}
+
+// deadLetter removes delivery from the queue, keeping its file in the
+// dead-letter directory.
+func (q *webhookQueue) deadLetter(delivery queuedDelivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.deliveries = q.deliveries[1:]
+	if q.dir == "" {
+		return nil
+	}
+	deadLetters := filepath.Join(q.dir, webhookDeadLetterDir)
+	if err := os.MkdirAll(deadLetters, 0700); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(q.dir, delivery.file), filepath.Join(deadLetters, delivery.file))
+}
+
+func (q *webhookQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.deliveries)
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
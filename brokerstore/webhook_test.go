@@ -0,0 +1,254 @@
+package brokerstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/brokerapi/v13/domain"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "code.cloudfoundry.org/service-broker-store/brokerstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+type receivedWebhook struct {
+	header  http.Header
+	body    []byte
+	payload WebhookPayload
+}
+
+var _ = Describe("WebhookNotifier", func() {
+	var (
+		logger   *lagertest.TestLogger
+		secret   []byte
+		mutex    sync.Mutex
+		received []receivedWebhook
+		failures int
+		status   int
+		receiver *httptest.Server
+		opts     WebhookOptions
+		notifier *WebhookNotifier
+		ctx      context.Context
+		cancel   context.CancelFunc
+	)
+
+	receivedSoFar := func() []receivedWebhook {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]receivedWebhook(nil), received...)
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("webhook")
+		secret = []byte("some-secret")
+		received = nil
+		failures = 0
+		status = http.StatusServiceUnavailable
+
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if failures > 0 {
+				failures--
+				w.WriteHeader(status)
+				return
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			webhook := receivedWebhook{header: r.Header, body: body}
+			json.Unmarshal(body, &webhook.payload)
+			received = append(received, webhook)
+		}))
+
+		opts = WebhookOptions{
+			URL:            receiver.URL,
+			Secret:         secret,
+			InitialBackoff: 5 * time.Millisecond,
+			MaxBackoff:     20 * time.Millisecond,
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		notifier, err = NewWebhookNotifier(logger, opts)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		receiver.Close()
+	})
+
+	It("should POST signed, redacted payloads in order", func() {
+		go notifier.Run(ctx)
+
+		Expect(notifier.Notify(Event{
+			Type:     EventCreate,
+			Kind:     InstanceRecord,
+			ID:       "instance-1",
+			Instance: ServiceInstance{OrganizationGUID: "org-guid", ServiceFingerPrint: map[string]interface{}{"password": "secret"}},
+		})).To(Succeed())
+		Expect(notifier.Notify(Event{
+			Type:    EventCreate,
+			Kind:    BindingRecord,
+			ID:      "binding-1",
+			Binding: domain.BindDetails{AppGUID: "app-guid", RawParameters: json.RawMessage(`{"token":"secret"}`)},
+		})).To(Succeed())
+		Expect(notifier.Notify(Event{Type: EventDelete, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+
+		Eventually(receivedSoFar).Should(HaveLen(3))
+		webhooks := receivedSoFar()
+
+		Expect(webhooks[0].payload.Type).To(Equal(EventCreate))
+		Expect(webhooks[0].payload.Kind).To(Equal(InstanceRecord))
+		Expect(webhooks[0].payload.ID).To(Equal("instance-1"))
+		Expect(webhooks[0].payload.Instance).To(HaveKeyWithValue("organization_guid", "org-guid"))
+		Expect(webhooks[1].payload.ID).To(Equal("binding-1"))
+		Expect(webhooks[1].payload.Binding).To(HaveKeyWithValue("app_guid", "app-guid"))
+		Expect(webhooks[2].payload.Type).To(Equal(EventDelete))
+		Expect(webhooks[2].payload.Instance).To(BeNil())
+
+		for _, webhook := range webhooks {
+			Expect(string(webhook.body)).NotTo(ContainSubstring("secret"))
+			Expect(webhook.header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(webhook.header.Get(WebhookDeliveryHeader)).To(Equal(webhook.payload.DeliveryID))
+			Expect(VerifyWebhookSignature(secret, webhook.header.Get(WebhookTimestampHeader), webhook.body, webhook.header.Get(WebhookSignatureHeader), time.Minute)).To(BeTrue())
+			Expect(VerifyWebhookSignature([]byte("wrong-secret"), webhook.header.Get(WebhookTimestampHeader), webhook.body, webhook.header.Get(WebhookSignatureHeader), time.Minute)).To(BeFalse())
+		}
+		Expect(notifier.Pending()).To(Equal(0))
+	})
+
+	It("should retry failed deliveries with backoff", func() {
+		mutex.Lock()
+		failures = 3
+		mutex.Unlock()
+
+		go notifier.Run(ctx)
+		Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+
+		Eventually(receivedSoFar).Should(HaveLen(1))
+		Expect(logger).To(gbytes.Say("failed-to-deliver-event"))
+	})
+
+	It("should retry deliveries the receiver throttles", func() {
+		mutex.Lock()
+		failures, status = 2, http.StatusTooManyRequests
+		mutex.Unlock()
+
+		go notifier.Run(ctx)
+		Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+
+		Eventually(receivedSoFar).Should(HaveLen(1))
+		Expect(notifier.Dropped()).To(Equal(0))
+	})
+
+	It("should drop events the receiver rejects, without holding up the rest", func() {
+		mutex.Lock()
+		failures, status = 1, http.StatusBadRequest
+		mutex.Unlock()
+
+		go notifier.Run(ctx)
+		Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+		Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-2"})).To(Succeed())
+
+		Eventually(receivedSoFar).Should(HaveLen(1))
+		Expect(receivedSoFar()[0].payload.ID).To(Equal("instance-2"))
+		Expect(notifier.Dropped()).To(Equal(1))
+		Expect(logger).To(gbytes.Say("dropped-undeliverable-event"))
+	})
+
+	It("should reject stale signatures", func() {
+		timestamp := "1000000000"
+		body := []byte(`{}`)
+		Expect(VerifyWebhookSignature(secret, timestamp, body, SignWebhook(secret, timestamp, body), time.Minute)).To(BeFalse())
+	})
+
+	Context("with a queue size limit", func() {
+		BeforeEach(func() {
+			opts.MaxQueueSize = 1
+		})
+
+		It("should refuse events beyond it", func() {
+			Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+			Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-2"})).To(MatchError(ErrWebhookQueueFull))
+		})
+	})
+
+	Context("with an on-disk queue", func() {
+		BeforeEach(func() {
+			var err error
+			opts.QueueDir, err = os.MkdirTemp("", "webhook-queue")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, opts.QueueDir)
+		})
+
+		It("should deliver events queued before a restart", func() {
+			Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+			Expect(notifier.Notify(Event{Type: EventDelete, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+			Expect(os.ReadDir(opts.QueueDir)).To(HaveLen(2))
+
+			restarted, err := NewWebhookNotifier(logger, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restarted.Pending()).To(Equal(2))
+			go restarted.Run(ctx)
+
+			Eventually(receivedSoFar).Should(HaveLen(2))
+			webhooks := receivedSoFar()
+			Expect(webhooks[0].payload.Type).To(Equal(EventCreate))
+			Expect(webhooks[1].payload.Type).To(Equal(EventDelete))
+			Eventually(func() ([]os.DirEntry, error) { return os.ReadDir(opts.QueueDir) }).Should(BeEmpty())
+		})
+
+		It("should keep rejected events in the dead-letter directory", func() {
+			mutex.Lock()
+			failures, status = 1, http.StatusGone
+			mutex.Unlock()
+
+			go notifier.Run(ctx)
+			Expect(notifier.Notify(Event{Type: EventCreate, Kind: InstanceRecord, ID: "instance-1"})).To(Succeed())
+
+			deadLetters := filepath.Join(opts.QueueDir, "dead-letter")
+			Eventually(func() ([]os.DirEntry, error) { return os.ReadDir(deadLetters) }).Should(HaveLen(1))
+			Expect(notifier.Pending()).To(Equal(0))
+
+			restarted, err := NewWebhookNotifier(logger, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restarted.Pending()).To(Equal(0))
+		})
+	})
+
+	It("should forward events from a watch", func() {
+		events := make(chan Event, 1)
+		events <- Event{Type: EventCreate, Kind: BindingRecord, ID: "binding-1"}
+		close(events)
+
+		Expect(notifier.Forward(events)).To(Succeed())
+		Expect(notifier.Pending()).To(Equal(1))
+	})
+
+	Context("when forwarded events cannot be queued", func() {
+		BeforeEach(func() {
+			opts.MaxQueueSize = 1
+		})
+
+		It("should queue the rest and return the error", func() {
+			events := make(chan Event, 2)
+			events <- Event{Type: EventCreate, Kind: BindingRecord, ID: "binding-1"}
+			events <- Event{Type: EventCreate, Kind: BindingRecord, ID: "binding-2"}
+			close(events)
+
+			Expect(notifier.Forward(events)).To(MatchError(ErrWebhookQueueFull))
+			Expect(notifier.Pending()).To(Equal(1))
+		})
+	})
+})